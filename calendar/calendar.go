@@ -0,0 +1,201 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"loan/models"
+	"strconv"
+	"strings"
+	"time"
+
+	// Embed the zone database so timezones resolve on hosts without one
+	_ "time/tzdata"
+)
+
+const dateLayout = "2006-01-02"
+
+// How far ahead the next opening or business day is searched for. A branch
+// that is not open within a year is treated as closed for good.
+const searchHorizonDays = 366
+
+var ErrNeverOpen = errors.New("branch is not open within the next year")
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// period is an opening in minutes since local midnight, close exclusive
+type period struct {
+	open, close int
+}
+
+// Calendar answers opening questions for one branch office from its weekly
+// hours and the holidays that apply to it
+type Calendar struct {
+	loc      *time.Location
+	weekly   [7][]period
+	holidays map[string]string // date -> name
+}
+
+// New validates hours and builds a calendar from them. Holidays may be nil.
+func New(hours models.OpeningHours, holidays []models.Holiday) (*Calendar, error) {
+	loc, err := time.LoadLocation(hours.Timezone)
+	if err != nil || hours.Timezone == "" {
+		return nil, fmt.Errorf("unknown timezone %q", hours.Timezone)
+	}
+
+	cal := &Calendar{loc: loc, holidays: make(map[string]string, len(holidays))}
+	for _, p := range hours.Weekly {
+		day, ok := weekdays[strings.ToLower(p.Day)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", p.Day)
+		}
+		open, err := parseClock(p.Open)
+		if err != nil {
+			return nil, err
+		}
+		closing, err := parseClock(p.Close)
+		if err != nil {
+			return nil, err
+		}
+		if closing <= open {
+			return nil, fmt.Errorf("%s: close %s is not after open %s", p.Day, p.Close, p.Open)
+		}
+		for _, existing := range cal.weekly[day] {
+			if open < existing.close && existing.open < closing {
+				return nil, fmt.Errorf("%s: opening periods overlap", p.Day)
+			}
+		}
+		cal.weekly[day] = append(cal.weekly[day], period{open: open, close: closing})
+	}
+
+	for _, h := range holidays {
+		cal.holidays[h.Date] = h.Name
+	}
+	return cal, nil
+}
+
+// parseClock turns "15:04" into minutes since midnight. "24:00" is allowed
+// as a closing time.
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 || len(parts[0]) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	hour, errHour := strconv.Atoi(parts[0])
+	minute, errMinute := strconv.Atoi(parts[1])
+	if errHour != nil || errMinute != nil || minute < 0 || minute > 59 || hour < 0 ||
+		hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// Location is the branch's timezone
+func (c *Calendar) Location() *time.Location {
+	return c.loc
+}
+
+// Holiday returns the name of the holiday on t's local date, if any
+func (c *Calendar) Holiday(t time.Time) (string, bool) {
+	name, ok := c.holidays[t.In(c.loc).Format(dateLayout)]
+	return name, ok
+}
+
+// IsBusinessDay reports whether the branch opens at all on t's local date
+func (c *Calendar) IsBusinessDay(t time.Time) bool {
+	t = t.In(c.loc)
+	if _, holiday := c.holidays[t.Format(dateLayout)]; holiday {
+		return false
+	}
+	return len(c.weekly[t.Weekday()]) > 0
+}
+
+// IsOpen reports whether the branch is open at t
+func (c *Calendar) IsOpen(t time.Time) bool {
+	t = t.In(c.loc)
+	if !c.IsBusinessDay(t) {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, p := range c.weekly[t.Weekday()] {
+		if minute >= p.open && minute < p.close {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns the first time at or after t when the branch is open
+func (c *Calendar) NextOpen(t time.Time) (time.Time, error) {
+	if c.IsOpen(t) {
+		return t, nil
+	}
+	t = t.In(c.loc)
+	day := startOfDay(t)
+	minute := t.Hour()*60 + t.Minute()
+
+	for i := 0; i < searchHorizonDays; i++ {
+		if c.IsBusinessDay(day) {
+			best := -1
+			for _, p := range c.weekly[day.Weekday()] {
+				if p.open > minute && (best < 0 || p.open < best) {
+					best = p.open
+				}
+			}
+			if best >= 0 {
+				return atMinute(day, best), nil
+			}
+		}
+		// Every period on later days counts
+		day = day.AddDate(0, 0, 1)
+		minute = -1
+	}
+	return time.Time{}, ErrNeverOpen
+}
+
+// NextBusinessDay returns the start of the first business day after t's
+// local date
+func (c *Calendar) NextBusinessDay(t time.Time) (time.Time, error) {
+	return c.AddBusinessDays(t, 1)
+}
+
+// AddBusinessDays moves forward n business days from t's local date and
+// returns the start of that day. With n of 0 it returns t's date if that is
+// a business day, otherwise the next one. Due dates that must land on a
+// business day are computed with this.
+func (c *Calendar) AddBusinessDays(t time.Time, n int) (time.Time, error) {
+	day := startOfDay(t.In(c.loc))
+	if n == 0 && c.IsBusinessDay(day) {
+		return day, nil
+	}
+	if n == 0 {
+		n = 1
+	}
+
+	limit := searchHorizonDays * n
+	for i := 0; i < limit; i++ {
+		day = day.AddDate(0, 0, 1)
+		if c.IsBusinessDay(day) {
+			n--
+			if n == 0 {
+				return day, nil
+			}
+		}
+	}
+	return time.Time{}, ErrNeverOpen
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func atMinute(day time.Time, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minute/60, minute%60, 0, 0, day.Location())
+}
@@ -0,0 +1,272 @@
+package calendar
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An event longer than this is assumed to be a mistake rather than a
+// holiday
+const maxEventDays = 31
+
+// Event is an all-day closure read from an iCalendar file, expanded into
+// the local dates it covers
+type Event struct {
+	UID     string
+	Summary string
+	Dates   []string // 2006-01-02
+}
+
+// ParseICS reads the VEVENTs of an iCalendar (RFC 5545) file as holidays.
+// Times are ignored; an event closes every date it touches. Cancelled
+// events are skipped. The only recurrence understood is FREQ=YEARLY, which
+// is expanded up to until; any other RRULE is rejected.
+func ParseICS(r io.Reader, until time.Time) ([]Event, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var current map[string]icsProperty
+	for n, line := range lines {
+		switch {
+		case line == "BEGIN:VEVENT":
+			current = make(map[string]icsProperty)
+		case line == "END:VEVENT":
+			if current == nil {
+				return nil, fmt.Errorf("line %d: END:VEVENT without BEGIN", n+1)
+			}
+			event, ok, err := buildEvent(current, until)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				events = append(events, event)
+			}
+			current = nil
+		case current != nil:
+			prop, ok := parseProperty(line)
+			if ok {
+				current[prop.name] = prop
+			}
+		}
+	}
+	if current != nil {
+		return nil, errors.New("unterminated VEVENT")
+	}
+	return events, nil
+}
+
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfoldLines joins continuation lines, which start with a space or tab
+func unfoldLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// parseProperty splits NAME;PARAM=VALUE:value. Quoted parameter values
+// may contain colons.
+func parseProperty(line string) (icsProperty, bool) {
+	inQuotes := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icsProperty{}, false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	prop := icsProperty{
+		name:   strings.ToUpper(parts[0]),
+		params: make(map[string]string),
+		value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		if key, value, ok := strings.Cut(param, "="); ok {
+			prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return prop, true
+}
+
+func buildEvent(props map[string]icsProperty, until time.Time) (Event, bool, error) {
+	if status, ok := props["STATUS"]; ok && strings.EqualFold(status.value, "CANCELLED") {
+		return Event{}, false, nil
+	}
+
+	event := Event{
+		UID:     props["UID"].value,
+		Summary: unescapeText(props["SUMMARY"].value),
+	}
+	name := event.Summary
+	if name == "" {
+		name = event.UID
+	}
+
+	startProp, ok := props["DTSTART"]
+	if !ok {
+		return Event{}, false, fmt.Errorf("event %q has no DTSTART", name)
+	}
+	start, startIsDate, err := parseICSTime(startProp)
+	if err != nil {
+		return Event{}, false, fmt.Errorf("event %q: %v", name, err)
+	}
+
+	// DTEND is exclusive. Without one an all-day event lasts a day.
+	days := 1
+	if endProp, ok := props["DTEND"]; ok {
+		end, endIsDate, err := parseICSTime(endProp)
+		if err != nil {
+			return Event{}, false, fmt.Errorf("event %q: %v", name, err)
+		}
+		days = daysBetween(start, end)
+		if !endIsDate && !startIsDate && (end.Hour() != 0 || end.Minute() != 0) {
+			days++
+		}
+		if days < 1 {
+			days = 1
+		}
+	}
+	if days > maxEventDays {
+		return Event{}, false, fmt.Errorf("event %q lasts %d days, more than %d", name, days, maxEventDays)
+	}
+
+	starts := []time.Time{start}
+	if rule, ok := props["RRULE"]; ok {
+		starts, err = expandYearly(start, rule.value, until)
+		if err != nil {
+			return Event{}, false, fmt.Errorf("event %q: %v", name, err)
+		}
+	}
+
+	for _, s := range starts {
+		for d := 0; d < days; d++ {
+			event.Dates = append(event.Dates, s.AddDate(0, 0, d).Format(dateLayout))
+		}
+	}
+	return event, true, nil
+}
+
+// parseICSTime reads a DATE or DATE-TIME value. Only the calendar date is
+// kept; the second result reports whether the value was a plain DATE.
+func parseICSTime(prop icsProperty) (time.Time, bool, error) {
+	value := prop.value
+	if prop.params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.Parse("20060102", value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid date %q", value)
+		}
+		return t, true, nil
+	}
+
+	t, err := time.Parse("20060102T150405", strings.TrimSuffix(value, "Z"))
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date-time %q", value)
+	}
+	return t, false, nil
+}
+
+func daysBetween(start, end time.Time) int {
+	s := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	e := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	return int(e.Sub(s).Hours() / 24)
+}
+
+// expandYearly lists the start dates of a FREQ=YEARLY rule, honouring
+// INTERVAL, COUNT and UNTIL, and stopping at until
+func expandYearly(start time.Time, rule string, until time.Time) ([]time.Time, error) {
+	parts := make(map[string]string)
+	for _, part := range strings.Split(rule, ";") {
+		if key, value, ok := strings.Cut(part, "="); ok {
+			parts[strings.ToUpper(key)] = value
+		}
+	}
+
+	for key := range parts {
+		switch key {
+		case "FREQ", "INTERVAL", "COUNT", "UNTIL":
+		default:
+			return nil, fmt.Errorf("unsupported RRULE part %s", key)
+		}
+	}
+	if parts["FREQ"] != "YEARLY" {
+		return nil, fmt.Errorf("unsupported RRULE frequency %q, only YEARLY is supported", parts["FREQ"])
+	}
+
+	interval := 1
+	if value, ok := parts["INTERVAL"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid RRULE INTERVAL %q", value)
+		}
+		interval = n
+	}
+	count := -1
+	if value, ok := parts["COUNT"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid RRULE COUNT %q", value)
+		}
+		count = n
+	}
+	if value, ok := parts["UNTIL"]; ok {
+		t, _, err := parseICSTime(icsProperty{value: value, params: map[string]string{}})
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE UNTIL %q", value)
+		}
+		if t.Before(until) {
+			until = t
+		}
+	}
+
+	var starts []time.Time
+	for year := 0; count != 0; year += interval {
+		next := start.AddDate(year, 0, 0)
+		if next.After(until) {
+			break
+		}
+		// Feb 29 only recurs in leap years
+		if next.Day() != start.Day() {
+			continue
+		}
+		starts = append(starts, next)
+		count--
+	}
+	return starts, nil
+}
+
+var textUnescaper = strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func unescapeText(value string) string {
+	return strings.TrimSpace(textUnescaper.Replace(value))
+}
@@ -0,0 +1,77 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"loan/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrBranchNotFound = errors.New("branch office not found")
+	ErrNoOpeningHours = errors.New("branch office has no opening hours")
+)
+
+// Service loads branch calendars from Mongo. It is the entry point for
+// other parts of the system, such as due-date calculation, that need to
+// know when a branch is open.
+type Service struct {
+	db *mongo.Database
+}
+
+func NewService(db *mongo.Database) *Service {
+	return &Service{db: db}
+}
+
+// ForBranch builds the calendar of a branch office from its opening hours,
+// its own holidays and its company's holidays
+func (s *Service) ForBranch(ctx context.Context, companyID, branchID string) (*Calendar, error) {
+	var branchOffice models.BranchOffice
+	err := s.db.Collection("branch_offices").FindOne(ctx, bson.M{
+		"_id":        branchID,
+		"company_id": companyID,
+	}).Decode(&branchOffice)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrBranchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if branchOffice.OpeningHours == nil {
+		return nil, ErrNoOpeningHours
+	}
+
+	holidays, err := s.Holidays(ctx, companyID, branchID)
+	if err != nil {
+		return nil, err
+	}
+	return New(*branchOffice.OpeningHours, holidays)
+}
+
+// Holidays lists the holidays that apply to a branch office: its own and
+// its company's, ordered by date. With an empty branchID only the
+// company's are listed.
+func (s *Service) Holidays(ctx context.Context, companyID, branchID string) ([]models.Holiday, error) {
+	branchIDs := bson.A{""}
+	if branchID != "" {
+		branchIDs = append(branchIDs, branchID)
+	}
+
+	cursor, err := s.db.Collection("holidays").Find(ctx, bson.M{
+		"company_id": companyID,
+		"branch_id":  bson.M{"$in": branchIDs},
+	}, options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "branch_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	holidays := []models.Holiday{}
+	if err := cursor.All(ctx, &holidays); err != nil {
+		return nil, err
+	}
+	return holidays, nil
+}
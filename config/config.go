@@ -2,8 +2,12 @@ package config
 
 import (
 	"context"
+	"loan/migrations"
+	"loan/storage"
+	"loan/utils"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -13,8 +17,16 @@ import (
 
 type Config struct {
 	MongoDB *mongo.Database
+	Blobs   storage.BlobStore
+
+	// Allowed values of Company.BusinessType
+	BusinessTypes []string
+	// Country assumed for companies created before country was recorded
+	DefaultCountry string
 }
 
+const defaultBusinessTypes = "retail,wholesale,manufacturing,services,agriculture,construction,transport,hospitality,healthcare,technology,finance,other"
+
 func LoadConfig() *Config {
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Error loading .env file: %v", err)
@@ -38,7 +50,49 @@ func LoadConfig() *Config {
 		log.Fatalf("Error connecting to MongoDB: %v", err)
 	}
 
+	businessTypes := strings.Split(getEnvOrDefault("BUSINESS_TYPES", defaultBusinessTypes), ",")
+	for i := range businessTypes {
+		businessTypes[i] = strings.TrimSpace(businessTypes[i])
+	}
+	defaultCountry := strings.ToUpper(getEnvOrDefault("DEFAULT_COUNTRY", "US"))
+
+	if err := utils.RegisterValidators(businessTypes); err != nil {
+		log.Fatalf("Error registering validators: %v", err)
+	}
+
+	db := client.Database(dbName)
+
+	migrationCtx, cancelMigrations := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancelMigrations()
+	if err := migrations.Run(migrationCtx, db, migrations.Env{
+		BusinessTypes:  businessTypes,
+		DefaultCountry: defaultCountry,
+	}); err != nil {
+		log.Fatalf("Error running migrations: %v", err)
+	}
+	EnsureIndexes(db)
+
 	return &Config{
-		MongoDB: client.Database(dbName),
+		MongoDB:        db,
+		Blobs:          loadBlobStore(),
+		BusinessTypes:  businessTypes,
+		DefaultCountry: defaultCountry,
+	}
+}
+
+// loadBlobStore picks the file storage backend from STORAGE_DRIVER. Only
+// "local" ships today; other backends implement storage.BlobStore.
+func loadBlobStore() storage.BlobStore {
+	driver := getEnvOrDefault("STORAGE_DRIVER", "local")
+	switch driver {
+	case "local":
+		store, err := storage.NewLocalStore(getEnvOrDefault("STORAGE_LOCAL_DIR", "./data/blobs"))
+		if err != nil {
+			log.Fatalf("Error initializing local storage: %v", err)
+		}
+		return store
 	}
+
+	log.Fatalf("Unknown storage driver: %s", driver)
+	return nil
 }
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionIndexes lists the indexes each collection needs. Creating an
+// index that already exists is a no-op, so this is safe to run on startup.
+var collectionIndexes = map[string][]mongo.IndexModel{
+	"companies": {
+		// Keyset pagination walks (created_at, _id) in descending order
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		// Walks of company groups follow parent_company_id
+		{Keys: bson.D{{Key: "parent_company_id", Value: 1}}},
+		// Companies without a tax ID are not constrained
+		{
+			Keys: bson.D{{Key: "country", Value: 1}, {Key: "tax_id", Value: 1}},
+			Options: options.Index().SetName("unique_tax_id").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"tax_id": bson.M{"$gt": ""}}),
+		},
+		// Text indexes back the search package
+		{
+			Keys: bson.D{{Key: "name", Value: "text"}, {Key: "tax_id", Value: "text"}, {Key: "email", Value: "text"}},
+			Options: options.Index().SetName("search_text").
+				SetWeights(bson.D{{Key: "name", Value: 10}, {Key: "tax_id", Value: 5}, {Key: "email", Value: 2}}),
+		},
+	},
+	"branch_offices": {
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{
+			Keys: bson.D{{Key: "name", Value: "text"}, {Key: "address", Value: "text"}, {Key: "phone", Value: "text"}},
+			Options: options.Index().SetName("search_text").
+				SetWeights(bson.D{{Key: "name", Value: 10}, {Key: "address", Value: 5}, {Key: "phone", Value: 2}}),
+		},
+		// Backs the nearby and map queries; branches without a location are
+		// left out of it
+		{Keys: bson.D{{Key: "location", Value: "2dsphere"}, {Key: "company_id", Value: 1}}},
+	},
+	"users": {
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "branch_roles.branch_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "memberships.company_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys: bson.D{{Key: "username", Value: "text"}, {Key: "full_name", Value: "text"}},
+			Options: options.Index().SetName("search_text").
+				SetWeights(bson.D{{Key: "full_name", Value: 10}, {Key: "username", Value: 5}}),
+		},
+	},
+	// Assignment periods: a user's timeline, a branch's staff on a date and
+	// the scheduler's lookups of periods due to start or end
+	"staff_assignments": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "effective_from", Value: -1}}},
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "branch_id", Value: 1}, {Key: "effective_from", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "effective_from", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "effective_to", Value: 1}}},
+	},
+	"staff_transfers": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "transferred_at", Value: -1}}},
+	},
+	// National IDs are unique among a company's customers; business
+	// customers have none
+	"customers": {
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "branch_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{
+			Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "national_id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"national_id": bson.M{"$gt": ""}}),
+		},
+	},
+	"loan_products": {
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	// Versions are immutable and numbered once per product
+	"loan_product_versions": {
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "version", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	},
+	"loans": {
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "branch_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "branch_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "customer_id", Value: 1}}},
+		// Staff transfers hand over an officer's loans at a branch
+		{Keys: bson.D{{Key: "officer_id", Value: 1}, {Key: "branch_id", Value: 1}}},
+	},
+	// One account per loan
+	"loan_accounts": {
+		{Keys: bson.D{{Key: "loan_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "branch_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "installments.due_date", Value: 1}}},
+	},
+	// Receipt numbers are unique within a company
+	"repayments": {
+		{Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "company_id", Value: 1}, {Key: "receipt_number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	},
+	"credit_allocations": {
+		{Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+	},
+	"company_documents": {
+		{Keys: bson.D{{Key: "company_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expiry_date", Value: 1}}},
+	},
+	// One holiday per date for a company (branch_id "") or a branch
+	"holidays": {
+		{
+			Keys:    bson.D{{Key: "company_id", Value: 1}, {Key: "branch_id", Value: 1}, {Key: "date", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	},
+	"media": {
+		{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "owner_id", Value: 1}}},
+	},
+	"change_logs": {
+		{Keys: bson.D{{Key: "entity_type", Value: 1}, {Key: "entity_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"job_runs": {
+		{Keys: bson.D{{Key: "job", Value: 1}, {Key: "started_at", Value: -1}}},
+	},
+	"audit_logs": {
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+}
+
+// EnsureIndexes creates the indexes listed in collectionIndexes. Each is
+// created on its own so one failure, such as a unique index over existing
+// duplicates, does not hold back the rest.
+func EnsureIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for collection, indexes := range collectionIndexes {
+		for _, index := range indexes {
+			if _, err := db.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+				log.Printf("Error creating index on %s: %v", collection, err)
+			}
+		}
+	}
+}
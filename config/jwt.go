@@ -12,15 +12,19 @@ var (
 )
 
 type JWTClaim struct {
-	UserID string        `json:"user_id"`
-	Roles  []models.Role `json:"roles"`
+	UserID    string        `json:"user_id"`
+	Roles     []models.Role `json:"roles"`
+	CompanyID string        `json:"company_id,omitempty"` // Company the roles apply in
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID string, roles []models.Role) (string, error) {
+// GenerateToken issues a token for a user acting in companyID with the
+// roles they hold there. companyID is empty for users without a company.
+func GenerateToken(userID string, roles []models.Role, companyID string) (string, error) {
 	claims := JWTClaim{
-		UserID: userID,
-		Roles:  roles,
+		UserID:    userID,
+		Roles:     roles,
+		CompanyID: companyID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"loan/config"
+	"loan/models"
+	"loan/staffing"
+	"loan/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AssignmentController serves the effective-dated history of staff
+// assignments and lets cover be scheduled ahead of time
+type AssignmentController struct {
+	config   *config.Config
+	staffing *staffing.Service
+}
+
+func NewAssignmentController(config *config.Config) *AssignmentController {
+	return &AssignmentController{
+		config:   config,
+		staffing: staffing.NewService(config.MongoDB),
+	}
+}
+
+// ScheduleAssignment gives a staff member a role at a branch office from
+// effective_from, and until effective_to when it is set. The scheduler
+// applies it when it starts and, for temporary cover, gives the staff
+// member's previous role back when it ends.
+func (ac *AssignmentController) ScheduleAssignment(c *gin.Context) {
+	companyID := c.Param("id")
+	branchID := c.Param("branch_id")
+	if !requireCompanyAccess(c, ac.config, companyID) {
+		return
+	}
+
+	var req models.ScheduleAssignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	if _, ok := ac.findBranch(c, companyID, branchID); !ok {
+		return
+	}
+
+	period, err := ac.staffing.Schedule(c, companyID, branchID, req, c.GetString("user_id"))
+	switch err {
+	case nil:
+		c.JSON(http.StatusCreated, period)
+	case staffing.ErrNotStaff, staffing.ErrInvalidPeriod:
+		utils.BadRequest(c, err.Error())
+	case staffing.ErrOverlap:
+		utils.HandleError(c, http.StatusConflict, err.Error())
+	default:
+		utils.InternalError(c, "Error scheduling assignment")
+	}
+}
+
+// CancelAssignment withdraws an assignment that has not started yet, or
+// ends one that is running now
+func (ac *AssignmentController) CancelAssignment(c *gin.Context) {
+	if !requireCompanyAccess(c, ac.config, c.Param("id")) {
+		return
+	}
+
+	period, err := ac.staffing.Cancel(c, c.Param("id"), c.Param("branch_id"), c.Param("assignment_id"),
+		c.GetString("user_id"))
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, period)
+	case staffing.ErrAssignmentNotFound:
+		utils.BadRequest(c, "Assignment not found")
+	case staffing.ErrAssignmentFinished:
+		utils.HandleError(c, http.StatusConflict, err.Error())
+	default:
+		utils.InternalError(c, "Error cancelling assignment")
+	}
+}
+
+// ListUserAssignments returns a user's assignment timeline, latest first,
+// optionally narrowed by ?status= and to the periods overlapping ?from= and
+// ?to=
+func (ac *AssignmentController) ListUserAssignments(c *gin.Context) {
+	companyIDs, ok := callerCompanyIDs(c, ac.config)
+	if !ok {
+		return
+	}
+
+	page, limit := utils.GetPaginationParams(c)
+	skip := (page - 1) * limit
+
+	filter := scopeFilter(bson.M{"user_id": c.Param("id")}, "company_id", companyIDs)
+
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	if fromStr := c.Query("from"); fromStr != "" {
+		from, _, err := utils.ParseDate(fromStr)
+		if err != nil {
+			utils.BadRequest(c, "Invalid from date")
+			return
+		}
+		filter["$or"] = bson.A{
+			bson.M{"effective_to": nil},
+			bson.M{"effective_to": bson.M{"$gt": from}},
+		}
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		to, dateOnly, err := utils.ParseDate(toStr)
+		if err != nil {
+			utils.BadRequest(c, "Invalid to date")
+			return
+		}
+		if dateOnly {
+			to = to.Add(24 * time.Hour)
+		}
+		filter["effective_from"] = bson.M{"$lt": to}
+	}
+
+	collection := ac.config.MongoDB.Collection("staff_assignments")
+	total, err := collection.CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error counting assignments")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "effective_from", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching assignments")
+		return
+	}
+	defer cursor.Close(c)
+
+	periods := []models.AssignmentPeriod{}
+	if err = cursor.All(c, &periods); err != nil {
+		utils.InternalError(c, "Error parsing assignments")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, periods, total, page, limit)
+}
+
+// ListBranchAssignments lists who was assigned to a branch office, and in
+// which role, at ?date= (RFC 3339 or 2006-01-02, default now). A plain date
+// covers the whole day in the branch's timezone and can be in the future,
+// in which case scheduled assignments are included.
+func (ac *AssignmentController) ListBranchAssignments(c *gin.Context) {
+	companyID := c.Param("id")
+	branchID := c.Param("branch_id")
+	if !requireCompanyAccess(c, ac.config, companyID) {
+		return
+	}
+
+	branchOffice, ok := ac.findBranch(c, companyID, branchID)
+	if !ok {
+		return
+	}
+
+	loc := time.UTC
+	if branchOffice.OpeningHours != nil {
+		if l, err := time.LoadLocation(branchOffice.OpeningHours.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	start := time.Now()
+	effectiveFrom := bson.M{"$lte": start}
+	if dateStr := c.Query("date"); dateStr != "" {
+		t, dateOnly, err := utils.ParseDate(dateStr)
+		if err != nil {
+			utils.BadRequest(c, "Invalid date")
+			return
+		}
+		start = t
+		effectiveFrom = bson.M{"$lte": start}
+		if dateOnly {
+			start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+			effectiveFrom = bson.M{"$lt": start.AddDate(0, 0, 1)}
+		}
+	}
+
+	cursor, err := ac.config.MongoDB.Collection("staff_assignments").Aggregate(c, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"company_id":     companyID,
+			"branch_id":      branchID,
+			"status":         bson.M{"$ne": models.AssignmentCancelled},
+			"effective_from": effectiveFrom,
+			"$or": bson.A{
+				bson.M{"effective_to": nil},
+				bson.M{"effective_to": bson.M{"$gt": start}},
+			},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "effective_from", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "users",
+			"localField":   "user_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"username":  bson.M{"$arrayElemAt": bson.A{"$user.username", 0}},
+			"full_name": bson.M{"$arrayElemAt": bson.A{"$user.full_name", 0}},
+		}}},
+		{{Key: "$unset", Value: "user"}},
+	})
+	if err != nil {
+		utils.InternalError(c, "Error fetching assignments")
+		return
+	}
+	defer cursor.Close(c)
+
+	periods := []models.BranchStaffPeriod{}
+	if err := cursor.All(c, &periods); err != nil {
+		utils.InternalError(c, "Error parsing assignments")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date": start.In(loc),
+		"data": periods,
+	})
+}
+
+func (ac *AssignmentController) findBranch(c *gin.Context, companyID, branchID string) (models.BranchOffice, bool) {
+	var branchOffice models.BranchOffice
+	err := ac.config.MongoDB.Collection("branch_offices").FindOne(c, bson.M{
+		"_id":        branchID,
+		"company_id": companyID,
+	}).Decode(&branchOffice)
+	if err != nil {
+		utils.BadRequest(c, "Branch office not found")
+		return branchOffice, false
+	}
+	return branchOffice, true
+}
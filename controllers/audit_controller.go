@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AuditController struct {
+	config *config.Config
+}
+
+func NewAuditController(config *config.Config) *AuditController {
+	return &AuditController{config: config}
+}
+
+// SearchAuditLogs lists audit entries matching the query filters
+func (ac *AuditController) SearchAuditLogs(c *gin.Context) {
+	filter, err := auditFilter(c)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	page, limit := utils.GetPaginationParams(c)
+	skip := (page - 1) * limit
+
+	// Get total count
+	total, err := ac.config.MongoDB.Collection("audit_logs").CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error counting audit logs")
+		return
+	}
+
+	// Set options for pagination and sorting
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := ac.config.MongoDB.Collection("audit_logs").Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching audit logs")
+		return
+	}
+	defer cursor.Close(c)
+
+	var entries []models.AuditLog
+	if err = cursor.All(c, &entries); err != nil {
+		utils.InternalError(c, "Error parsing audit logs")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, entries, total, page, limit)
+}
+
+// ExportAuditLogs streams audit entries matching the query filters as
+// newline-delimited JSON, oldest first
+func (ac *AuditController) ExportAuditLogs(c *gin.Context) {
+	filter, err := auditFilter(c)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := ac.config.MongoDB.Collection("audit_logs").Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching audit logs")
+		return
+	}
+	defer cursor.Close(c)
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", "attachment; filename=audit_logs.ndjson")
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	for cursor.Next(c) {
+		var entry models.AuditLog
+		if err := cursor.Decode(&entry); err != nil {
+			utils.Error("Error decoding audit log: " + err.Error())
+			return
+		}
+		if err := encoder.Encode(entry); err != nil {
+			// Client went away
+			return
+		}
+		c.Writer.Flush()
+	}
+	if err := cursor.Err(); err != nil {
+		utils.Error("Error streaming audit logs: " + err.Error())
+	}
+}
+
+// auditFilter builds the Mongo filter shared by search and export
+func auditFilter(c *gin.Context) (bson.M, error) {
+	filter := bson.M{}
+
+	if actorID := c.Query("actor_id"); actorID != "" {
+		filter["actor_id"] = actorID
+	}
+	if role := c.Query("role"); role != "" {
+		filter["roles"] = role
+	}
+	if method := c.Query("method"); method != "" {
+		filter["method"] = strings.ToUpper(method)
+	}
+	if route := c.Query("route"); route != "" {
+		filter["route"] = route
+	}
+	if resourceID := c.Query("resource_id"); resourceID != "" {
+		filter["resource_ids"] = resourceID
+	}
+	if clientIP := c.Query("client_ip"); clientIP != "" {
+		filter["client_ip"] = clientIP
+	}
+	if statusStr := c.Query("status"); statusStr != "" {
+		status, err := strconv.Atoi(statusStr)
+		if err != nil {
+			return nil, errors.New("Invalid status")
+		}
+		filter["status"] = status
+	}
+
+	createdAt := bson.M{}
+	if fromStr := c.Query("from"); fromStr != "" {
+		from, _, err := utils.ParseDate(fromStr)
+		if err != nil {
+			return nil, errors.New("Invalid from date")
+		}
+		createdAt["$gte"] = from
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		to, dateOnly, err := utils.ParseDate(toStr)
+		if err != nil {
+			return nil, errors.New("Invalid to date")
+		}
+		if dateOnly {
+			to = to.Add(24 * time.Hour)
+		}
+		createdAt["$lt"] = to
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	return filter, nil
+}
@@ -6,16 +6,73 @@ import (
 	"loan/utils"
 	"math"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Fields clients may filter and sort users on
+var userQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"username":               utils.FieldString,
+		"full_name":              utils.FieldString,
+		"roles":                  utils.FieldString,
+		"company_id":             utils.FieldString,
+		"branch_roles.branch_id": utils.FieldString,
+		"branch_roles.role":      utils.FieldString,
+		"created_at":             utils.FieldTime,
+		"updated_at":             utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"username":   true,
+		"full_name":  true,
+		"created_at": true,
+		"updated_at": true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
+// Fields and expansions clients may request on users
+var userShapeSpec = utils.ShapeSpec{
+	Fields: map[string]bool{
+		"id": true, "username": true, "roles": true, "full_name": true, "bio": true, "avatar": true,
+		"company_id": true, "memberships": true, "branch_roles": true, "created_at": true, "updated_at": true,
+	},
+	// A user's companies may include ones the caller cannot see, so they
+	// are summarized
+	Expansions: map[string]utils.Expansion{
+		"company": {
+			From:    "companies",
+			Let:     bson.M{"company_id": "$company_id"},
+			Match:   bson.M{"$eq": bson.A{"$_id", "$$company_id"}},
+			Project: companySummary,
+			Single:  true,
+		},
+		"companies": {
+			From:    "companies",
+			Let:     bson.M{"company_ids": "$memberships.company_id"},
+			Match:   bson.M{"$in": bson.A{"$_id", bson.M{"$ifNull": bson.A{"$$company_ids", bson.A{}}}}},
+			Project: companySummary,
+		},
+		"branches": {
+			From:  "branch_offices",
+			Let:   bson.M{"branch_ids": "$branch_roles.branch_id"},
+			Match: bson.M{"$in": bson.A{"$_id", bson.M{"$ifNull": bson.A{"$$branch_ids", bson.A{}}}}},
+		},
+	},
+	Hidden: []string{"password"},
+}
+
+// Columns written when users are exported. Password is deliberately absent.
+var userExportColumns = []string{
+	"id", "username", "full_name", "roles", "company_id", "branch_roles", "created_at", "updated_at",
+}
+
 type AuthController struct {
 	config *config.Config
 }
@@ -44,12 +101,13 @@ func (ac *AuthController) Register(c *gin.Context) {
 
 	// Create new user with string ID
 	user := models.User{
-		ID:        id,
-		Username:  req.Username,
-		Password:  req.Password,
-		Roles:     req.Roles,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:          id,
+		Username:    req.Username,
+		Password:    req.Password,
+		Roles:       req.Roles,
+		Memberships: []models.CompanyMembership{},
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
 	}
 
 	// Set default roles if none provided
@@ -68,10 +126,12 @@ func (ac *AuthController) Register(c *gin.Context) {
 		return
 	}
 
+	recordChange(c, ac.config, models.EntityUser, user.ID, models.ChangeActionCreate, nil, user)
+
 	user.Password = "" // Don't send password back
 
 	// Generate token with string ID
-	token, err := config.GenerateToken(id, user.Roles)
+	token, err := config.GenerateToken(id, user.Roles, "")
 	if err != nil {
 		utils.InternalError(c, "Error generating token")
 		return
@@ -104,8 +164,8 @@ func (ac *AuthController) Login(c *gin.Context) {
 		return
 	}
 
-	// Generate token with roles
-	token, err := config.GenerateToken(user.ID, user.Roles)
+	// Generate token with the roles held in the home company
+	token, err := config.GenerateToken(user.ID, user.RolesIn(user.CompanyID), user.CompanyID)
 	if err != nil {
 		utils.InternalError(c, "Error generating token")
 		return
@@ -113,8 +173,56 @@ func (ac *AuthController) Login(c *gin.Context) {
 
 	user.Password = "" // Don't send password back
 	c.JSON(http.StatusOK, models.AuthResponse{
-		Token: token,
-		User:  user,
+		Token:     token,
+		CompanyID: user.CompanyID,
+		User:      user,
+	})
+}
+
+// SwitchCompany issues a new token for acting in another company the user
+// is a member of, carrying the roles they hold there. Super users can
+// switch to any company.
+func (ac *AuthController) SwitchCompany(c *gin.Context) {
+	var req models.SwitchCompanyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	var user models.User
+	err := ac.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": c.GetString("user_id")}).Decode(&user)
+	if err != nil {
+		utils.BadRequest(c, "User not found")
+		return
+	}
+
+	roles := user.RolesIn(req.CompanyID)
+	if len(roles) == 0 {
+		utils.Forbidden(c, "You are not a member of this company")
+		return
+	}
+
+	count, err := ac.config.MongoDB.Collection("companies").CountDocuments(c, bson.M{"_id": req.CompanyID})
+	if err != nil {
+		utils.InternalError(c, "Error fetching company")
+		return
+	}
+	if count == 0 {
+		utils.BadRequest(c, "Company not found")
+		return
+	}
+
+	token, err := config.GenerateToken(user.ID, roles, req.CompanyID)
+	if err != nil {
+		utils.InternalError(c, "Error generating token")
+		return
+	}
+
+	user.Password = "" // Don't send password back
+	c.JSON(http.StatusOK, models.AuthResponse{
+		Token:     token,
+		CompanyID: req.CompanyID,
+		User:      user,
 	})
 }
 
@@ -126,15 +234,28 @@ func (ac *AuthController) GetCurrentProfile(c *gin.Context) {
 		return
 	}
 
+	shape, err := utils.ParseShape(c, userShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
 	var user models.User
-	err := ac.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": userID}).Decode(&user)
+	err = ac.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": userID}).Decode(&user)
 	if err != nil {
 		utils.BadRequest(c, "User not found")
 		return
 	}
 
 	user.Password = "" // Don't send password
-	c.JSON(http.StatusOK, user)
+
+	data, err := utils.ApplyShapeOne(c, ac.config.MongoDB.Collection("users"), shape, user)
+	if err != nil {
+		utils.InternalError(c, "Error fetching user")
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
 }
 
 // UpdateProfile updates the current user's profile
@@ -151,6 +272,12 @@ func (ac *AuthController) UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	var user models.User
+	if err := ac.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": userID}).Decode(&user); err != nil {
+		utils.BadRequest(c, "User not found")
+		return
+	}
+
 	// Prepare update data
 	update := bson.M{
 		"$set": bson.M{
@@ -183,6 +310,61 @@ func (ac *AuthController) UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	recordChange(c, ac.config, models.EntityUser, userID, models.ChangeActionUpdate, user, updatedUser)
+
+	updatedUser.Password = "" // Don't send password
+	c.JSON(http.StatusOK, updatedUser)
+}
+
+// PatchProfile applies a JSON Merge Patch or JSON Patch to the current
+// user's profile, so bio and avatar can be cleared with null
+func (ac *AuthController) PatchProfile(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var user models.User
+	if err := ac.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": userID}).Decode(&user); err != nil {
+		utils.BadRequest(c, "User not found")
+		return
+	}
+
+	current := models.ProfilePatch{
+		FullName: user.FullName,
+		Bio:      user.Bio,
+		Avatar:   user.Avatar,
+	}
+	var patched models.ProfilePatch
+	if err := utils.BindPatch(c, current, &patched); err != nil {
+		respondPatchError(c, err)
+		return
+	}
+
+	fields, err := utils.PatchFields(patched)
+	if err != nil {
+		utils.InternalError(c, "Error updating profile")
+		return
+	}
+	fields["updated_at"] = time.Now()
+
+	// Only write if the profile did not change since it was read
+	result := ac.config.MongoDB.Collection("users").FindOneAndUpdate(
+		c,
+		bson.M{"_id": userID, "updated_at": user.UpdatedAt},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedUser models.User
+	if err := result.Decode(&updatedUser); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondPatchConflict(c)
+		} else {
+			utils.InternalError(c, "Error updating profile")
+		}
+		return
+	}
+
+	recordChange(c, ac.config, models.EntityUser, userID, models.ChangeActionUpdate, user, updatedUser)
+
 	updatedUser.Password = "" // Don't send password
 	c.JSON(http.StatusOK, updatedUser)
 }
@@ -243,32 +425,73 @@ func (ac *AuthController) UpdatePassword(c *gin.Context) {
 		return
 	}
 
+	recordChange(c, ac.config, models.EntityUser, userID, models.ChangeActionUpdate, user, updatedUser)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Password updated successfully",
 	})
 }
 
-// GetAllUsers gets all users with pagination
+// GetAllUsers gets the users of the caller's companies with pagination
 func (ac *AuthController) GetAllUsers(c *gin.Context) {
-	// Get pagination parameters from query
-	page := 1
-	limit := 10
-	if pageStr := c.Query("page"); pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
-		}
+	companyIDs, ok := callerCompanyIDs(c, ac.config)
+	if !ok {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, userQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(scopeUserFilter(bson.M{}, companyIDs))
+
+	shape, err := utils.ParseShape(c, userShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	if format, ok := utils.GetExportFormat(c); ok {
+		utils.Export(c, format, ac.config.MongoDB.Collection("users"), filter, query.Sort,
+			"users", userExportColumns)
+		return
 	}
-	if limitStr := c.Query("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+
+	if utils.IsCursorRequest(c) {
+		params, err := utils.GetCursorParams(c)
+		if err != nil {
+			utils.BadRequest(c, err.Error())
+			return
+		}
+
+		users, page, err := utils.FindCursorPage[models.User](c, ac.config.MongoDB.Collection("users"), filter, params)
+		if err != nil {
+			utils.InternalError(c, "Error fetching users")
+			return
 		}
+
+		data, err := utils.ApplyShape(c, ac.config.MongoDB.Collection("users"), shape, users)
+		if err != nil {
+			utils.InternalError(c, "Error fetching users")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"users":      data,
+			"pagination": page,
+		})
+		return
 	}
 
+	// Get pagination parameters from query
+	page, limit := utils.GetPaginationParams(c)
+
 	// Calculate skip value
 	skip := (page - 1) * limit
 
 	// Get total count
-	total, err := ac.config.MongoDB.Collection("users").CountDocuments(c, bson.M{})
+	total, err := ac.config.MongoDB.Collection("users").CountDocuments(c, filter)
 	if err != nil {
 		utils.InternalError(c, "Error counting users")
 		return
@@ -276,12 +499,12 @@ func (ac *AuthController) GetAllUsers(c *gin.Context) {
 
 	// Set options for pagination and sorting
 	opts := options.Find().
-		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSort(query.Sort).
 		SetSkip(int64(skip)).
 		SetLimit(int64(limit))
 
 	// Fetch users from database
-	cursor, err := ac.config.MongoDB.Collection("users").Find(c, bson.M{}, opts)
+	cursor, err := ac.config.MongoDB.Collection("users").Find(c, filter, opts)
 	if err != nil {
 		utils.InternalError(c, "Error fetching users")
 		return
@@ -299,6 +522,12 @@ func (ac *AuthController) GetAllUsers(c *gin.Context) {
 		users[i].Password = ""
 	}
 
+	data, err := utils.ApplyShape(c, ac.config.MongoDB.Collection("users"), shape, users)
+	if err != nil {
+		utils.InternalError(c, "Error fetching users")
+		return
+	}
+
 	// Calculate pagination metadata
 	totalPages := int(math.Ceil(float64(total) / float64(limit)))
 	hasNext := page < totalPages
@@ -306,7 +535,7 @@ func (ac *AuthController) GetAllUsers(c *gin.Context) {
 
 	// Return response with pagination metadata
 	response := gin.H{
-		"users": users,
+		"users": data,
 		"pagination": gin.H{
 			"total":       total,
 			"total_pages": totalPages,
@@ -5,14 +5,76 @@ import (
 	"loan/models"
 	"loan/utils"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Fields clients may filter and sort branch offices on
+var branchOfficeQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"name":                       utils.FieldString,
+		"address":                    utils.FieldString,
+		"postal_address.city":        utils.FieldString,
+		"postal_address.region":      utils.FieldString,
+		"postal_address.postal_code": utils.FieldString,
+		"postal_address.country":     utils.FieldString,
+		"phone":                      utils.FieldString,
+		"email":                      utils.FieldString,
+		"created_by":                 utils.FieldString,
+		"created_at":                 utils.FieldTime,
+		"updated_at":                 utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"name":       true,
+		"created_at": true,
+		"updated_at": true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
+// Fields and expansions clients may request on branch offices
+var branchOfficeShapeSpec = utils.ShapeSpec{
+	Fields: map[string]bool{
+		"id": true, "company_id": true, "name": true, "address": true, "postal_address": true, "location": true,
+		"opening_hours": true, "phone": true, "email": true, "created_by": true, "updated_by": true, "created_at": true, "updated_at": true,
+	},
+	Expansions: map[string]utils.Expansion{
+		"company": {
+			From:   "companies",
+			Let:    bson.M{"company_id": "$company_id"},
+			Match:  bson.M{"$eq": bson.A{"$_id", "$$company_id"}},
+			Single: true,
+		},
+		"staff": {
+			From:    "users",
+			Let:     bson.M{"branch_id": "$_id"},
+			Match:   bson.M{"$in": bson.A{"$$branch_id", bson.M{"$ifNull": bson.A{"$branch_roles.branch_id", bson.A{}}}}},
+			Project: bson.M{"password": 0},
+		},
+	},
+}
+
+// Limits on the nearby and map queries
+const (
+	defaultNearbyRadius = 5000  // meters
+	maxNearbyRadius     = 50000 // meters
+	maxGeoResults       = 500
+)
+
+// Columns written when branch offices are exported
+var branchOfficeExportColumns = []string{
+	"id", "company_id", "name", "address", "postal_address", "location", "phone", "email",
+	"created_by", "updated_by", "created_at", "updated_at",
+}
+
 type BranchOfficeController struct {
 	config *config.Config
 }
@@ -24,6 +86,9 @@ func NewBranchOfficeController(config *config.Config) *BranchOfficeController {
 // CreateBranchOffice creates a new branch office for a company
 func (bc *BranchOfficeController) CreateBranchOffice(c *gin.Context) {
 	companyID := c.Param("id")
+	if !requireCompanyAccess(c, bc.config, companyID) {
+		return
+	}
 
 	// Verify company exists
 	var company models.Company
@@ -40,16 +105,18 @@ func (bc *BranchOfficeController) CreateBranchOffice(c *gin.Context) {
 	}
 
 	branchOffice := models.BranchOffice{
-		ID:        primitive.NewObjectID().Hex(),
-		CompanyID: companyID,
-		Name:      req.Name,
-		Address:   req.Address,
-		Phone:     req.Phone,
-		Email:     req.Email,
-		CreatedBy: c.GetString("user_id"),
-		UpdatedBy: c.GetString("user_id"),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:            primitive.NewObjectID().Hex(),
+		CompanyID:     companyID,
+		Name:          req.Name,
+		Address:       branchAddress(req),
+		PostalAddress: req.PostalAddress,
+		Location:      req.Location,
+		Phone:         req.Phone,
+		Email:         req.Email,
+		CreatedBy:     c.GetString("user_id"),
+		UpdatedBy:     c.GetString("user_id"),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
 	}
 
 	_, err = bc.config.MongoDB.Collection("branch_offices").InsertOne(c, branchOffice)
@@ -58,6 +125,8 @@ func (bc *BranchOfficeController) CreateBranchOffice(c *gin.Context) {
 		return
 	}
 
+	recordChange(c, bc.config, models.EntityBranchOffice, branchOffice.ID, models.ChangeActionCreate, nil, branchOffice)
+
 	c.JSON(http.StatusCreated, branchOffice)
 }
 
@@ -65,9 +134,18 @@ func (bc *BranchOfficeController) CreateBranchOffice(c *gin.Context) {
 func (bc *BranchOfficeController) GetBranchOffice(c *gin.Context) {
 	branchID := c.Param("branch_id")
 	companyID := c.Param("id")
+	if !requireCompanyAccess(c, bc.config, companyID) {
+		return
+	}
+
+	shape, err := utils.ParseShape(c, branchOfficeShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
 
 	var branchOffice models.BranchOffice
-	err := bc.config.MongoDB.Collection("branch_offices").FindOne(c,
+	err = bc.config.MongoDB.Collection("branch_offices").FindOne(c,
 		bson.M{
 			"_id":        branchID,
 			"company_id": companyID,
@@ -78,13 +156,22 @@ func (bc *BranchOfficeController) GetBranchOffice(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, branchOffice)
+	data, err := utils.ApplyShapeOne(c, bc.config.MongoDB.Collection("branch_offices"), shape, branchOffice)
+	if err != nil {
+		utils.InternalError(c, "Error fetching branch office")
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
 }
 
 // UpdateBranchOffice updates a branch office
 func (bc *BranchOfficeController) UpdateBranchOffice(c *gin.Context) {
 	branchID := c.Param("branch_id")
 	companyID := c.Param("id")
+	if !requireCompanyAccess(c, bc.config, companyID) {
+		return
+	}
 
 	var req models.BranchOffice
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -92,6 +179,17 @@ func (bc *BranchOfficeController) UpdateBranchOffice(c *gin.Context) {
 		return
 	}
 
+	var branchOffice models.BranchOffice
+	err := bc.config.MongoDB.Collection("branch_offices").FindOne(c,
+		bson.M{
+			"_id":        branchID,
+			"company_id": companyID,
+		}).Decode(&branchOffice)
+	if err != nil {
+		utils.BadRequest(c, "Branch office not found")
+		return
+	}
+
 	update := bson.M{
 		"$set": bson.M{
 			"updated_at": time.Now(),
@@ -103,8 +201,14 @@ func (bc *BranchOfficeController) UpdateBranchOffice(c *gin.Context) {
 	if req.Name != "" {
 		update["$set"].(bson.M)["name"] = req.Name
 	}
-	if req.Address != "" {
-		update["$set"].(bson.M)["address"] = req.Address
+	if req.Address != "" || req.PostalAddress != nil {
+		update["$set"].(bson.M)["address"] = branchAddress(req)
+	}
+	if req.PostalAddress != nil {
+		update["$set"].(bson.M)["postal_address"] = req.PostalAddress
+	}
+	if req.Location != nil {
+		update["$set"].(bson.M)["location"] = req.Location
 	}
 	if req.Phone != "" {
 		update["$set"].(bson.M)["phone"] = req.Phone
@@ -129,18 +233,142 @@ func (bc *BranchOfficeController) UpdateBranchOffice(c *gin.Context) {
 		return
 	}
 
+	recordChange(c, bc.config, models.EntityBranchOffice, branchID, models.ChangeActionUpdate, branchOffice, updatedBranchOffice)
+
+	c.JSON(http.StatusOK, updatedBranchOffice)
+}
+
+// PatchBranchOffice applies a JSON Merge Patch or JSON Patch to a branch
+// office
+func (bc *BranchOfficeController) PatchBranchOffice(c *gin.Context) {
+	branchID := c.Param("branch_id")
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, bc.config, companyID) {
+		return
+	}
+
+	var branchOffice models.BranchOffice
+	err := bc.config.MongoDB.Collection("branch_offices").FindOne(c,
+		bson.M{
+			"_id":        branchID,
+			"company_id": companyID,
+		}).Decode(&branchOffice)
+	if err != nil {
+		utils.BadRequest(c, "Branch office not found")
+		return
+	}
+
+	current := models.BranchOfficePatch{
+		Name:          branchOffice.Name,
+		Address:       branchOffice.Address,
+		PostalAddress: branchOffice.PostalAddress,
+		Location:      branchOffice.Location,
+		Phone:         branchOffice.Phone,
+		Email:         branchOffice.Email,
+	}
+	var patched models.BranchOfficePatch
+	if err := utils.BindPatch(c, current, &patched); err != nil {
+		respondPatchError(c, err)
+		return
+	}
+
+	// Keep the one-line address in step with a changed postal address
+	// unless the patch set it too
+	if patched.PostalAddress != nil && patched.Address == branchOffice.Address &&
+		!reflect.DeepEqual(patched.PostalAddress, branchOffice.PostalAddress) {
+		patched.Address = patched.PostalAddress.String()
+	}
+
+	fields, err := utils.PatchFields(patched)
+	if err != nil {
+		utils.InternalError(c, "Error updating branch office")
+		return
+	}
+	fields["updated_at"] = time.Now()
+	fields["updated_by"] = c.GetString("user_id")
+
+	// Only write if nobody changed the branch office since it was read
+	result := bc.config.MongoDB.Collection("branch_offices").FindOneAndUpdate(
+		c,
+		bson.M{
+			"_id":        branchID,
+			"company_id": companyID,
+			"updated_at": branchOffice.UpdatedAt,
+		},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedBranchOffice models.BranchOffice
+	if err := result.Decode(&updatedBranchOffice); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondPatchConflict(c)
+		} else {
+			utils.InternalError(c, "Error updating branch office")
+		}
+		return
+	}
+
+	recordChange(c, bc.config, models.EntityBranchOffice, branchID, models.ChangeActionUpdate, branchOffice, updatedBranchOffice)
+
 	c.JSON(http.StatusOK, updatedBranchOffice)
 }
 
 // ListBranchOffices lists all branch offices for a company
 func (bc *BranchOfficeController) ListBranchOffices(c *gin.Context) {
 	companyID := c.Param("id")
+	if !requireCompanyAccess(c, bc.config, companyID) {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, branchOfficeQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(bson.M{"company_id": companyID})
+
+	shape, err := utils.ParseShape(c, branchOfficeShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	if format, ok := utils.GetExportFormat(c); ok {
+		utils.Export(c, format, bc.config.MongoDB.Collection("branch_offices"), filter, query.Sort,
+			"branch_offices", branchOfficeExportColumns)
+		return
+	}
+
+	if utils.IsCursorRequest(c) {
+		params, err := utils.GetCursorParams(c)
+		if err != nil {
+			utils.BadRequest(c, err.Error())
+			return
+		}
+
+		branchOffices, page, err := utils.FindCursorPage[models.BranchOffice](c, bc.config.MongoDB.Collection("branch_offices"),
+			filter, params)
+		if err != nil {
+			utils.InternalError(c, "Error fetching branch offices")
+			return
+		}
+
+		data, err := utils.ApplyShape(c, bc.config.MongoDB.Collection("branch_offices"), shape, branchOffices)
+		if err != nil {
+			utils.InternalError(c, "Error fetching branch offices")
+			return
+		}
+
+		utils.SendCursorResponse(c, data, page)
+		return
+	}
+
 	page, limit := utils.GetPaginationParams(c)
 	skip := (page - 1) * limit
 
 	// Get total count
-	total, err := bc.config.MongoDB.Collection("branch_offices").CountDocuments(c,
-		bson.M{"company_id": companyID})
+	total, err := bc.config.MongoDB.Collection("branch_offices").CountDocuments(c, filter)
 	if err != nil {
 		utils.InternalError(c, "Error counting branch offices")
 		return
@@ -148,13 +376,12 @@ func (bc *BranchOfficeController) ListBranchOffices(c *gin.Context) {
 
 	// Set options for pagination and sorting
 	opts := options.Find().
-		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSort(query.Sort).
 		SetSkip(int64(skip)).
 		SetLimit(int64(limit))
 
 	// Fetch branch offices
-	cursor, err := bc.config.MongoDB.Collection("branch_offices").Find(c,
-		bson.M{"company_id": companyID}, opts)
+	cursor, err := bc.config.MongoDB.Collection("branch_offices").Find(c, filter, opts)
 	if err != nil {
 		utils.InternalError(c, "Error fetching branch offices")
 		return
@@ -167,28 +394,184 @@ func (bc *BranchOfficeController) ListBranchOffices(c *gin.Context) {
 		return
 	}
 
-	utils.SendPaginatedResponse(c, branchOffices, total, page, limit)
+	data, err := utils.ApplyShape(c, bc.config.MongoDB.Collection("branch_offices"), shape, branchOffices)
+	if err != nil {
+		utils.InternalError(c, "Error fetching branch offices")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, data, total, page, limit)
 }
 
 // DeleteBranchOffice deletes a branch office
 func (bc *BranchOfficeController) DeleteBranchOffice(c *gin.Context) {
 	branchID := c.Param("branch_id")
 	companyID := c.Param("id")
+	if !requireCompanyAccess(c, bc.config, companyID) {
+		return
+	}
 
-	result, err := bc.config.MongoDB.Collection("branch_offices").DeleteOne(c,
+	var branchOffice models.BranchOffice
+	err := bc.config.MongoDB.Collection("branch_offices").FindOneAndDelete(c,
 		bson.M{
 			"_id":        branchID,
 			"company_id": companyID,
-		})
+		}).Decode(&branchOffice)
+	if err == mongo.ErrNoDocuments {
+		utils.BadRequest(c, "Branch office not found")
+		return
+	}
 	if err != nil {
 		utils.InternalError(c, "Error deleting branch office")
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		utils.BadRequest(c, "Branch office not found")
+	recordChange(c, bc.config, models.EntityBranchOffice, branchID, models.ChangeActionDelete, branchOffice, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Branch office deleted successfully"})
+}
+
+// NearbyBranchOffices lists the branch offices within radius meters of a
+// point, nearest first, across the caller's company
+func (bc *BranchOfficeController) NearbyBranchOffices(c *gin.Context) {
+	lat, errLat := strconv.ParseFloat(c.Query("lat"), 64)
+	lng, errLng := strconv.ParseFloat(c.Query("lng"), 64)
+	if errLat != nil || errLng != nil || !models.ValidLatLng(lat, lng) {
+		utils.BadRequest(c, "Invalid lat or lng")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Branch office deleted successfully"})
+	radius := float64(defaultNearbyRadius)
+	if radiusStr := c.Query("radius"); radiusStr != "" {
+		r, err := strconv.ParseFloat(radiusStr, 64)
+		if err != nil || r <= 0 || r > maxNearbyRadius {
+			utils.BadRequest(c, "Invalid radius, expected meters up to "+strconv.Itoa(maxNearbyRadius))
+			return
+		}
+		radius = r
+	}
+
+	companyIDs, ok := callerCompanyIDs(c, bc.config)
+	if !ok {
+		return
+	}
+
+	query := bson.M{}
+	scopeFilter(query, "company_id", companyIDs)
+
+	cursor, err := bc.config.MongoDB.Collection("branch_offices").Aggregate(c, mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":          models.NewGeoPoint(lat, lng),
+			"key":           "location",
+			"spherical":     true,
+			"maxDistance":   radius,
+			"query":         query,
+			"distanceField": "distance_meters",
+		}}},
+		{{Key: "$limit", Value: geoResultLimit(c)}},
+	})
+	if err != nil {
+		utils.InternalError(c, "Error fetching branch offices")
+		return
+	}
+	defer cursor.Close(c)
+
+	branchOffices := []models.NearbyBranchOffice{}
+	if err = cursor.All(c, &branchOffices); err != nil {
+		utils.InternalError(c, "Error parsing branch offices")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": branchOffices})
+}
+
+// BranchOfficesInBounds lists the branch offices inside a bounding box for
+// map views, across the caller's company. The box is given as
+// bbox=min_lng,min_lat,max_lng,max_lat.
+func (bc *BranchOfficeController) BranchOfficesInBounds(c *gin.Context) {
+	parts := strings.Split(c.Query("bbox"), ",")
+	if len(parts) != 4 {
+		utils.BadRequest(c, "bbox must be min_lng,min_lat,max_lng,max_lat")
+		return
+	}
+	var box [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			utils.BadRequest(c, "bbox must be min_lng,min_lat,max_lng,max_lat")
+			return
+		}
+		box[i] = v
+	}
+	minLng, minLat, maxLng, maxLat := box[0], box[1], box[2], box[3]
+	if !models.ValidLatLng(minLat, minLng) || !models.ValidLatLng(maxLat, maxLng) ||
+		minLng >= maxLng || minLat >= maxLat {
+		utils.BadRequest(c, "Invalid bbox")
+		return
+	}
+	// Polygon edges are great circles, so a box wider than a hemisphere
+	// would select the wrong side of the globe
+	if maxLng-minLng >= 180 {
+		utils.BadRequest(c, "bbox must span less than 180 degrees of longitude")
+		return
+	}
+
+	companyIDs, ok := callerCompanyIDs(c, bc.config)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{
+		"location": bson.M{"$geoWithin": bson.M{"$geometry": bson.M{
+			"type": "Polygon",
+			"coordinates": bson.A{bson.A{
+				bson.A{minLng, minLat},
+				bson.A{maxLng, minLat},
+				bson.A{maxLng, maxLat},
+				bson.A{minLng, maxLat},
+				bson.A{minLng, minLat},
+			}},
+		}}},
+	}
+	scopeFilter(filter, "company_id", companyIDs)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(geoResultLimit(c))
+
+	cursor, err := bc.config.MongoDB.Collection("branch_offices").Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching branch offices")
+		return
+	}
+	defer cursor.Close(c)
+
+	branchOffices := []models.BranchOffice{}
+	if err = cursor.All(c, &branchOffices); err != nil {
+		utils.InternalError(c, "Error parsing branch offices")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": branchOffices})
+}
+
+// branchAddress returns the one-line address for a branch office request,
+// built from its postal address when no address is given
+func branchAddress(req models.BranchOffice) string {
+	if req.Address == "" && req.PostalAddress != nil {
+		return req.PostalAddress.String()
+	}
+	return req.Address
+}
+
+// geoResultLimit reads ?limit= for the geo queries, which return a single
+// unpaginated list
+func geoResultLimit(c *gin.Context) int64 {
+	limit := int64(maxGeoResults)
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.ParseInt(limitStr, 10, 64); err == nil && l > 0 && l < limit {
+			limit = l
+		}
+	}
+	return limit
 }
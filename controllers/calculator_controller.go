@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"loan/config"
+	"loan/loancalc"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CalculatorController quotes loans without storing anything
+type CalculatorController struct {
+	config *config.Config
+}
+
+func NewCalculatorController(config *config.Config) *CalculatorController {
+	return &CalculatorController{config: config}
+}
+
+// CalculateSchedule returns the installments and totals of the loan in the
+// request, as JSON or, with ?format=csv or Accept: text/csv, as CSV
+func (cc *CalculatorController) CalculateSchedule(c *gin.Context) {
+	var req models.ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	schedule, err := loancalc.Generate(req)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	format, ok := utils.GetExportFormat(c)
+	if !ok {
+		c.JSON(http.StatusOK, schedule)
+		return
+	}
+	if format != utils.ExportCSV {
+		utils.HandleError(c, http.StatusNotAcceptable, "Schedules are available as JSON or CSV")
+		return
+	}
+
+	decimals := 2
+	if req.Rounding.Decimals != nil {
+		decimals = *req.Rounding.Decimals
+	}
+	amount := func(x float64) string {
+		return strconv.FormatFloat(x, 'f', decimals, 64)
+	}
+
+	filename := fmt.Sprintf("schedule_%s.csv", time.Now().Format("20060102_150405"))
+	c.Header("Content-Type", string(utils.ExportCSV))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"number", "due_date", "payment", "principal", "interest", "capitalized", "balance", "grace"})
+	for _, inst := range schedule.Installments {
+		w.Write([]string{
+			strconv.Itoa(inst.Number), inst.DueDate, amount(inst.Payment), amount(inst.Principal),
+			amount(inst.Interest), amount(inst.Capitalized), amount(inst.Balance), strconv.FormatBool(inst.Grace),
+		})
+	}
+	totals := schedule.Totals
+	w.Write([]string{
+		"total", "", amount(totals.Payment), amount(totals.Principal), amount(totals.Interest),
+		amount(totals.Capitalized), "", "",
+	})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		utils.Error("Error writing schedule: " + err.Error())
+	}
+}
@@ -0,0 +1,363 @@
+package controllers
+
+import (
+	"loan/calendar"
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxCalendarFileSize = 2 << 20
+	// Yearly holidays in an imported calendar are expanded this far ahead
+	holidayImportYears = 5
+	// Largest ?days= accepted by NextBusinessDay
+	maxBusinessDays = 365
+)
+
+type CalendarController struct {
+	config    *config.Config
+	calendars *calendar.Service
+}
+
+func NewCalendarController(config *config.Config) *CalendarController {
+	return &CalendarController{
+		config:    config,
+		calendars: calendar.NewService(config.MongoDB),
+	}
+}
+
+// SetOpeningHours replaces the weekly opening hours of a branch office
+func (cc *CalendarController) SetOpeningHours(c *gin.Context) {
+	branchID := c.Param("branch_id")
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, cc.config, companyID) {
+		return
+	}
+
+	var req models.OpeningHours
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+	if _, err := calendar.New(req, nil); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	var branchOffice models.BranchOffice
+	err := cc.config.MongoDB.Collection("branch_offices").FindOne(c,
+		bson.M{
+			"_id":        branchID,
+			"company_id": companyID,
+		}).Decode(&branchOffice)
+	if err != nil {
+		utils.BadRequest(c, "Branch office not found")
+		return
+	}
+
+	result := cc.config.MongoDB.Collection("branch_offices").FindOneAndUpdate(
+		c,
+		bson.M{
+			"_id":        branchID,
+			"company_id": companyID,
+		},
+		bson.M{"$set": bson.M{
+			"opening_hours": req,
+			"updated_at":    time.Now(),
+			"updated_by":    c.GetString("user_id"),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedBranchOffice models.BranchOffice
+	if err := result.Decode(&updatedBranchOffice); err != nil {
+		utils.BadRequest(c, "Branch office not found")
+		return
+	}
+
+	recordChange(c, cc.config, models.EntityBranchOffice, branchID, models.ChangeActionUpdate, branchOffice, updatedBranchOffice)
+
+	c.JSON(http.StatusOK, updatedBranchOffice)
+}
+
+// ListHolidays lists a company's holidays, or with a branch_id the holidays
+// that apply to that branch office
+func (cc *CalendarController) ListHolidays(c *gin.Context) {
+	if !requireCompanyAccess(c, cc.config, c.Param("id")) {
+		return
+	}
+
+	holidays, err := cc.calendars.Holidays(c, c.Param("id"), c.Param("branch_id"))
+	if err != nil {
+		utils.InternalError(c, "Error fetching holidays")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": holidays})
+}
+
+// CreateHoliday adds a holiday to a company, or to one branch office when
+// the route has a branch_id
+func (cc *CalendarController) CreateHoliday(c *gin.Context) {
+	companyID := c.Param("id")
+	branchID := c.Param("branch_id")
+	if !requireCompanyAccess(c, cc.config, companyID) || !cc.holidayOwnerExists(c, companyID, branchID) {
+		return
+	}
+
+	var req models.CreateHolidayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	holiday := models.Holiday{
+		ID:        primitive.NewObjectID().Hex(),
+		CompanyID: companyID,
+		BranchID:  branchID,
+		Date:      req.Date,
+		Name:      req.Name,
+		Source:    models.HolidayManual,
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := cc.config.MongoDB.Collection("holidays").InsertOne(c, holiday); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			utils.HandleError(c, http.StatusConflict, "A holiday already exists on this date")
+		} else {
+			utils.InternalError(c, "Error creating holiday")
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, holiday)
+}
+
+// DeleteHoliday removes one of a company's holidays
+func (cc *CalendarController) DeleteHoliday(c *gin.Context) {
+	if !requireCompanyAccess(c, cc.config, c.Param("id")) {
+		return
+	}
+
+	result, err := cc.config.MongoDB.Collection("holidays").DeleteOne(c, bson.M{
+		"_id":        c.Param("holiday_id"),
+		"company_id": c.Param("id"),
+	})
+	if err != nil {
+		utils.InternalError(c, "Error deleting holiday")
+		return
+	}
+	if result.DeletedCount == 0 {
+		utils.BadRequest(c, "Holiday not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Holiday deleted successfully"})
+}
+
+// ImportHolidays reads an iCalendar (.ics) file from the "file" field and
+// adds each date as a holiday of the company, or of one branch office when
+// the route has a branch_id. Dates that already have a holiday are renamed
+// to the imported event, so importing the same file twice is harmless.
+func (cc *CalendarController) ImportHolidays(c *gin.Context) {
+	companyID := c.Param("id")
+	branchID := c.Param("branch_id")
+	if !requireCompanyAccess(c, cc.config, companyID) || !cc.holidayOwnerExists(c, companyID, branchID) {
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		utils.BadRequest(c, "File is required")
+		return
+	}
+	if fileHeader.Size > maxCalendarFileSize {
+		utils.BadRequest(c, "File is too large")
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		utils.BadRequest(c, "Error reading file")
+		return
+	}
+	defer file.Close()
+
+	events, err := calendar.ParseICS(file, time.Now().AddDate(holidayImportYears, 0, 0))
+	if err != nil {
+		utils.BadRequest(c, "Invalid calendar: "+err.Error())
+		return
+	}
+
+	userID := c.GetString("user_id")
+	result := models.HolidayImportResult{Events: len(events)}
+	var writes []mongo.WriteModel
+	for _, event := range events {
+		name := event.Summary
+		if name == "" {
+			name = "Holiday"
+		}
+		for _, date := range event.Dates {
+			result.Dates++
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"company_id": companyID, "branch_id": branchID, "date": date}).
+				SetUpdate(bson.M{
+					"$set": bson.M{"name": name, "source": models.HolidayICS, "uid": event.UID},
+					"$setOnInsert": bson.M{
+						"_id":        primitive.NewObjectID().Hex(),
+						"created_by": userID,
+						"created_at": time.Now(),
+					},
+				}).
+				SetUpsert(true))
+		}
+	}
+
+	if len(writes) > 0 {
+		written, err := cc.config.MongoDB.Collection("holidays").BulkWrite(c, writes, options.BulkWrite().SetOrdered(false))
+		if written != nil {
+			result.Created = int(written.UpsertedCount)
+			result.Updated = int(written.ModifiedCount)
+		}
+		if err != nil {
+			utils.InternalError(c, "Error importing holidays")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// IsOpen reports whether a branch office is open at ?at= (RFC 3339,
+// default now) and when it next opens
+func (cc *CalendarController) IsOpen(c *gin.Context) {
+	if !requireCompanyAccess(c, cc.config, c.Param("id")) {
+		return
+	}
+
+	at := time.Now()
+	if atStr := c.Query("at"); atStr != "" {
+		t, err := time.Parse(time.RFC3339, atStr)
+		if err != nil {
+			utils.BadRequest(c, "Invalid at, expected RFC 3339")
+			return
+		}
+		at = t
+	}
+
+	cal, ok := cc.branchCalendar(c)
+	if !ok {
+		return
+	}
+
+	response := gin.H{
+		"at":       at.In(cal.Location()),
+		"open":     cal.IsOpen(at),
+		"timezone": cal.Location().String(),
+	}
+	if name, ok := cal.Holiday(at); ok {
+		response["holiday"] = name
+	}
+	if next, err := cal.NextOpen(at); err == nil {
+		response["next_open"] = next
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// NextBusinessDay returns the business day ?days= (default 1) business days
+// after ?date= (2006-01-02 or RFC 3339, default today) at a branch office
+func (cc *CalendarController) NextBusinessDay(c *gin.Context) {
+	if !requireCompanyAccess(c, cc.config, c.Param("id")) {
+		return
+	}
+
+	days := 1
+	if daysStr := c.Query("days"); daysStr != "" {
+		d, err := strconv.Atoi(daysStr)
+		if err != nil || d < 0 || d > maxBusinessDays {
+			utils.BadRequest(c, "Invalid days")
+			return
+		}
+		days = d
+	}
+
+	cal, ok := cc.branchCalendar(c)
+	if !ok {
+		return
+	}
+
+	from := time.Now().In(cal.Location())
+	if dateStr := c.Query("date"); dateStr != "" {
+		t, dateOnly, err := utils.ParseDate(dateStr)
+		if err != nil {
+			utils.BadRequest(c, "Invalid date")
+			return
+		}
+		if dateOnly {
+			// A plain date is a day in the branch's timezone
+			t = time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, cal.Location())
+		}
+		from = t
+	}
+
+	next, err := cal.AddBusinessDays(from, days)
+	if err != nil {
+		utils.HandleError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from.In(cal.Location()).Format("2006-01-02"),
+		"days":     days,
+		"date":     next.Format("2006-01-02"),
+		"timezone": cal.Location().String(),
+	})
+}
+
+// branchCalendar loads the calendar of the branch office in the route. When
+// it cannot, an error response is written and ok is false.
+func (cc *CalendarController) branchCalendar(c *gin.Context) (*calendar.Calendar, bool) {
+	cal, err := cc.calendars.ForBranch(c, c.Param("id"), c.Param("branch_id"))
+	switch err {
+	case nil:
+		return cal, true
+	case calendar.ErrBranchNotFound:
+		utils.BadRequest(c, "Branch office not found")
+	case calendar.ErrNoOpeningHours:
+		utils.BadRequest(c, "Branch office has no opening hours")
+	default:
+		utils.InternalError(c, "Error loading branch calendar")
+	}
+	return nil, false
+}
+
+// holidayOwnerExists checks the company, and the branch office when one is
+// given, that a holiday is being added to
+func (cc *CalendarController) holidayOwnerExists(c *gin.Context, companyID, branchID string) bool {
+	collection, filter, message := "companies", bson.M{"_id": companyID}, "Company not found"
+	if branchID != "" {
+		collection, filter, message = "branch_offices", bson.M{"_id": branchID, "company_id": companyID}, "Branch office not found"
+	}
+
+	count, err := cc.config.MongoDB.Collection(collection).CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error fetching company")
+		return false
+	}
+	if count == 0 {
+		utils.BadRequest(c, message)
+		return false
+	}
+	return true
+}
@@ -5,14 +5,82 @@ import (
 	"loan/models"
 	"loan/utils"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Fields clients may filter and sort companies on
+var companyQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"name":              utils.FieldString,
+		"email":             utils.FieldString,
+		"phone":             utils.FieldString,
+		"tax_id":            utils.FieldString,
+		"business_type":     utils.FieldString,
+		"trading_name":      utils.FieldString,
+		"type":              utils.FieldString,
+		"country":           utils.FieldString,
+		"parent_company_id": utils.FieldString,
+		"created_by":        utils.FieldString,
+		"created_at":        utils.FieldTime,
+		"updated_at":        utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"name":          true,
+		"business_type": true,
+		"country":       true,
+		"created_at":    true,
+		"updated_at":    true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
+// companySummary projects a company down to its ID and name, for related
+// companies the caller may not have access to
+var companySummary = bson.M{"name": 1}
+
+// Fields and expansions clients may request on companies
+var companyShapeSpec = utils.ShapeSpec{
+	Fields: map[string]bool{
+		"id": true, "parent_company_id": true, "name": true, "trading_name": true, "type": true, "registration_number": true,
+		"country": true, "address": true, "phone": true, "email": true, "website": true,
+		"tax_id": true, "business_type": true, "created_by": true, "updated_by": true,
+		"created_at": true, "updated_at": true,
+	},
+	Expansions: map[string]utils.Expansion{
+		"branches": {
+			From:  "branch_offices",
+			Let:   bson.M{"company_id": "$_id"},
+			Match: bson.M{"$eq": bson.A{"$company_id", "$$company_id"}},
+		},
+		// Access only inherits down a group, so the parent is summarized
+		"parent": {
+			From:    "companies",
+			Let:     bson.M{"parent_company_id": "$parent_company_id"},
+			Match:   bson.M{"$eq": bson.A{"$_id", "$$parent_company_id"}},
+			Project: companySummary,
+			Single:  true,
+		},
+		"subsidiaries": {
+			From:  "companies",
+			Let:   bson.M{"company_id": "$_id"},
+			Match: bson.M{"$eq": bson.A{"$parent_company_id", "$$company_id"}},
+		},
+	},
+}
+
+// Columns written when companies are exported
+var companyExportColumns = []string{
+	"id", "parent_company_id", "name", "trading_name", "type", "registration_number", "country", "address", "phone",
+	"email", "website", "tax_id", "business_type", "created_by", "updated_by", "created_at", "updated_at",
+}
+
 type CompanyController struct {
 	config *config.Config
 }
@@ -25,55 +93,153 @@ func NewCompanyController(config *config.Config) *CompanyController {
 func (cc *CompanyController) CreateCompany(c *gin.Context) {
 	var req models.CreateCompanyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequest(c, "Invalid request body")
+		utils.BadRequest(c, "Invalid request body: "+strings.Join(utils.ValidationMessages(err, req), "; "))
 		return
 	}
 
+	if req.ParentCompanyID != "" {
+		if !requireCompanyAccess(c, cc.config, req.ParentCompanyID) ||
+			!checkParentCompany(c, cc.config, "", req.ParentCompanyID) {
+			return
+		}
+	}
+
 	userID := c.GetString("user_id")
 	company := models.Company{
-		ID:           primitive.NewObjectID().Hex(),
-		Name:         req.Name,
-		Address:      req.Address,
-		Phone:        req.Phone,
-		Email:        req.Email,
-		Website:      req.Website,
-		TaxID:        req.TaxID,
-		BusinessType: req.BusinessType,
-		CreatedBy:    userID,
-		UpdatedBy:    userID,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		ID:                 primitive.NewObjectID().Hex(),
+		ParentCompanyID:    req.ParentCompanyID,
+		Name:               req.Name,
+		TradingName:        req.TradingName,
+		Type:               req.Type,
+		RegistrationNumber: req.RegistrationNumber,
+		Country:            strings.ToUpper(req.Country),
+		Address:            req.Address,
+		Phone:              req.Phone,
+		Email:              req.Email,
+		Website:            req.Website,
+		TaxID:              utils.NormalizeTaxID(req.TaxID),
+		BusinessType:       req.BusinessType,
+		CreatedBy:          userID,
+		UpdatedBy:          userID,
+		CreatedAt:          time.Now(),
+		UpdatedAt:          time.Now(),
+	}
+
+	if !cc.ensureTaxIDAvailable(c, company.Country, company.TaxID, "") {
+		return
 	}
 
 	_, err := cc.config.MongoDB.Collection("companies").InsertOne(c, company)
 	if err != nil {
-		utils.InternalError(c, "Error creating company")
+		if mongo.IsDuplicateKeyError(err) {
+			respondTaxIDTaken(c)
+		} else {
+			utils.InternalError(c, "Error creating company")
+		}
 		return
 	}
 
+	recordChange(c, cc.config, models.EntityCompany, company.ID, models.ChangeActionCreate, nil, company)
+
+	// A new top-level company is outside every group the creator can see,
+	// so they are made its admin
+	if company.ParentCompanyID == "" && !utils.HasRole(c, models.RoleSuperUser) {
+		if !cc.addCreator(c, company.ID) {
+			return
+		}
+	}
+
 	c.JSON(http.StatusCreated, company)
 }
 
+// addCreator makes the current user an admin member of the company they
+// created. It becomes their home company if they have none; otherwise they
+// switch to it to work in it. When it fails an error response is written
+// and false returned.
+func (cc *CompanyController) addCreator(c *gin.Context, companyID string) bool {
+	var user models.User
+	err := cc.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": c.GetString("user_id")}).Decode(&user)
+	if err != nil {
+		utils.InternalError(c, "Error fetching user")
+		return false
+	}
+
+	roles := []models.Role{models.RoleAdmin}
+	set := bson.M{"updated_at": time.Now()}
+	if user.CompanyID == "" {
+		for _, role := range user.Roles {
+			if role != models.RoleAdmin {
+				roles = append(roles, role)
+			}
+		}
+		set["company_id"] = companyID
+		set["roles"] = roles
+	}
+
+	updatedUser := user
+	updatedUser.Memberships = append([]models.CompanyMembership{}, user.Memberships...)
+	updatedUser.SetMembership(companyID, roles, user.ID)
+	set["memberships"] = updatedUser.Memberships
+
+	result := cc.config.MongoDB.Collection("users").FindOneAndUpdate(c,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if err := result.Decode(&updatedUser); err != nil {
+		utils.InternalError(c, "Error adding you to the company")
+		return false
+	}
+
+	recordChange(c, cc.config, models.EntityUser, user.ID, models.ChangeActionUpdate, user, updatedUser)
+	return true
+}
+
 // GetCompany gets a company by ID
 func (cc *CompanyController) GetCompany(c *gin.Context) {
 	id := c.Param("id")
+	if !requireCompanyAccess(c, cc.config, id) {
+		return
+	}
+
+	shape, err := utils.ParseShape(c, companyShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
 
 	var company models.Company
-	err := cc.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": id}).Decode(&company)
+	err = cc.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": id}).Decode(&company)
 	if err != nil {
 		utils.BadRequest(c, "Company not found")
 		return
 	}
 
-	c.JSON(http.StatusOK, company)
+	data, err := utils.ApplyShapeOne(c, cc.config.MongoDB.Collection("companies"), shape, company)
+	if err != nil {
+		utils.InternalError(c, "Error fetching company")
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
 }
 
 // UpdateCompany updates a company
 func (cc *CompanyController) UpdateCompany(c *gin.Context) {
 	id := c.Param("id")
+	if !requireCompanyAccess(c, cc.config, id) {
+		return
+	}
+
 	var req models.UpdateCompanyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequest(c, "Invalid request body")
+		utils.BadRequest(c, "Invalid request body: "+strings.Join(utils.ValidationMessages(err, req), "; "))
+		return
+	}
+
+	var company models.Company
+	if err := cc.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": id}).Decode(&company); err != nil {
+		utils.BadRequest(c, "Company not found")
 		return
 	}
 
@@ -97,15 +263,48 @@ func (cc *CompanyController) UpdateCompany(c *gin.Context) {
 	if req.Email != "" {
 		update["$set"].(bson.M)["email"] = req.Email
 	}
+	if req.ParentCompanyID != "" && req.ParentCompanyID != company.ParentCompanyID {
+		if !requireCompanyAccess(c, cc.config, req.ParentCompanyID) ||
+			!checkParentCompany(c, cc.config, id, req.ParentCompanyID) {
+			return
+		}
+		update["$set"].(bson.M)["parent_company_id"] = req.ParentCompanyID
+	}
 	if req.Website != "" {
 		update["$set"].(bson.M)["website"] = req.Website
 	}
-	if req.TaxID != "" {
-		update["$set"].(bson.M)["tax_id"] = req.TaxID
-	}
 	if req.BusinessType != "" {
 		update["$set"].(bson.M)["business_type"] = req.BusinessType
 	}
+	if req.TradingName != "" {
+		update["$set"].(bson.M)["trading_name"] = req.TradingName
+	}
+	if req.Type != "" {
+		update["$set"].(bson.M)["type"] = req.Type
+	}
+	if req.RegistrationNumber != "" {
+		update["$set"].(bson.M)["registration_number"] = req.RegistrationNumber
+	}
+
+	// The tax ID is checked against the country it will end up with
+	if req.TaxID != "" || req.Country != "" {
+		country, taxID := company.Country, company.TaxID
+		if req.Country != "" {
+			country = strings.ToUpper(req.Country)
+			update["$set"].(bson.M)["country"] = country
+		}
+		if req.TaxID != "" {
+			taxID = utils.NormalizeTaxID(req.TaxID)
+			update["$set"].(bson.M)["tax_id"] = taxID
+		}
+		if err := utils.ValidateTaxID(country, taxID); err != nil {
+			utils.BadRequest(c, err.Error())
+			return
+		}
+		if !cc.ensureTaxIDAvailable(c, country, taxID, id) {
+			return
+		}
+	}
 
 	result := cc.config.MongoDB.Collection("companies").FindOneAndUpdate(
 		c,
@@ -116,38 +315,188 @@ func (cc *CompanyController) UpdateCompany(c *gin.Context) {
 
 	var updatedCompany models.Company
 	if err := result.Decode(&updatedCompany); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			respondTaxIDTaken(c)
+		} else {
+			utils.BadRequest(c, "Company not found")
+		}
+		return
+	}
+
+	recordChange(c, cc.config, models.EntityCompany, id, models.ChangeActionUpdate, company, updatedCompany)
+
+	c.JSON(http.StatusOK, updatedCompany)
+}
+
+// PatchCompany applies a JSON Merge Patch or JSON Patch to a company.
+// Unlike UpdateCompany, a null clears a field.
+func (cc *CompanyController) PatchCompany(c *gin.Context) {
+	id := c.Param("id")
+	if !requireCompanyAccess(c, cc.config, id) {
+		return
+	}
+
+	var company models.Company
+	if err := cc.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": id}).Decode(&company); err != nil {
 		utils.BadRequest(c, "Company not found")
 		return
 	}
 
+	current := models.CompanyPatch{
+		ParentCompanyID:    company.ParentCompanyID,
+		Name:               company.Name,
+		TradingName:        company.TradingName,
+		Type:               company.Type,
+		RegistrationNumber: company.RegistrationNumber,
+		Country:            company.Country,
+		Address:            company.Address,
+		Phone:              company.Phone,
+		Email:              company.Email,
+		Website:            company.Website,
+		TaxID:              company.TaxID,
+		BusinessType:       company.BusinessType,
+	}
+	var patched models.CompanyPatch
+	if err := utils.BindPatch(c, current, &patched); err != nil {
+		respondPatchError(c, err)
+		return
+	}
+	patched.Country = strings.ToUpper(patched.Country)
+	patched.TaxID = utils.NormalizeTaxID(patched.TaxID)
+
+	// Detaching from a group needs no checks; moving into one does
+	if patched.ParentCompanyID != "" && patched.ParentCompanyID != company.ParentCompanyID {
+		if !requireCompanyAccess(c, cc.config, patched.ParentCompanyID) ||
+			!checkParentCompany(c, cc.config, id, patched.ParentCompanyID) {
+			return
+		}
+	}
+
+	if !cc.ensureTaxIDAvailable(c, patched.Country, patched.TaxID, id) {
+		return
+	}
+
+	fields, err := utils.PatchFields(patched)
+	if err != nil {
+		utils.InternalError(c, "Error updating company")
+		return
+	}
+	fields["updated_at"] = time.Now()
+	fields["updated_by"] = c.GetString("user_id")
+
+	// Only write if nobody changed the company since it was read
+	result := cc.config.MongoDB.Collection("companies").FindOneAndUpdate(
+		c,
+		bson.M{"_id": id, "updated_at": company.UpdatedAt},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedCompany models.Company
+	if err := result.Decode(&updatedCompany); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondPatchConflict(c)
+		} else if mongo.IsDuplicateKeyError(err) {
+			respondTaxIDTaken(c)
+		} else {
+			utils.InternalError(c, "Error updating company")
+		}
+		return
+	}
+
+	recordChange(c, cc.config, models.EntityCompany, id, models.ChangeActionUpdate, company, updatedCompany)
+
 	c.JSON(http.StatusOK, updatedCompany)
 }
 
 // DeleteCompany deletes a company
 func (cc *CompanyController) DeleteCompany(c *gin.Context) {
 	id := c.Param("id")
+	if !requireCompanyAccess(c, cc.config, id) {
+		return
+	}
 
-	result, err := cc.config.MongoDB.Collection("companies").DeleteOne(c, bson.M{"_id": id})
+	// Subsidiaries would be left pointing at a parent that is gone
+	subsidiaries, err := cc.config.MongoDB.Collection("companies").CountDocuments(c, bson.M{"parent_company_id": id})
 	if err != nil {
 		utils.InternalError(c, "Error deleting company")
 		return
 	}
+	if subsidiaries > 0 {
+		utils.HandleError(c, http.StatusConflict, "Company has subsidiaries; move or delete them first")
+		return
+	}
 
-	if result.DeletedCount == 0 {
+	var company models.Company
+	err = cc.config.MongoDB.Collection("companies").FindOneAndDelete(c, bson.M{"_id": id}).Decode(&company)
+	if err == mongo.ErrNoDocuments {
 		utils.BadRequest(c, "Company not found")
 		return
 	}
+	if err != nil {
+		utils.InternalError(c, "Error deleting company")
+		return
+	}
+
+	recordChange(c, cc.config, models.EntityCompany, id, models.ChangeActionDelete, company, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Company deleted successfully"})
 }
 
-// ListCompanies lists all companies with pagination
+// ListCompanies lists the companies the caller may see with pagination
 func (cc *CompanyController) ListCompanies(c *gin.Context) {
+	companyIDs, ok := callerCompanyIDs(c, cc.config)
+	if !ok {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, companyQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(scopeFilter(bson.M{}, "_id", companyIDs))
+
+	shape, err := utils.ParseShape(c, companyShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	if format, ok := utils.GetExportFormat(c); ok {
+		utils.Export(c, format, cc.config.MongoDB.Collection("companies"), filter, query.Sort,
+			"companies", companyExportColumns)
+		return
+	}
+
+	if utils.IsCursorRequest(c) {
+		params, err := utils.GetCursorParams(c)
+		if err != nil {
+			utils.BadRequest(c, err.Error())
+			return
+		}
+
+		companies, page, err := utils.FindCursorPage[models.Company](c, cc.config.MongoDB.Collection("companies"), filter, params)
+		if err != nil {
+			utils.InternalError(c, "Error fetching companies")
+			return
+		}
+
+		data, err := utils.ApplyShape(c, cc.config.MongoDB.Collection("companies"), shape, companies)
+		if err != nil {
+			utils.InternalError(c, "Error fetching companies")
+			return
+		}
+
+		utils.SendCursorResponse(c, data, page)
+		return
+	}
+
 	page, limit := utils.GetPaginationParams(c)
 	skip := (page - 1) * limit
 
 	// Get total count
-	total, err := cc.config.MongoDB.Collection("companies").CountDocuments(c, bson.M{})
+	total, err := cc.config.MongoDB.Collection("companies").CountDocuments(c, filter)
 	if err != nil {
 		utils.InternalError(c, "Error counting companies")
 		return
@@ -155,12 +504,12 @@ func (cc *CompanyController) ListCompanies(c *gin.Context) {
 
 	// Set options for pagination and sorting
 	opts := options.Find().
-		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSort(query.Sort).
 		SetSkip(int64(skip)).
 		SetLimit(int64(limit))
 
 	// Fetch companies
-	cursor, err := cc.config.MongoDB.Collection("companies").Find(c, bson.M{}, opts)
+	cursor, err := cc.config.MongoDB.Collection("companies").Find(c, filter, opts)
 	if err != nil {
 		utils.InternalError(c, "Error fetching companies")
 		return
@@ -173,5 +522,36 @@ func (cc *CompanyController) ListCompanies(c *gin.Context) {
 		return
 	}
 
-	utils.SendPaginatedResponse(c, companies, total, page, limit)
+	data, err := utils.ApplyShape(c, cc.config.MongoDB.Collection("companies"), shape, companies)
+	if err != nil {
+		utils.InternalError(c, "Error fetching companies")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, data, total, page, limit)
+}
+
+// ensureTaxIDAvailable writes a 409 and returns false when another company
+// in the country already has the tax ID. The unique index backs this up
+// against concurrent writes.
+func (cc *CompanyController) ensureTaxIDAvailable(c *gin.Context, country, taxID, exceptID string) bool {
+	filter := bson.M{"country": country, "tax_id": taxID}
+	if exceptID != "" {
+		filter["_id"] = bson.M{"$ne": exceptID}
+	}
+
+	count, err := cc.config.MongoDB.Collection("companies").CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error checking tax ID")
+		return false
+	}
+	if count > 0 {
+		respondTaxIDTaken(c)
+		return false
+	}
+	return true
+}
+
+func respondTaxIDTaken(c *gin.Context) {
+	utils.HandleError(c, http.StatusConflict, "A company with this tax ID already exists")
 }
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// companyDescendants lists the subsidiaries below companyID, nearest
+// levels first
+func companyDescendants(ctx context.Context, cfg *config.Config, companyID string) ([]models.CompanyNode, error) {
+	return walkCompanyTree(ctx, cfg, companyID, "$_id", "_id", "parent_company_id")
+}
+
+// companyAncestors lists the parents above companyID, its direct parent
+// first
+func companyAncestors(ctx context.Context, cfg *config.Config, companyID string) ([]models.CompanyNode, error) {
+	return walkCompanyTree(ctx, cfg, companyID, "$parent_company_id", "parent_company_id", "_id")
+}
+
+func walkCompanyTree(ctx context.Context, cfg *config.Config, companyID, startWith, from, to string) ([]models.CompanyNode, error) {
+	cursor, err := cfg.MongoDB.Collection("companies").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": companyID}}},
+		{{Key: "$graphLookup", Value: bson.M{
+			"from":             "companies",
+			"startWith":        startWith,
+			"connectFromField": from,
+			"connectToField":   to,
+			"as":               "nodes",
+			"maxDepth":         utils.MaxGroupDepth - 1,
+			"depthField":       "depth",
+		}}},
+		{{Key: "$unwind", Value: "$nodes"}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$nodes"}}},
+		// $graphLookup counts from 0; a direct child or parent is depth 1
+		{{Key: "$addFields", Value: bson.M{"depth": bson.M{"$add": bson.A{"$depth", 1}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "depth", Value: 1}, {Key: "name", Value: 1}, {Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	nodes := []models.CompanyNode{}
+	if err := cursor.All(ctx, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// checkParentCompany validates a new parent for companyID, which is empty
+// for a company being created. When it is invalid an error response is
+// written and false returned.
+func checkParentCompany(c *gin.Context, cfg *config.Config, companyID, parentID string) bool {
+	problem, err := parentCompanyProblem(c, cfg, companyID, parentID)
+	if err != nil {
+		utils.InternalError(c, "Error fetching company group")
+		return false
+	}
+	if problem != "" {
+		utils.BadRequest(c, problem)
+		return false
+	}
+	return true
+}
+
+// parentCompanyProblem says what is wrong with parentID as the new parent
+// of companyID, or returns "" when nothing is. The parent must exist, must
+// not be the company itself or one of its subsidiaries, which would make a
+// cycle, and must not make the group deeper than utils.MaxGroupDepth.
+func parentCompanyProblem(ctx context.Context, cfg *config.Config, companyID, parentID string) (string, error) {
+	if parentID == "" {
+		return "", nil
+	}
+	if parentID == companyID {
+		return "A company cannot be its own parent", nil
+	}
+
+	count, err := cfg.MongoDB.Collection("companies").CountDocuments(ctx, bson.M{"_id": parentID})
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "Parent company not found", nil
+	}
+
+	// Levels the company brings with it: itself and its deepest subsidiary
+	levels := 1
+	if companyID != "" {
+		descendants, err := companyDescendants(ctx, cfg, companyID)
+		if err != nil {
+			return "", err
+		}
+		for _, node := range descendants {
+			if node.ID == parentID {
+				return "Parent company is a subsidiary of this company", nil
+			}
+			if node.Depth+1 > levels {
+				levels = node.Depth + 1
+			}
+		}
+	}
+
+	ancestors, err := companyAncestors(ctx, cfg, parentID)
+	if err != nil {
+		return "", err
+	}
+	// The parent and everything above it, plus the levels being attached
+	if len(ancestors)+1+levels > utils.MaxGroupDepth {
+		return "Company group would be too deep", nil
+	}
+	return "", nil
+}
+
+// companyGroupRoot returns the top company of the group companyID is in,
+// which is companyID itself when it has no parent
+func companyGroupRoot(ctx context.Context, cfg *config.Config, companyID string) (string, error) {
+	ancestors, err := companyAncestors(ctx, cfg, companyID)
+	if err != nil {
+		return "", err
+	}
+	if len(ancestors) == 0 {
+		return companyID, nil
+	}
+	return ancestors[len(ancestors)-1].ID, nil
+}
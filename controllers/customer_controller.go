@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Fields clients may filter and sort customers on
+var customerQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"branch_id":           utils.FieldString,
+		"type":                utils.FieldString,
+		"name":                utils.FieldString,
+		"national_id":         utils.FieldString,
+		"registration_number": utils.FieldString,
+		"tax_id":              utils.FieldString,
+		"employer":            utils.FieldString,
+		"address.city":        utils.FieldString,
+		"address.country":     utils.FieldString,
+		"phone":               utils.FieldString,
+		"email":               utils.FieldString,
+		"annual_income":       utils.FieldNumber,
+		"created_by":          utils.FieldString,
+		"created_at":          utils.FieldTime,
+		"updated_at":          utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"name":          true,
+		"annual_income": true,
+		"created_at":    true,
+		"updated_at":    true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
+// Fields and expansions clients may request on customers
+var customerShapeSpec = utils.ShapeSpec{
+	Fields: map[string]bool{
+		"id": true, "company_id": true, "branch_id": true, "type": true, "name": true, "first_name": true,
+		"last_name": true, "national_id": true, "date_of_birth": true, "employer": true, "business_name": true,
+		"registration_number": true, "tax_id": true, "address": true, "phone": true, "email": true,
+		"annual_income": true, "created_by": true, "updated_by": true, "created_at": true, "updated_at": true,
+	},
+	Expansions: map[string]utils.Expansion{
+		"branch": {
+			From:   "branch_offices",
+			Let:    bson.M{"branch_id": "$branch_id"},
+			Match:  bson.M{"$eq": bson.A{"$_id", "$$branch_id"}},
+			Single: true,
+		},
+	},
+}
+
+// Columns written when customers are exported
+var customerExportColumns = []string{
+	"id", "company_id", "branch_id", "type", "name", "first_name", "last_name", "national_id", "date_of_birth",
+	"employer", "business_name", "registration_number", "tax_id", "address", "phone", "email", "annual_income",
+	"created_by", "updated_by", "created_at", "updated_at",
+}
+
+// CustomerController manages a company's borrowers. Admins see all of a
+// company's customers; staff of the company only see those of the branch
+// offices they hold a role at.
+type CustomerController struct {
+	config *config.Config
+}
+
+func NewCustomerController(config *config.Config) *CustomerController {
+	return &CustomerController{config: config}
+}
+
+// CreateCustomer adds a borrower to one of a company's branch offices
+func (cc *CustomerController) CreateCustomer(c *gin.Context) {
+	companyID := c.Param("id")
+	scope, ok := customerScope(c, cc.config, companyID)
+	if !ok {
+		return
+	}
+
+	var req models.CustomerDetails
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+	if !cc.checkBranch(c, companyID, req.BranchID, scope) {
+		return
+	}
+	req.TaxID = utils.NormalizeTaxID(req.TaxID)
+
+	customer := models.Customer{
+		ID:              primitive.NewObjectID().Hex(),
+		CompanyID:       companyID,
+		Name:            req.DisplayName(),
+		CustomerDetails: req,
+		CreatedBy:       c.GetString("user_id"),
+		UpdatedBy:       c.GetString("user_id"),
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+
+	if _, err := cc.config.MongoDB.Collection("customers").InsertOne(c, customer); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			utils.HandleError(c, http.StatusConflict, "A customer with this national ID already exists")
+		} else {
+			utils.InternalError(c, "Error creating customer")
+		}
+		return
+	}
+
+	recordChange(c, cc.config, models.EntityCustomer, customer.ID, models.ChangeActionCreate, nil, customer)
+
+	c.JSON(http.StatusCreated, customer)
+}
+
+// GetCustomer gets a customer by ID
+func (cc *CustomerController) GetCustomer(c *gin.Context) {
+	scope, ok := customerScope(c, cc.config, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	shape, err := utils.ParseShape(c, customerShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	scope["_id"] = c.Param("customer_id")
+	var customer models.Customer
+	if err := cc.config.MongoDB.Collection("customers").FindOne(c, scope).Decode(&customer); err != nil {
+		utils.BadRequest(c, "Customer not found")
+		return
+	}
+
+	data, err := utils.ApplyShapeOne(c, cc.config.MongoDB.Collection("customers"), shape, customer)
+	if err != nil {
+		utils.InternalError(c, "Error fetching customer")
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// UpdateCustomer replaces a customer's details. Moving the customer to
+// another branch office needs access to both.
+func (cc *CustomerController) UpdateCustomer(c *gin.Context) {
+	companyID := c.Param("id")
+	customerID := c.Param("customer_id")
+	scope, ok := customerScope(c, cc.config, companyID)
+	if !ok {
+		return
+	}
+
+	var req models.CustomerDetails
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	scope["_id"] = customerID
+	var customer models.Customer
+	if err := cc.config.MongoDB.Collection("customers").FindOne(c, scope).Decode(&customer); err != nil {
+		utils.BadRequest(c, "Customer not found")
+		return
+	}
+	if req.BranchID != customer.BranchID && !cc.checkBranch(c, companyID, req.BranchID, scope) {
+		return
+	}
+	req.TaxID = utils.NormalizeTaxID(req.TaxID)
+
+	fields, err := utils.PatchFields(req)
+	if err != nil {
+		utils.InternalError(c, "Error updating customer")
+		return
+	}
+	fields["name"] = req.DisplayName()
+	fields["updated_at"] = time.Now()
+	fields["updated_by"] = c.GetString("user_id")
+
+	cc.writeCustomer(c, customer, fields)
+}
+
+// PatchCustomer applies a JSON Merge Patch or JSON Patch to a customer
+func (cc *CustomerController) PatchCustomer(c *gin.Context) {
+	companyID := c.Param("id")
+	customerID := c.Param("customer_id")
+	scope, ok := customerScope(c, cc.config, companyID)
+	if !ok {
+		return
+	}
+
+	scope["_id"] = customerID
+	var customer models.Customer
+	if err := cc.config.MongoDB.Collection("customers").FindOne(c, scope).Decode(&customer); err != nil {
+		utils.BadRequest(c, "Customer not found")
+		return
+	}
+
+	var patched models.CustomerDetails
+	if err := utils.BindPatch(c, customer.CustomerDetails, &patched); err != nil {
+		respondPatchError(c, err)
+		return
+	}
+	if patched.BranchID != customer.BranchID && !cc.checkBranch(c, companyID, patched.BranchID, scope) {
+		return
+	}
+	patched.TaxID = utils.NormalizeTaxID(patched.TaxID)
+
+	fields, err := utils.PatchFields(patched)
+	if err != nil {
+		utils.InternalError(c, "Error updating customer")
+		return
+	}
+	fields["name"] = patched.DisplayName()
+	fields["updated_at"] = time.Now()
+	fields["updated_by"] = c.GetString("user_id")
+
+	cc.writeCustomer(c, customer, fields)
+}
+
+// ListCustomers lists the customers of a company the caller may see
+func (cc *CustomerController) ListCustomers(c *gin.Context) {
+	scope, ok := customerScope(c, cc.config, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, customerQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(scope)
+
+	shape, err := utils.ParseShape(c, customerShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	sendGroupList[models.Customer](c, cc.config.MongoDB.Collection("customers"), filter, query.Sort, shape,
+		"customers", customerExportColumns, "customers")
+}
+
+// DeleteCustomer deletes a customer who has no loan applications
+func (cc *CustomerController) DeleteCustomer(c *gin.Context) {
+	scope, ok := customerScope(c, cc.config, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	// Loans refer to their customer, so a customer with loans stays
+	loans, err := cc.config.MongoDB.Collection("loans").CountDocuments(c, bson.M{"customer_id": c.Param("customer_id")})
+	if err != nil {
+		utils.InternalError(c, "Error fetching loan applications")
+		return
+	}
+	if loans > 0 {
+		utils.HandleError(c, http.StatusConflict, "Customers with loan applications cannot be deleted")
+		return
+	}
+
+	scope["_id"] = c.Param("customer_id")
+	var customer models.Customer
+	err = cc.config.MongoDB.Collection("customers").FindOneAndDelete(c, scope).Decode(&customer)
+	if err == mongo.ErrNoDocuments {
+		utils.BadRequest(c, "Customer not found")
+		return
+	}
+	if err != nil {
+		utils.InternalError(c, "Error deleting customer")
+		return
+	}
+
+	recordChange(c, cc.config, models.EntityCustomer, customer.ID, models.ChangeActionDelete, customer, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Customer deleted successfully"})
+}
+
+// writeCustomer sets fields on customer, provided nobody changed it since
+// it was read, and answers with the result
+func (cc *CustomerController) writeCustomer(c *gin.Context, customer models.Customer, fields bson.M) {
+	result := cc.config.MongoDB.Collection("customers").FindOneAndUpdate(
+		c,
+		bson.M{
+			"_id":        customer.ID,
+			"company_id": customer.CompanyID,
+			"updated_at": customer.UpdatedAt,
+		},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedCustomer models.Customer
+	if err := result.Decode(&updatedCustomer); err != nil {
+		switch {
+		case err == mongo.ErrNoDocuments:
+			respondPatchConflict(c)
+		case mongo.IsDuplicateKeyError(err):
+			utils.HandleError(c, http.StatusConflict, "A customer with this national ID already exists")
+		default:
+			utils.InternalError(c, "Error updating customer")
+		}
+		return
+	}
+
+	recordChange(c, cc.config, models.EntityCustomer, customer.ID, models.ChangeActionUpdate, customer, updatedCustomer)
+
+	c.JSON(http.StatusOK, updatedCustomer)
+}
+
+// checkBranch checks a customer's branch office belongs to the company and
+// is one the caller may see customers of
+func (cc *CustomerController) checkBranch(c *gin.Context, companyID, branchID string, scope bson.M) bool {
+	filter := bson.M{"_id": branchID, "company_id": companyID}
+	if branchIDs, ok := scope["branch_id"]; ok {
+		filter["$and"] = bson.A{bson.M{"_id": branchIDs}}
+	}
+
+	count, err := cc.config.MongoDB.Collection("branch_offices").CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error fetching branch offices")
+		return false
+	}
+	if count == 0 {
+		utils.BadRequest(c, "Invalid branch office ID: "+branchID)
+		return false
+	}
+	return true
+}
+
+// customerScope returns the filter for the customers of companyID the
+// caller may see. Admins and super users with access to the company see
+// all of them. Staff must belong to the company and only see the customers
+// of branch offices they hold a role at. Anyone else gets a 403, and ok is
+// false whenever an error response has been written.
+func customerScope(c *gin.Context, cfg *config.Config, companyID string) (bson.M, bool) {
+	if !requireCompanyAccess(c, cfg, companyID) {
+		return nil, false
+	}
+
+	filter := bson.M{"company_id": companyID}
+	if utils.HasRole(c, models.RoleAdmin) || utils.HasRole(c, models.RoleSuperUser) {
+		return filter, true
+	}
+
+	var user models.User
+	err := cfg.MongoDB.Collection("users").FindOne(c, bson.M{"_id": c.GetString("user_id")}).Decode(&user)
+	if err != nil {
+		utils.BadRequest(c, "User not found")
+		return nil, false
+	}
+	if !user.IsStaff() || user.CompanyID != companyID {
+		utils.Forbidden(c, "Only staff of this company can see its customers")
+		return nil, false
+	}
+
+	branchIDs := make([]string, 0, len(user.BranchRoles))
+	for _, assignment := range user.BranchRoles {
+		branchIDs = append(branchIDs, assignment.BranchID)
+	}
+	filter["branch_id"] = bson.M{"$in": branchIDs}
+	return filter, true
+}
@@ -0,0 +1,547 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxDocumentSize = 20 << 20
+
+// Content types accepted for company documents, detected from the file
+// contents rather than trusted from the client
+var allowedDocumentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+}
+
+type DocumentController struct {
+	config *config.Config
+}
+
+func NewDocumentController(config *config.Config) *DocumentController {
+	return &DocumentController{config: config}
+}
+
+// CreateDocument uploads the first version of a company document
+func (dc *DocumentController) CreateDocument(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, dc.config, companyID) {
+		return
+	}
+
+	// Verify company exists
+	var company models.Company
+	err := dc.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": companyID}).Decode(&company)
+	if err != nil {
+		utils.BadRequest(c, "Company not found")
+		return
+	}
+
+	var req models.DocumentMetadataRequest
+	if err := c.ShouldBind(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	issuedDate, expiryDate, err := parseDocumentDates(req.IssuedDate, req.ExpiryDate)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	id := primitive.NewObjectID().Hex()
+	version, ok := dc.storeUpload(c, companyID, id, 1)
+	if !ok {
+		return
+	}
+
+	userID := c.GetString("user_id")
+	document := models.CompanyDocument{
+		Document: models.Document{
+			Type:        req.Type,
+			Number:      req.Number,
+			URL:         documentURL(companyID, id),
+			IssuedDate:  issuedDate,
+			ExpiryDate:  expiryDate,
+			Description: req.Description,
+		},
+		ID:             id,
+		CompanyID:      companyID,
+		CurrentVersion: 1,
+		Versions:       []models.DocumentVersion{*version},
+		CreatedBy:      userID,
+		UpdatedBy:      userID,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	if _, err := dc.config.MongoDB.Collection("company_documents").InsertOne(c, document); err != nil {
+		dc.config.Blobs.Delete(c, version.StorageKey)
+		utils.InternalError(c, "Error creating document")
+		return
+	}
+
+	recordChange(c, dc.config, models.EntityDocument, id, models.ChangeActionCreate, nil, document)
+
+	c.JSON(http.StatusCreated, document)
+}
+
+// UploadDocumentVersion adds a new version of an existing document
+func (dc *DocumentController) UploadDocumentVersion(c *gin.Context) {
+	companyID := c.Param("id")
+	documentID := c.Param("document_id")
+	if !requireCompanyAccess(c, dc.config, companyID) {
+		return
+	}
+
+	var document models.CompanyDocument
+	err := dc.config.MongoDB.Collection("company_documents").FindOne(c,
+		bson.M{
+			"_id":        documentID,
+			"company_id": companyID,
+		}).Decode(&document)
+	if err != nil {
+		utils.BadRequest(c, "Document not found")
+		return
+	}
+
+	next := document.CurrentVersion + 1
+	version, ok := dc.storeUpload(c, companyID, documentID, next)
+	if !ok {
+		return
+	}
+
+	// Guard on current_version so two concurrent uploads cannot both
+	// claim the same version number
+	result := dc.config.MongoDB.Collection("company_documents").FindOneAndUpdate(
+		c,
+		bson.M{
+			"_id":             documentID,
+			"company_id":      companyID,
+			"current_version": document.CurrentVersion,
+		},
+		bson.M{
+			"$set": bson.M{
+				"current_version": next,
+				"updated_by":      c.GetString("user_id"),
+				"updated_at":      time.Now(),
+			},
+			"$push": bson.M{"versions": version},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedDocument models.CompanyDocument
+	if err := result.Decode(&updatedDocument); err != nil {
+		dc.config.Blobs.Delete(c, version.StorageKey)
+		if err == mongo.ErrNoDocuments {
+			utils.HandleError(c, http.StatusConflict, "Document was modified, please retry")
+			return
+		}
+		utils.InternalError(c, "Error updating document")
+		return
+	}
+
+	recordChange(c, dc.config, models.EntityDocument, documentID, models.ChangeActionUpdate, document, updatedDocument)
+
+	c.JSON(http.StatusOK, updatedDocument)
+}
+
+// GetDocument gets a document's metadata and version history
+func (dc *DocumentController) GetDocument(c *gin.Context) {
+	if !requireCompanyAccess(c, dc.config, c.Param("id")) {
+		return
+	}
+
+	var document models.CompanyDocument
+	err := dc.config.MongoDB.Collection("company_documents").FindOne(c,
+		bson.M{
+			"_id":        c.Param("document_id"),
+			"company_id": c.Param("id"),
+		}).Decode(&document)
+	if err != nil {
+		utils.BadRequest(c, "Document not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, document)
+}
+
+// DownloadDocument streams the current version of a document, or the one
+// given by ?version=
+func (dc *DocumentController) DownloadDocument(c *gin.Context) {
+	if !requireCompanyAccess(c, dc.config, c.Param("id")) {
+		return
+	}
+
+	var document models.CompanyDocument
+	err := dc.config.MongoDB.Collection("company_documents").FindOne(c,
+		bson.M{
+			"_id":        c.Param("document_id"),
+			"company_id": c.Param("id"),
+		}).Decode(&document)
+	if err != nil {
+		utils.BadRequest(c, "Document not found")
+		return
+	}
+
+	wanted := document.CurrentVersion
+	if versionStr := c.Query("version"); versionStr != "" {
+		wanted, err = strconv.Atoi(versionStr)
+		if err != nil {
+			utils.BadRequest(c, "Invalid version")
+			return
+		}
+	}
+
+	var version *models.DocumentVersion
+	for i := range document.Versions {
+		if document.Versions[i].Version == wanted {
+			version = &document.Versions[i]
+			break
+		}
+	}
+	if version == nil {
+		utils.BadRequest(c, "Version not found")
+		return
+	}
+
+	blob, err := dc.config.Blobs.Get(c, version.StorageKey)
+	if err != nil {
+		utils.InternalError(c, "Error reading document")
+		return
+	}
+	defer blob.Close()
+
+	c.DataFromReader(http.StatusOK, version.Size, version.ContentType, blob, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", version.Filename),
+		"ETag":                `"` + version.Checksum + `"`,
+	})
+}
+
+// ListDocuments lists a company's documents with pagination
+func (dc *DocumentController) ListDocuments(c *gin.Context) {
+	if !requireCompanyAccess(c, dc.config, c.Param("id")) {
+		return
+	}
+
+	filter := bson.M{"company_id": c.Param("id")}
+	if docType := c.Query("type"); docType != "" {
+		filter["type"] = docType
+	}
+
+	page, limit := utils.GetPaginationParams(c)
+	skip := (page - 1) * limit
+
+	// Get total count
+	total, err := dc.config.MongoDB.Collection("company_documents").CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error counting documents")
+		return
+	}
+
+	// Set options for pagination and sorting
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := dc.config.MongoDB.Collection("company_documents").Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching documents")
+		return
+	}
+	defer cursor.Close(c)
+
+	var documents []models.CompanyDocument
+	if err = cursor.All(c, &documents); err != nil {
+		utils.InternalError(c, "Error parsing documents")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, documents, total, page, limit)
+}
+
+// UpdateDocument updates a document's metadata. The file itself is changed
+// by uploading a new version.
+func (dc *DocumentController) UpdateDocument(c *gin.Context) {
+	companyID := c.Param("id")
+	documentID := c.Param("document_id")
+	if !requireCompanyAccess(c, dc.config, companyID) {
+		return
+	}
+
+	var req models.UpdateDocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	var document models.CompanyDocument
+	err := dc.config.MongoDB.Collection("company_documents").FindOne(c,
+		bson.M{
+			"_id":        documentID,
+			"company_id": companyID,
+		}).Decode(&document)
+	if err != nil {
+		utils.BadRequest(c, "Document not found")
+		return
+	}
+
+	issuedDate, expiryDate, err := parseDocumentDates(req.IssuedDate, req.ExpiryDate)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"updated_at": time.Now(),
+			"updated_by": c.GetString("user_id"),
+		},
+	}
+
+	// Only update fields that are provided
+	if req.Type != "" {
+		update["$set"].(bson.M)["type"] = req.Type
+	}
+	if req.Number != "" {
+		update["$set"].(bson.M)["number"] = req.Number
+	}
+	if req.Description != "" {
+		update["$set"].(bson.M)["description"] = req.Description
+	}
+	if req.IssuedDate != "" {
+		update["$set"].(bson.M)["issued_date"] = issuedDate
+	}
+	if req.ExpiryDate != "" {
+		update["$set"].(bson.M)["expiry_date"] = expiryDate
+	}
+
+	result := dc.config.MongoDB.Collection("company_documents").FindOneAndUpdate(
+		c,
+		bson.M{
+			"_id":        documentID,
+			"company_id": companyID,
+		},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedDocument models.CompanyDocument
+	if err := result.Decode(&updatedDocument); err != nil {
+		utils.BadRequest(c, "Document not found")
+		return
+	}
+
+	recordChange(c, dc.config, models.EntityDocument, documentID, models.ChangeActionUpdate, document, updatedDocument)
+
+	c.JSON(http.StatusOK, updatedDocument)
+}
+
+// DeleteDocument deletes a document and every stored version of it
+func (dc *DocumentController) DeleteDocument(c *gin.Context) {
+	if !requireCompanyAccess(c, dc.config, c.Param("id")) {
+		return
+	}
+
+	documentID := c.Param("document_id")
+
+	var document models.CompanyDocument
+	err := dc.config.MongoDB.Collection("company_documents").FindOneAndDelete(c,
+		bson.M{
+			"_id":        documentID,
+			"company_id": c.Param("id"),
+		}).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		utils.BadRequest(c, "Document not found")
+		return
+	}
+	if err != nil {
+		utils.InternalError(c, "Error deleting document")
+		return
+	}
+
+	for _, version := range document.Versions {
+		if err := dc.config.Blobs.Delete(c, version.StorageKey); err != nil {
+			utils.Error("Error deleting document blob: " + err.Error())
+		}
+	}
+
+	recordChange(c, dc.config, models.EntityDocument, documentID, models.ChangeActionDelete, document, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
+}
+
+// ListExpiringDocuments lists documents whose expiry date falls within the
+// next ?days= days (default 30), soonest first. Super users see every
+// company; everyone else sees their own.
+func (dc *DocumentController) ListExpiringDocuments(c *gin.Context) {
+	days := 30
+	if daysStr := c.Query("days"); daysStr != "" {
+		d, err := strconv.Atoi(daysStr)
+		if err != nil || d < 0 {
+			utils.BadRequest(c, "Invalid days")
+			return
+		}
+		days = d
+	}
+
+	companyIDs, ok := callerCompanyIDs(c, dc.config)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	filter := bson.M{
+		"expiry_date": bson.M{
+			"$gte": now,
+			"$lte": now.AddDate(0, 0, days),
+		},
+	}
+	scopeFilter(filter, "company_id", companyIDs)
+
+	page, limit := utils.GetPaginationParams(c)
+	skip := (page - 1) * limit
+
+	// Get total count
+	total, err := dc.config.MongoDB.Collection("company_documents").CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error counting documents")
+		return
+	}
+
+	// Set options for pagination and sorting
+	opts := options.Find().
+		SetSort(bson.D{{Key: "expiry_date", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := dc.config.MongoDB.Collection("company_documents").Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching documents")
+		return
+	}
+	defer cursor.Close(c)
+
+	var documents []models.CompanyDocument
+	if err = cursor.All(c, &documents); err != nil {
+		utils.InternalError(c, "Error parsing documents")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, documents, total, page, limit)
+}
+
+// storeUpload validates the uploaded "file" field, writes it to the blob
+// store and returns its version record. The content type is sniffed from
+// the data, and an optional "checksum" field (hex SHA-256) is verified.
+func (dc *DocumentController) storeUpload(c *gin.Context, companyID, documentID string, versionNumber int) (*models.DocumentVersion, bool) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		utils.BadRequest(c, "File is required")
+		return nil, false
+	}
+	if fileHeader.Size > maxDocumentSize {
+		utils.BadRequest(c, "File is too large")
+		return nil, false
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		utils.BadRequest(c, "Error reading file")
+		return nil, false
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		utils.BadRequest(c, "Error reading file")
+		return nil, false
+	}
+	head = head[:n]
+
+	contentType := strings.Split(http.DetectContentType(head), ";")[0]
+	if !allowedDocumentTypes[contentType] {
+		utils.BadRequest(c, "Unsupported file type: "+contentType)
+		return nil, false
+	}
+
+	// Every upload gets its own key, so an upload that loses the race for
+	// a version number never overwrites, or deletes, the one that won
+	key := fmt.Sprintf("companies/%s/documents/%s/%s", companyID, documentID, primitive.NewObjectID().Hex())
+	hash := sha256.New()
+	counter := &countingWriter{}
+	reader := io.TeeReader(io.MultiReader(bytes.NewReader(head), file), io.MultiWriter(hash, counter))
+
+	if err := dc.config.Blobs.Put(c, key, reader); err != nil {
+		utils.InternalError(c, "Error storing file")
+		return nil, false
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if expected := c.PostForm("checksum"); expected != "" && !strings.EqualFold(expected, checksum) {
+		dc.config.Blobs.Delete(c, key)
+		utils.BadRequest(c, "Checksum mismatch")
+		return nil, false
+	}
+
+	return &models.DocumentVersion{
+		Version:     versionNumber,
+		Filename:    fileHeader.Filename,
+		ContentType: contentType,
+		Size:        counter.n,
+		Checksum:    checksum,
+		StorageKey:  key,
+		UploadedBy:  c.GetString("user_id"),
+		UploadedAt:  time.Now(),
+	}, true
+}
+
+func parseDocumentDates(issued, expiry string) (issuedDate, expiryDate time.Time, err error) {
+	if issued != "" {
+		if issuedDate, _, err = utils.ParseDate(issued); err != nil {
+			return issuedDate, expiryDate, fmt.Errorf("Invalid issued_date")
+		}
+	}
+	if expiry != "" {
+		if expiryDate, _, err = utils.ParseDate(expiry); err != nil {
+			return issuedDate, expiryDate, fmt.Errorf("Invalid expiry_date")
+		}
+	}
+	if !issuedDate.IsZero() && !expiryDate.IsZero() && expiryDate.Before(issuedDate) {
+		return issuedDate, expiryDate, fmt.Errorf("expiry_date is before issued_date")
+	}
+	return issuedDate, expiryDate, nil
+}
+
+func documentURL(companyID, documentID string) string {
+	return fmt.Sprintf("/api/companies/%s/documents/%s/download", companyID, documentID)
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GroupController serves views across a company and all of its
+// subsidiaries, for admins of holding groups
+type GroupController struct {
+	config *config.Config
+}
+
+func NewGroupController(config *config.Config) *GroupController {
+	return &GroupController{config: config}
+}
+
+// ListSubsidiaries lists every company below a company in its group, with
+// its depth below it
+func (gc *GroupController) ListSubsidiaries(c *gin.Context) {
+	id := c.Param("id")
+	if !requireCompanyAccess(c, gc.config, id) {
+		return
+	}
+
+	nodes, err := companyDescendants(c, gc.config, id)
+	if err != nil {
+		utils.InternalError(c, "Error fetching subsidiaries")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": nodes})
+}
+
+// ListAncestors lists the parent companies above a company, its direct
+// parent first. Access only inherits down a group, so parents above the
+// caller's companies are shown by ID and name only.
+func (gc *GroupController) ListAncestors(c *gin.Context) {
+	id := c.Param("id")
+	if !requireCompanyAccess(c, gc.config, id) {
+		return
+	}
+	companyIDs, ok := callerCompanyIDs(c, gc.config)
+	if !ok {
+		return
+	}
+	visible := make(map[string]bool, len(companyIDs))
+	for _, companyID := range companyIDs {
+		visible[companyID] = true
+	}
+
+	nodes, err := companyAncestors(c, gc.config, id)
+	if err != nil {
+		utils.InternalError(c, "Error fetching parent companies")
+		return
+	}
+
+	data := make([]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		if companyIDs == nil || visible[node.ID] {
+			data = append(data, node)
+			continue
+		}
+		data = append(data, gin.H{
+			"id":    node.ID,
+			"name":  node.Name,
+			"depth": node.Depth,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+// ListGroupBranchOffices lists the branch offices of a company and all of
+// its subsidiaries
+func (gc *GroupController) ListGroupBranchOffices(c *gin.Context) {
+	groupIDs, ok := gc.groupIDs(c)
+	if !ok {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, branchOfficeQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(bson.M{"company_id": bson.M{"$in": groupIDs}})
+
+	shape, err := utils.ParseShape(c, branchOfficeShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	sendGroupList[models.BranchOffice](c, gc.config.MongoDB.Collection("branch_offices"), filter, query.Sort, shape,
+		"branch_offices", branchOfficeExportColumns, "branch offices")
+}
+
+// ListGroupStaff lists the staff members of a company and all of its
+// subsidiaries
+func (gc *GroupController) ListGroupStaff(c *gin.Context) {
+	groupIDs, ok := gc.groupIDs(c)
+	if !ok {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, staffQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(bson.M{
+		"roles":      models.RoleStaff,
+		"company_id": bson.M{"$in": groupIDs},
+	})
+
+	shape, err := utils.ParseShape(c, userShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	sendGroupList[models.User](c, gc.config.MongoDB.Collection("users"), filter, query.Sort, shape,
+		"staff", userExportColumns, "staff members")
+}
+
+// groupIDs checks the caller may see the company in the route and returns
+// it with its subsidiaries
+func (gc *GroupController) groupIDs(c *gin.Context) ([]string, bool) {
+	id := c.Param("id")
+	if !requireCompanyAccess(c, gc.config, id) {
+		return nil, false
+	}
+
+	groupIDs, err := utils.CompanyGroupIDs(c, gc.config.MongoDB, id)
+	if err != nil {
+		utils.InternalError(c, "Error fetching company group")
+		return nil, false
+	}
+	return groupIDs, true
+}
+
+// sendGroupList answers a list request as an export, a cursor page or an
+// offset page, the same ways the per-company list endpoints do
+func sendGroupList[T utils.CursorKeyer](c *gin.Context, collection *mongo.Collection, filter bson.M, sort bson.D,
+	shape utils.Shape, exportName string, exportColumns []string, noun string) {
+	if format, ok := utils.GetExportFormat(c); ok {
+		utils.Export(c, format, collection, filter, sort, exportName, exportColumns)
+		return
+	}
+
+	if utils.IsCursorRequest(c) {
+		params, err := utils.GetCursorParams(c)
+		if err != nil {
+			utils.BadRequest(c, err.Error())
+			return
+		}
+
+		items, page, err := utils.FindCursorPage[T](c, collection, filter, params)
+		if err != nil {
+			utils.InternalError(c, "Error fetching "+noun)
+			return
+		}
+
+		data, err := utils.ApplyShape(c, collection, shape, items)
+		if err != nil {
+			utils.InternalError(c, "Error fetching "+noun)
+			return
+		}
+
+		utils.SendCursorResponse(c, data, page)
+		return
+	}
+
+	page, limit := utils.GetPaginationParams(c)
+	skip := (page - 1) * limit
+
+	total, err := collection.CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error counting "+noun)
+		return
+	}
+
+	opts := options.Find().
+		SetSort(sort).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching "+noun)
+		return
+	}
+	defer cursor.Close(c)
+
+	var items []T
+	if err = cursor.All(c, &items); err != nil {
+		utils.InternalError(c, "Error parsing "+noun)
+		return
+	}
+
+	data, err := utils.ApplyShape(c, collection, shape, items)
+	if err != nil {
+		utils.InternalError(c, "Error fetching "+noun)
+		return
+	}
+
+	utils.SendPaginatedResponse(c, data, total, page, limit)
+}
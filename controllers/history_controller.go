@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type HistoryController struct {
+	config *config.Config
+}
+
+func NewHistoryController(config *config.Config) *HistoryController {
+	return &HistoryController{config: config}
+}
+
+// GetCompanyHistory lists the change log of a company the caller may see
+func (hc *HistoryController) GetCompanyHistory(c *gin.Context) {
+	if !requireCompanyAccess(c, hc.config, c.Param("id")) {
+		return
+	}
+
+	hc.listHistory(c, models.EntityCompany, c.Param("id"))
+}
+
+// GetBranchOfficeHistory lists the change log of a branch office of a
+// company the caller may see
+func (hc *HistoryController) GetBranchOfficeHistory(c *gin.Context) {
+	if !requireBranchAccess(c, hc.config, c.Param("id"), c.Param("branch_id")) {
+		return
+	}
+
+	hc.listHistory(c, models.EntityBranchOffice, c.Param("branch_id"))
+}
+
+// GetCustomerHistory lists the change log of a customer the caller may see
+func (hc *HistoryController) GetCustomerHistory(c *gin.Context) {
+	scope, ok := customerScope(c, hc.config, c.Param("id"))
+	if !ok {
+		return
+	}
+	scope["_id"] = c.Param("customer_id")
+	count, err := hc.config.MongoDB.Collection("customers").CountDocuments(c, scope)
+	if err != nil {
+		utils.InternalError(c, "Error fetching customer")
+		return
+	}
+	if count == 0 {
+		utils.BadRequest(c, "Customer not found")
+		return
+	}
+
+	hc.listHistory(c, models.EntityCustomer, c.Param("customer_id"))
+}
+
+// GetUserHistory lists the change log of the caller or of a user who is a
+// member of a company the caller may see
+func (hc *HistoryController) GetUserHistory(c *gin.Context) {
+	userID := c.Param("id")
+	if userID != c.GetString("user_id") {
+		companyIDs, ok := callerCompanyIDs(c, hc.config)
+		if !ok {
+			return
+		}
+		filter := scopeUserFilter(bson.M{"_id": userID}, companyIDs)
+		count, err := hc.config.MongoDB.Collection("users").CountDocuments(c, filter)
+		if err != nil {
+			utils.InternalError(c, "Error fetching user")
+			return
+		}
+		if count == 0 {
+			utils.BadRequest(c, "User not found")
+			return
+		}
+	}
+
+	hc.listHistory(c, models.EntityUser, userID)
+}
+
+// listHistory returns change log entries for an entity, newest first,
+// optionally narrowed by ?field=, ?from= and ?to=
+func (hc *HistoryController) listHistory(c *gin.Context, entityType models.EntityType, entityID string) {
+	page, limit := utils.GetPaginationParams(c)
+	skip := (page - 1) * limit
+
+	filter := bson.M{
+		"entity_type": entityType,
+		"entity_id":   entityID,
+	}
+
+	if field := c.Query("field"); field != "" {
+		filter["changes.field"] = field
+	}
+
+	createdAt := bson.M{}
+	if fromStr := c.Query("from"); fromStr != "" {
+		from, _, err := utils.ParseDate(fromStr)
+		if err != nil {
+			utils.BadRequest(c, "Invalid from date")
+			return
+		}
+		createdAt["$gte"] = from
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		to, dateOnly, err := utils.ParseDate(toStr)
+		if err != nil {
+			utils.BadRequest(c, "Invalid to date")
+			return
+		}
+		if dateOnly {
+			to = to.Add(24 * time.Hour)
+		}
+		createdAt["$lt"] = to
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	// Get total count
+	total, err := hc.config.MongoDB.Collection("change_logs").CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error counting history")
+		return
+	}
+
+	// Set options for pagination and sorting
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := hc.config.MongoDB.Collection("change_logs").Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching history")
+		return
+	}
+	defer cursor.Close(c)
+
+	var entries []models.ChangeLog
+	if err = cursor.All(c, &entries); err != nil {
+		utils.InternalError(c, "Error parsing history")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, entries, total, page, limit)
+}
+
+// recordChange writes an immutable change log entry for an entity on behalf
+// of the current request
+func recordChange(c *gin.Context, cfg *config.Config, entityType models.EntityType, entityID string,
+	action models.ChangeAction, before, after interface{}) {
+	utils.AddAuditResource(c, entityID)
+	writeChangeLog(c, cfg, c.GetString("user_id"), c.ClientIP(), entityType, entityID, action, before, after)
+}
+
+// writeChangeLog records a change outside of a request, such as from a
+// background job. A failure is logged rather than returned, since the change
+// itself has already been written by the time this is called.
+func writeChangeLog(ctx context.Context, cfg *config.Config, actorID, clientIP string, entityType models.EntityType,
+	entityID string, action models.ChangeAction, before, after interface{}) {
+	changes, err := utils.DiffDocuments(before, after)
+	if err != nil {
+		utils.Error("Error computing change log diff: "+err.Error(), utils.Fields(map[string]interface{}{
+			"entity_type": entityType,
+			"entity_id":   entityID,
+		}))
+		return
+	}
+
+	// Nothing actually changed
+	if action == models.ChangeActionUpdate && len(changes) == 0 {
+		return
+	}
+
+	entry := models.ChangeLog{
+		ID:         primitive.NewObjectID().Hex(),
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Changes:    changes,
+		ActorID:    actorID,
+		ClientIP:   clientIP,
+		CreatedAt:  time.Now(),
+	}
+
+	if _, err := cfg.MongoDB.Collection("change_logs").InsertOne(ctx, entry); err != nil {
+		utils.Error("Error recording change log: "+err.Error(), utils.Fields(map[string]interface{}{
+			"entity_type": entityType,
+			"entity_id":   entityID,
+		}))
+	}
+}
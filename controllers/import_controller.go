@@ -0,0 +1,533 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"loan/config"
+	"loan/models"
+	"loan/staffing"
+	"loan/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxImportFileSize = 10 << 20
+	// Files with more data rows than this run as a background job
+	importSyncRowLimit = 200
+	importBatchSize    = 100
+	maxStoredRowErrors = 1000
+	importJobTimeout   = 30 * time.Minute
+)
+
+type ImportController struct {
+	config   *config.Config
+	staffing *staffing.Service
+}
+
+func NewImportController(config *config.Config) *ImportController {
+	return &ImportController{
+		config:   config,
+		staffing: staffing.NewService(config.MongoDB),
+	}
+}
+
+// rowImporter turns one decoded row into a document ready to insert
+type rowImporter struct {
+	collection string
+	entityType models.EntityType
+	newRow     func() interface{}
+	prepare    func(ctx context.Context, row interface{}) (doc interface{}, id string, errs []string)
+	// inserted, when set, runs for each document once it has been written
+	inserted func(ctx context.Context, doc interface{})
+}
+
+// importCaller is what the user who started an import may do: the
+// companies they may import into and the roles they may give staff
+type importCaller struct {
+	companyIDs []string // nil for super users, who may import anywhere
+	roles      map[models.Role]bool
+}
+
+func (ic importCaller) canAccess(companyID string) bool {
+	if ic.companyIDs == nil {
+		return true
+	}
+	for _, id := range ic.companyIDs {
+		if id == companyID {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportCompanies imports companies from a spreadsheet
+func (ic *ImportController) ImportCompanies(c *gin.Context) {
+	ic.startImport(c, models.ImportCompanies, "")
+}
+
+// ImportBranchOffices imports branch offices for a company from a spreadsheet
+func (ic *ImportController) ImportBranchOffices(c *gin.Context) {
+	ic.startImport(c, models.ImportBranchOffices, c.Param("id"))
+}
+
+// ImportStaff imports staff members for a company from a spreadsheet
+func (ic *ImportController) ImportStaff(c *gin.Context) {
+	ic.startImport(c, models.ImportStaff, c.Param("id"))
+}
+
+// GetImportJob returns the status of an import job. Other than the user
+// who started it, only users with access to the company imported into may
+// see it, and only super users may see other users' company imports.
+func (ic *ImportController) GetImportJob(c *gin.Context) {
+	var job models.ImportJob
+	err := ic.config.MongoDB.Collection("import_jobs").FindOne(c, bson.M{"_id": c.Param("job_id")}).Decode(&job)
+	if err != nil {
+		utils.BadRequest(c, "Import job not found")
+		return
+	}
+	if job.CreatedBy != c.GetString("user_id") {
+		if job.CompanyID == "" && !utils.HasRole(c, models.RoleSuperUser) {
+			utils.BadRequest(c, "Import job not found")
+			return
+		}
+		if job.CompanyID != "" && !requireCompanyAccess(c, ic.config, job.CompanyID) {
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (ic *ImportController) startImport(c *gin.Context, kind models.ImportKind, companyID string) {
+	companyIDs, ok := callerCompanyIDs(c, ic.config)
+	if !ok {
+		return
+	}
+	caller := importCaller{
+		companyIDs: companyIDs,
+		roles:      map[models.Role]bool{models.RoleUser: true, models.RoleStaff: true},
+	}
+	if utils.HasRole(c, models.RoleAdmin) || utils.HasRole(c, models.RoleSuperUser) {
+		caller.roles[models.RoleAdmin] = true
+	}
+
+	if companyID != "" {
+		if !caller.canAccess(companyID) {
+			utils.Forbidden(c, "You do not have access to this company")
+			return
+		}
+		var company models.Company
+		err := ic.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": companyID}).Decode(&company)
+		if err != nil {
+			utils.BadRequest(c, "Company not found")
+			return
+		}
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		utils.BadRequest(c, "File is required")
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		utils.BadRequest(c, "File is too large")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		utils.BadRequest(c, "Error reading file")
+		return
+	}
+	defer file.Close()
+
+	rows, err := utils.ReadSpreadsheet(file, fileHeader.Filename)
+	if err != nil {
+		utils.BadRequest(c, "Error reading file: "+err.Error())
+		return
+	}
+	if len(rows) < 2 {
+		utils.BadRequest(c, "File has no data rows")
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	job := models.ImportJob{
+		ID:        primitive.NewObjectID().Hex(),
+		Kind:      kind,
+		CompanyID: companyID,
+		Filename:  fileHeader.Filename,
+		DryRun:    dryRun,
+		Status:    models.ImportStatusPending,
+		TotalRows: len(rows) - 1,
+		RowErrors: []models.RowError{},
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := ic.config.MongoDB.Collection("import_jobs").InsertOne(c, job); err != nil {
+		utils.InternalError(c, "Error creating import job")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	if job.TotalRows > importSyncRowLimit {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), importJobTimeout)
+			defer cancel()
+			ic.runImport(ctx, &job, caller, rows, clientIP)
+		}()
+
+		c.JSON(http.StatusAccepted, job)
+		return
+	}
+
+	ic.runImport(c, &job, caller, rows, clientIP)
+	c.JSON(http.StatusOK, job)
+}
+
+// runImport validates every row, then unless this is a dry run inserts the
+// valid ones in batches. Progress is saved to the job as it goes.
+func (ic *ImportController) runImport(ctx context.Context, job *models.ImportJob, caller importCaller, rows [][]string,
+	clientIP string) {
+	job.Status = models.ImportStatusRunning
+	ic.saveJob(ctx, job)
+
+	importer := ic.importerFor(job, caller)
+
+	decoder, err := utils.NewRowDecoder(rows[0], importer.newRow())
+	if err != nil {
+		ic.failJob(ctx, job, err.Error())
+		return
+	}
+
+	docs := make([]interface{}, 0, len(rows)-1)
+	ids := make([]string, 0, len(rows)-1)
+	for i, cells := range rows[1:] {
+		rowNumber := i + 2
+		if isBlankRow(cells) {
+			job.TotalRows--
+			continue
+		}
+
+		row := importer.newRow()
+		if err := decoder.Decode(cells, row); err != nil {
+			ic.addRowError(job, rowNumber, []string{err.Error()})
+			continue
+		}
+		if err := binding.Validator.ValidateStruct(row); err != nil {
+			ic.addRowError(job, rowNumber, utils.ValidationMessages(err, row))
+			continue
+		}
+
+		doc, id, errs := importer.prepare(ctx, row)
+		if len(errs) > 0 {
+			ic.addRowError(job, rowNumber, errs)
+			continue
+		}
+
+		docs = append(docs, doc)
+		ids = append(ids, id)
+	}
+	job.ValidRows = len(docs)
+
+	if job.DryRun {
+		ic.finishJob(ctx, job)
+		return
+	}
+
+	collection := ic.config.MongoDB.Collection(importer.collection)
+	for start := 0; start < len(docs); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+
+		result, err := collection.InsertMany(ctx, docs[start:end], options.InsertMany().SetOrdered(false))
+		if result != nil {
+			job.ImportedRows += len(result.InsertedIDs)
+		}
+		if err != nil {
+			ic.failJob(ctx, job, "Error inserting rows: "+err.Error())
+			return
+		}
+
+		for j := start; j < end; j++ {
+			writeChangeLog(ctx, ic.config, job.CreatedBy, clientIP, importer.entityType, ids[j],
+				models.ChangeActionCreate, nil, docs[j])
+			if importer.inserted != nil {
+				importer.inserted(ctx, docs[j])
+			}
+		}
+		ic.saveJob(ctx, job)
+	}
+
+	ic.finishJob(ctx, job)
+}
+
+func (ic *ImportController) importerFor(job *models.ImportJob, caller importCaller) rowImporter {
+	switch job.Kind {
+	case models.ImportBranchOffices:
+		return rowImporter{
+			collection: "branch_offices",
+			entityType: models.EntityBranchOffice,
+			newRow:     func() interface{} { return &models.BranchOffice{} },
+			prepare: func(ctx context.Context, row interface{}) (interface{}, string, []string) {
+				req := row.(*models.BranchOffice)
+				branchOffice := models.BranchOffice{
+					ID:        primitive.NewObjectID().Hex(),
+					CompanyID: job.CompanyID,
+					Name:      req.Name,
+					Address:   req.Address,
+					Phone:     req.Phone,
+					Email:     req.Email,
+					CreatedBy: job.CreatedBy,
+					UpdatedBy: job.CreatedBy,
+					CreatedAt: time.Now(),
+					UpdatedAt: time.Now(),
+				}
+				return branchOffice, branchOffice.ID, nil
+			},
+		}
+
+	case models.ImportStaff:
+		return ic.staffImporter(job, caller)
+	}
+
+	return ic.companyImporter(job, caller)
+}
+
+// companyImporter rejects tax IDs that are taken or repeated in the file,
+// and parent companies the caller has no access to
+func (ic *ImportController) companyImporter(job *models.ImportJob, caller importCaller) rowImporter {
+	seenTaxIDs := make(map[string]bool)
+
+	return rowImporter{
+		collection: "companies",
+		entityType: models.EntityCompany,
+		newRow:     func() interface{} { return &models.CreateCompanyRequest{} },
+		prepare: func(ctx context.Context, row interface{}) (interface{}, string, []string) {
+			req := row.(*models.CreateCompanyRequest)
+			country := strings.ToUpper(req.Country)
+			taxID := utils.NormalizeTaxID(req.TaxID)
+
+			key := country + "/" + taxID
+			if seenTaxIDs[key] {
+				return nil, "", []string{"tax_id appears more than once in the file"}
+			}
+			seenTaxIDs[key] = true
+			count, err := ic.config.MongoDB.Collection("companies").CountDocuments(ctx, bson.M{"country": country, "tax_id": taxID})
+			if err != nil {
+				return nil, "", []string{"Error checking tax ID"}
+			} else if count > 0 {
+				return nil, "", []string{"a company with this tax_id already exists"}
+			}
+
+			if req.ParentCompanyID != "" {
+				if !caller.canAccess(req.ParentCompanyID) {
+					return nil, "", []string{"parent_company_id is not a company you have access to"}
+				}
+				problem, err := parentCompanyProblem(ctx, ic.config, "", req.ParentCompanyID)
+				if err != nil {
+					return nil, "", []string{"Error checking parent company"}
+				} else if problem != "" {
+					return nil, "", []string{problem}
+				}
+			}
+
+			company := models.Company{
+				ID:                 primitive.NewObjectID().Hex(),
+				ParentCompanyID:    req.ParentCompanyID,
+				Name:               req.Name,
+				TradingName:        req.TradingName,
+				Type:               req.Type,
+				RegistrationNumber: req.RegistrationNumber,
+				Country:            country,
+				Address:            req.Address,
+				Phone:              req.Phone,
+				Email:              req.Email,
+				Website:            req.Website,
+				TaxID:              taxID,
+				BusinessType:       req.BusinessType,
+				CreatedBy:          job.CreatedBy,
+				UpdatedBy:          job.CreatedBy,
+				CreatedAt:          time.Now(),
+				UpdatedAt:          time.Now(),
+			}
+			return company, company.ID, nil
+		},
+	}
+}
+
+// staffImporter resolves branch offices by ID or name within the job's
+// company and rejects usernames that are taken or repeated in the file, and
+// roles the caller may not give
+func (ic *ImportController) staffImporter(job *models.ImportJob, caller importCaller) rowImporter {
+	branches := make(map[string]string)
+	branchesLoaded := false
+	seenUsernames := make(map[string]bool)
+
+	return rowImporter{
+		collection: "users",
+		entityType: models.EntityUser,
+		newRow:     func() interface{} { return &models.StaffImportRow{} },
+		prepare: func(ctx context.Context, row interface{}) (interface{}, string, []string) {
+			req := row.(*models.StaffImportRow)
+			var errs []string
+
+			if !branchesLoaded {
+				cursor, err := ic.config.MongoDB.Collection("branch_offices").Find(ctx, bson.M{"company_id": job.CompanyID})
+				if err != nil {
+					return nil, "", []string{"Error fetching branch offices"}
+				}
+				var list []models.BranchOffice
+				if err := cursor.All(ctx, &list); err != nil {
+					return nil, "", []string{"Error parsing branch offices"}
+				}
+				for _, b := range list {
+					branches[b.ID] = b.ID
+					branches[strings.ToLower(b.Name)] = b.ID
+				}
+				branchesLoaded = true
+			}
+
+			if req.CompanyID != "" && req.CompanyID != job.CompanyID {
+				errs = append(errs, "company_id does not match the company being imported into")
+			}
+			for _, role := range req.Roles {
+				if !caller.roles[role] {
+					errs = append(errs, "you may not give the role: "+string(role))
+				}
+			}
+
+			branchRoles := make([]models.BranchAssignment, 0, len(req.BranchOffices))
+			for _, ref := range req.BranchOffices {
+				role := models.BranchRoleTeller
+				if i := strings.LastIndex(ref, ":"); i >= 0 {
+					if r := models.BranchRole(strings.TrimSpace(ref[i+1:])); r.Valid() {
+						role = r
+						ref = strings.TrimSpace(ref[:i])
+					}
+				}
+
+				id, ok := branches[ref]
+				if !ok {
+					id, ok = branches[strings.ToLower(ref)]
+				}
+				if !ok {
+					errs = append(errs, "unknown branch office: "+ref)
+					continue
+				}
+				branchRoles = append(branchRoles, models.BranchAssignment{
+					BranchID:   id,
+					Role:       role,
+					AssignedAt: time.Now(),
+					AssignedBy: job.CreatedBy,
+				})
+			}
+
+			if seenUsernames[req.Username] {
+				errs = append(errs, "username appears more than once in the file")
+			} else {
+				seenUsernames[req.Username] = true
+				count, err := ic.config.MongoDB.Collection("users").CountDocuments(ctx, bson.M{"username": req.Username})
+				if err != nil {
+					errs = append(errs, "Error checking username")
+				} else if count > 0 {
+					errs = append(errs, "username already taken")
+				}
+			}
+
+			if len(errs) > 0 {
+				return nil, "", errs
+			}
+
+			roles := req.Roles
+			hasStaffRole := false
+			for _, role := range roles {
+				if role == models.RoleStaff {
+					hasStaffRole = true
+					break
+				}
+			}
+			if !hasStaffRole {
+				roles = append(roles, models.RoleStaff)
+			}
+
+			user := models.User{
+				ID:          primitive.NewObjectID().Hex(),
+				Username:    req.Username,
+				Password:    req.Password,
+				FullName:    req.FullName,
+				Roles:       roles,
+				CompanyID:   job.CompanyID,
+				Memberships: []models.CompanyMembership{},
+				BranchRoles: branchRoles,
+				CreatedAt:   time.Now(),
+				UpdatedAt:   time.Now(),
+			}
+			user.SetMembership(job.CompanyID, roles, job.CreatedBy)
+			if err := user.HashPassword(); err != nil {
+				return nil, "", []string{"Error hashing password"}
+			}
+			return user, user.ID, nil
+		},
+		inserted: func(ctx context.Context, doc interface{}) {
+			user := doc.(models.User)
+			if err := ic.staffing.RecordChange(ctx, models.User{}, user, job.CreatedBy, user.CreatedAt); err != nil {
+				utils.Error("Error recording staff assignments: "+err.Error(), utils.Fields(map[string]interface{}{
+					"user_id": user.ID,
+				}))
+			}
+		},
+	}
+}
+
+func (ic *ImportController) addRowError(job *models.ImportJob, row int, messages []string) {
+	job.InvalidRows++
+	if len(job.RowErrors) >= maxStoredRowErrors {
+		job.ErrorsTruncated = true
+		return
+	}
+	job.RowErrors = append(job.RowErrors, models.RowError{Row: row, Messages: messages})
+}
+
+func (ic *ImportController) finishJob(ctx context.Context, job *models.ImportJob) {
+	now := time.Now()
+	job.Status = models.ImportStatusCompleted
+	job.FinishedAt = &now
+	ic.saveJob(ctx, job)
+}
+
+func (ic *ImportController) failJob(ctx context.Context, job *models.ImportJob, message string) {
+	now := time.Now()
+	job.Status = models.ImportStatusFailed
+	job.Error = message
+	job.FinishedAt = &now
+	ic.saveJob(ctx, job)
+}
+
+func (ic *ImportController) saveJob(ctx context.Context, job *models.ImportJob) {
+	_, err := ic.config.MongoDB.Collection("import_jobs").ReplaceOne(ctx, bson.M{"_id": job.ID}, job)
+	if err != nil {
+		utils.Error(fmt.Sprintf("Error saving import job %s: %v", job.ID, err))
+	}
+}
+
+func isBlankRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
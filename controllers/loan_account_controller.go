@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"errors"
+	"loan/config"
+	"loan/models"
+	"loan/servicing"
+	"loan/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Fields clients may filter and sort loan accounts on
+var loanAccountQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"status":                utils.FieldString,
+		"loan_id":               utils.FieldString,
+		"customer_id":           utils.FieldString,
+		"currency":              utils.FieldString,
+		"principal":             utils.FieldNumber,
+		"balances.principal":    utils.FieldNumber,
+		"credit":                utils.FieldNumber,
+		"installments.status":   utils.FieldString,
+		"installments.due_date": utils.FieldTime,
+		"created_at":            utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"principal":          true,
+		"balances.principal": true,
+		"created_at":         true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
+// Fields and expansions clients may request on loan accounts
+var loanAccountShapeSpec = utils.ShapeSpec{
+	Fields: map[string]bool{
+		"id": true, "company_id": true, "branch_id": true, "loan_id": true, "customer_id": true,
+		"currency": true, "principal": true, "installments": true, "balances": true, "credit": true,
+		"allocation": true, "late_payment_grace_days": true, "status": true, "created_by": true,
+		"created_at": true, "updated_at": true,
+	},
+	Expansions: map[string]utils.Expansion{
+		"customer": {
+			From:   "customers",
+			Let:    bson.M{"customer_id": "$customer_id"},
+			Match:  bson.M{"$eq": bson.A{"$_id", "$$customer_id"}},
+			Single: true,
+		},
+	},
+}
+
+// Columns written when loan accounts are exported
+var loanAccountExportColumns = []string{
+	"id", "company_id", "branch_id", "loan_id", "customer_id", "currency", "principal", "balances", "credit",
+	"status", "created_at", "updated_at",
+}
+
+// Fields clients may filter repayments on
+var repaymentQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"receipt_number": utils.FieldString,
+		"method":         utils.FieldString,
+		"amount":         utils.FieldNumber,
+		"received_by":    utils.FieldString,
+		"created_at":     utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"amount":     true,
+		"created_at": true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
+// Columns written when repayments are exported
+var repaymentExportColumns = []string{
+	"id", "receipt_number", "account_id", "loan_id", "amount", "credit_used", "allocated", "prepaid", "credited",
+	"method", "reference", "received_by", "created_at",
+}
+
+// Fields clients may filter credit allocations on
+var creditAllocationQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"amount":     utils.FieldNumber,
+		"created_at": utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"amount":     true,
+		"created_at": true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
+// Columns written when credit allocations are exported
+var creditAllocationExportColumns = []string{
+	"id", "account_id", "loan_id", "amount", "allocated", "created_at",
+}
+
+// LoanAccountController serves the accounts of active loans at a branch
+// office and takes repayments on them
+type LoanAccountController struct {
+	config    *config.Config
+	servicing *servicing.Service
+}
+
+func NewLoanAccountController(config *config.Config) *LoanAccountController {
+	return &LoanAccountController{
+		config:    config,
+		servicing: servicing.NewService(config.MongoDB),
+	}
+}
+
+// OpenLoanAccount opens the account of an active loan. The body may set how
+// payments are allocated; by default fees, penalties, interest and then
+// principal, with overpayments paying the coming installments in advance.
+func (ac *LoanAccountController) OpenLoanAccount(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, ac.config, companyID) {
+		return
+	}
+
+	var req models.AllocationSettings
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequest(c, "Invalid request body")
+			return
+		}
+	}
+
+	var loan models.LoanApplication
+	err := ac.config.MongoDB.Collection("loans").FindOne(c, bson.M{
+		"_id":        c.Param("loan_id"),
+		"company_id": companyID,
+		"branch_id":  c.Param("branch_id"),
+	}).Decode(&loan)
+	if err != nil {
+		utils.BadRequest(c, "Loan application not found")
+		return
+	}
+
+	version, err := findLoanProductVersion(c, ac.config, companyID, loan.ProductID, loan.ProductVersion)
+	if err != nil {
+		utils.InternalError(c, "Error fetching loan product")
+		return
+	}
+
+	account, err := ac.servicing.Open(c, loan, version.LoanProductTerms, req, c.GetString("user_id"))
+	switch {
+	case err == nil:
+	case err == servicing.ErrLoanNotActive, err == servicing.ErrAccountExists:
+		utils.HandleError(c, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, servicing.ErrUnschedulable):
+		utils.HandleError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	default:
+		utils.InternalError(c, "Error opening loan account")
+		return
+	}
+
+	recordChange(c, ac.config, models.EntityLoanAccount, account.ID, models.ChangeActionCreate, nil, account)
+
+	c.JSON(http.StatusCreated, account)
+}
+
+// GetLoanAccount gets a loan account with its installments and balances
+func (ac *LoanAccountController) GetLoanAccount(c *gin.Context) {
+	if !requireCompanyAccess(c, ac.config, c.Param("id")) {
+		return
+	}
+
+	shape, err := utils.ParseShape(c, loanAccountShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	account, ok := ac.findAccount(c)
+	if !ok {
+		return
+	}
+
+	data, err := utils.ApplyShapeOne(c, ac.config.MongoDB.Collection("loan_accounts"), shape, account)
+	if err != nil {
+		utils.InternalError(c, "Error fetching loan account")
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// ListLoanAccounts lists the loan accounts of a branch office. Accounts
+// with overdue installments are found with ?installments.status=overdue.
+func (ac *LoanAccountController) ListLoanAccounts(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, ac.config, companyID) {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, loanAccountQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(bson.M{"company_id": companyID, "branch_id": c.Param("branch_id")})
+
+	shape, err := utils.ParseShape(c, loanAccountShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	sendGroupList[models.LoanAccount](c, ac.config.MongoDB.Collection("loan_accounts"), filter, query.Sort, shape,
+		"loan_accounts", loanAccountExportColumns, "loan accounts")
+}
+
+// SetLoanAccountAllocation changes how a loan account's future payments
+// are allocated
+func (ac *LoanAccountController) SetLoanAccountAllocation(c *gin.Context) {
+	if !requireCompanyAccess(c, ac.config, c.Param("id")) {
+		return
+	}
+
+	var req models.AllocationSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	account, ok := ac.findAccount(c)
+	if !ok {
+		return
+	}
+
+	updated, err := ac.servicing.SetAllocation(c, account, req)
+	switch err {
+	case nil:
+	case servicing.ErrStaleAccount:
+		utils.HandleError(c, http.StatusConflict, err.Error())
+		return
+	default:
+		utils.InternalError(c, "Error updating loan account")
+		return
+	}
+
+	recordChange(c, ac.config, models.EntityLoanAccount, account.ID, models.ChangeActionUpdate, account, updated)
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// RecordRepayment takes a payment on a loan account, allocates it and
+// answers with its receipt and the account after it
+func (ac *LoanAccountController) RecordRepayment(c *gin.Context) {
+	if !requireCompanyAccess(c, ac.config, c.Param("id")) {
+		return
+	}
+
+	var req models.RepaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	account, ok := ac.findAccount(c)
+	if !ok {
+		return
+	}
+
+	repayment, updated, err := ac.servicing.Repay(c, account, req, c.GetString("user_id"))
+	switch err {
+	case nil:
+	case servicing.ErrAccountPaidOff, servicing.ErrStaleAccount:
+		utils.HandleError(c, http.StatusConflict, err.Error())
+		return
+	default:
+		utils.InternalError(c, "Error recording repayment")
+		return
+	}
+
+	recordChange(c, ac.config, models.EntityLoanAccount, account.ID, models.ChangeActionUpdate, account, updated)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"repayment": repayment,
+		"account":   updated,
+	})
+}
+
+// ListRepayments lists the payments taken on a loan account
+func (ac *LoanAccountController) ListRepayments(c *gin.Context) {
+	if !requireCompanyAccess(c, ac.config, c.Param("id")) {
+		return
+	}
+
+	account, ok := ac.findAccount(c)
+	if !ok {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, repaymentQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(bson.M{"account_id": account.ID})
+
+	sendGroupList[models.Repayment](c, ac.config.MongoDB.Collection("repayments"), filter, query.Sort,
+		utils.Shape{}, "repayments", repaymentExportColumns, "repayments")
+}
+
+// GetRepayment gets a payment taken on a loan account, by ID or receipt
+// number
+func (ac *LoanAccountController) GetRepayment(c *gin.Context) {
+	if !requireCompanyAccess(c, ac.config, c.Param("id")) {
+		return
+	}
+
+	account, ok := ac.findAccount(c)
+	if !ok {
+		return
+	}
+
+	var repayment models.Repayment
+	err := ac.config.MongoDB.Collection("repayments").FindOne(c, bson.M{
+		"account_id": account.ID,
+		"$or": bson.A{
+			bson.M{"_id": c.Param("repayment_id")},
+			bson.M{"receipt_number": c.Param("repayment_id")},
+		},
+	}).Decode(&repayment)
+	if err != nil {
+		utils.BadRequest(c, "Repayment not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, repayment)
+}
+
+// ListCreditAllocations lists the credit of a loan account that was
+// allocated to installments as they fell due
+func (ac *LoanAccountController) ListCreditAllocations(c *gin.Context) {
+	if !requireCompanyAccess(c, ac.config, c.Param("id")) {
+		return
+	}
+
+	account, ok := ac.findAccount(c)
+	if !ok {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, creditAllocationQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(bson.M{"account_id": account.ID})
+
+	sendGroupList[models.CreditAllocation](c, ac.config.MongoDB.Collection("credit_allocations"), filter,
+		query.Sort, utils.Shape{}, "credit_allocations", creditAllocationExportColumns, "credit allocations")
+}
+
+func (ac *LoanAccountController) findAccount(c *gin.Context) (models.LoanAccount, bool) {
+	var account models.LoanAccount
+	err := ac.config.MongoDB.Collection("loan_accounts").FindOne(c, bson.M{
+		"_id":        c.Param("account_id"),
+		"company_id": c.Param("id"),
+		"branch_id":  c.Param("branch_id"),
+	}).Decode(&account)
+	if err != nil {
+		utils.BadRequest(c, "Loan account not found")
+		return account, false
+	}
+	return account, true
+}
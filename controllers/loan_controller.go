@@ -0,0 +1,537 @@
+package controllers
+
+import (
+	"fmt"
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Fields clients may filter and sort loan applications on
+var loanQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"status":          utils.FieldString,
+		"customer_id":     utils.FieldString,
+		"product_id":      utils.FieldString,
+		"product_version": utils.FieldNumber,
+		"currency":        utils.FieldString,
+		"principal":       utils.FieldNumber,
+		"term":            utils.FieldNumber,
+		"officer_id":      utils.FieldString,
+		"created_by":      utils.FieldString,
+		"created_at":      utils.FieldTime,
+		"updated_at":      utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"principal":  true,
+		"created_at": true,
+		"updated_at": true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
+// Fields and expansions clients may request on loan applications
+var loanShapeSpec = utils.ShapeSpec{
+	Fields: map[string]bool{
+		"id": true, "company_id": true, "branch_id": true, "customer_id": true, "product_id": true,
+		"product_version": true, "currency": true, "principal": true, "term": true, "purpose": true,
+		"status": true, "transitions": true, "officer_id": true, "created_by": true, "updated_by": true,
+		"created_at": true, "updated_at": true,
+	},
+	Expansions: map[string]utils.Expansion{
+		"customer": {
+			From:   "customers",
+			Let:    bson.M{"customer_id": "$customer_id"},
+			Match:  bson.M{"$eq": bson.A{"$_id", "$$customer_id"}},
+			Single: true,
+		},
+		"product": {
+			From: "loan_product_versions",
+			Let:  bson.M{"product_id": "$product_id", "product_version": "$product_version"},
+			Match: bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$product_id", "$$product_id"}},
+				bson.M{"$eq": bson.A{"$version", "$$product_version"}},
+			}},
+			Single: true,
+		},
+	},
+}
+
+// Columns written when loan applications are exported
+var loanExportColumns = []string{
+	"id", "company_id", "branch_id", "customer_id", "product_id", "product_version", "currency", "principal",
+	"term", "purpose", "status", "officer_id", "created_by", "updated_by", "created_at", "updated_at",
+}
+
+// LoanController manages loan applications at a branch office and moves
+// them through their lifecycle. Each move is a separate endpoint, and is
+// refused unless the loan is in the status it moves from.
+type LoanController struct {
+	config *config.Config
+}
+
+func NewLoanController(config *config.Config) *LoanController {
+	return &LoanController{config: config}
+}
+
+// CreateLoan drafts a loan application for a customer against the current
+// version of an active loan product
+func (lc *LoanController) CreateLoan(c *gin.Context) {
+	companyID := c.Param("id")
+	branchID := c.Param("branch_id")
+	if !requireCompanyAccess(c, lc.config, companyID) || !lc.checkBranch(c, companyID, branchID) {
+		return
+	}
+
+	var req models.LoanApplicationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	product, ok := lc.checkApplication(c, companyID, branchID, req)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	loan := models.LoanApplication{
+		ID:                     primitive.NewObjectID().Hex(),
+		CompanyID:              companyID,
+		BranchID:               branchID,
+		LoanApplicationRequest: req,
+		ProductVersion:         product.CurrentVersion,
+		Currency:               product.Currency,
+		Status:                 models.LoanDraft,
+		Transitions: []models.LoanTransition{{
+			To:      models.LoanDraft,
+			Reason:  "Application drafted",
+			ActorID: c.GetString("user_id"),
+			At:      now,
+		}},
+		OfficerID: c.GetString("user_id"),
+		CreatedBy: c.GetString("user_id"),
+		UpdatedBy: c.GetString("user_id"),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if _, err := lc.config.MongoDB.Collection("loans").InsertOne(c, loan); err != nil {
+		utils.InternalError(c, "Error creating loan application")
+		return
+	}
+
+	recordChange(c, lc.config, models.EntityLoan, loan.ID, models.ChangeActionCreate, nil, loan)
+
+	c.JSON(http.StatusCreated, loan)
+}
+
+// GetLoan gets a loan application by ID
+func (lc *LoanController) GetLoan(c *gin.Context) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	shape, err := utils.ParseShape(c, loanShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	loan, ok := lc.findLoan(c)
+	if !ok {
+		return
+	}
+
+	data, err := utils.ApplyShapeOne(c, lc.config.MongoDB.Collection("loans"), shape, loan)
+	if err != nil {
+		utils.InternalError(c, "Error fetching loan application")
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// ListLoans lists the loan applications of a branch office
+func (lc *LoanController) ListLoans(c *gin.Context) {
+	companyID := c.Param("id")
+	branchID := c.Param("branch_id")
+	if !requireCompanyAccess(c, lc.config, companyID) {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, loanQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(bson.M{"company_id": companyID, "branch_id": branchID})
+
+	shape, err := utils.ParseShape(c, loanShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	sendGroupList[models.LoanApplication](c, lc.config.MongoDB.Collection("loans"), filter, query.Sort, shape,
+		"loans", loanExportColumns, "loan applications")
+}
+
+// UpdateLoan changes a draft loan application. The draft moves to the
+// product's current version.
+func (lc *LoanController) UpdateLoan(c *gin.Context) {
+	companyID := c.Param("id")
+	branchID := c.Param("branch_id")
+	if !requireCompanyAccess(c, lc.config, companyID) {
+		return
+	}
+
+	var req models.LoanApplicationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	loan, ok := lc.findLoan(c)
+	if !ok {
+		return
+	}
+	if loan.Status != models.LoanDraft {
+		utils.HandleError(c, http.StatusConflict, "Only draft loan applications can be changed")
+		return
+	}
+
+	product, ok := lc.checkApplication(c, companyID, branchID, req)
+	if !ok {
+		return
+	}
+
+	fields, err := utils.PatchFields(req)
+	if err != nil {
+		utils.InternalError(c, "Error updating loan application")
+		return
+	}
+	fields["product_version"] = product.CurrentVersion
+	fields["currency"] = product.Currency
+	fields["updated_at"] = time.Now()
+	fields["updated_by"] = c.GetString("user_id")
+
+	result := lc.config.MongoDB.Collection("loans").FindOneAndUpdate(
+		c,
+		bson.M{
+			"_id":        loan.ID,
+			"company_id": companyID,
+			"branch_id":  branchID,
+			"status":     models.LoanDraft,
+			"updated_at": loan.UpdatedAt,
+		},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedLoan models.LoanApplication
+	if err := result.Decode(&updatedLoan); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondPatchConflict(c)
+			return
+		}
+		utils.InternalError(c, "Error updating loan application")
+		return
+	}
+
+	recordChange(c, lc.config, models.EntityLoan, loan.ID, models.ChangeActionUpdate, loan, updatedLoan)
+
+	c.JSON(http.StatusOK, updatedLoan)
+}
+
+// DeleteLoan deletes a draft loan application. Once submitted, a loan
+// stays on record whatever becomes of it.
+func (lc *LoanController) DeleteLoan(c *gin.Context) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	loan, ok := lc.findLoan(c)
+	if !ok {
+		return
+	}
+	if loan.Status != models.LoanDraft {
+		utils.HandleError(c, http.StatusConflict, "Only draft loan applications can be deleted")
+		return
+	}
+
+	result, err := lc.config.MongoDB.Collection("loans").DeleteOne(c, bson.M{
+		"_id":    loan.ID,
+		"status": models.LoanDraft,
+	})
+	if err != nil {
+		utils.InternalError(c, "Error deleting loan application")
+		return
+	}
+	if result.DeletedCount == 0 {
+		respondPatchConflict(c)
+		return
+	}
+
+	recordChange(c, lc.config, models.EntityLoan, loan.ID, models.ChangeActionDelete, loan, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Loan application deleted successfully"})
+}
+
+// SubmitLoan hands a draft in for review
+func (lc *LoanController) SubmitLoan(c *gin.Context) {
+	lc.transition(c, models.LoanSubmitted)
+}
+
+// ReviewLoan starts the review of a submitted loan
+func (lc *LoanController) ReviewLoan(c *gin.Context) {
+	lc.transition(c, models.LoanUnderReview)
+}
+
+// ApproveLoan approves a loan under review
+func (lc *LoanController) ApproveLoan(c *gin.Context) {
+	lc.transition(c, models.LoanApproved)
+}
+
+// RejectLoan turns down a loan under review
+func (lc *LoanController) RejectLoan(c *gin.Context) {
+	lc.transition(c, models.LoanRejected)
+}
+
+// DisburseLoan records that an approved loan was paid out
+func (lc *LoanController) DisburseLoan(c *gin.Context) {
+	lc.transition(c, models.LoanDisbursed)
+}
+
+// ActivateLoan starts the repayment of a disbursed loan
+func (lc *LoanController) ActivateLoan(c *gin.Context) {
+	lc.transition(c, models.LoanActive)
+}
+
+// CloseLoan closes a loan that has been repaid. A loan whose account
+// still has a balance is written off instead.
+func (lc *LoanController) CloseLoan(c *gin.Context) {
+	count, err := lc.config.MongoDB.Collection("loan_accounts").CountDocuments(c, bson.M{
+		"loan_id":    c.Param("loan_id"),
+		"company_id": c.Param("id"),
+		"status":     models.LoanAccountActive,
+	})
+	if err != nil {
+		utils.InternalError(c, "Error fetching loan account")
+		return
+	}
+	if count > 0 {
+		utils.HandleError(c, http.StatusConflict, "Loan account still has a balance")
+		return
+	}
+
+	lc.transition(c, models.LoanClosed)
+}
+
+// WriteOffLoan writes off an active loan that will not be repaid
+func (lc *LoanController) WriteOffLoan(c *gin.Context) {
+	lc.transition(c, models.LoanWrittenOff)
+}
+
+// GetLoanTimeline lists a loan application's transitions, oldest first,
+// with the names of the users who made them
+func (lc *LoanController) GetLoanTimeline(c *gin.Context) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	loan, ok := lc.findLoan(c)
+	if !ok {
+		return
+	}
+
+	actorIDs := []string{}
+	for _, t := range loan.Transitions {
+		actorIDs = append(actorIDs, t.ActorID)
+	}
+	cursor, err := lc.config.MongoDB.Collection("users").Find(c,
+		bson.M{"_id": bson.M{"$in": actorIDs}},
+		options.Find().SetProjection(bson.M{"username": 1, "full_name": 1}),
+	)
+	if err != nil {
+		utils.InternalError(c, "Error fetching users")
+		return
+	}
+	defer cursor.Close(c)
+
+	var users []models.User
+	if err := cursor.All(c, &users); err != nil {
+		utils.InternalError(c, "Error parsing users")
+		return
+	}
+	names := make(map[string]string, len(users))
+	for _, user := range users {
+		names[user.ID] = user.FullName
+		if names[user.ID] == "" {
+			names[user.ID] = user.Username
+		}
+	}
+
+	timeline := make([]models.LoanTimelineEntry, 0, len(loan.Transitions))
+	for _, t := range loan.Transitions {
+		timeline = append(timeline, models.LoanTimelineEntry{LoanTransition: t, ActorName: names[t.ActorID]})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"loan_id": loan.ID,
+		"status":  loan.Status,
+		"data":    timeline,
+	})
+}
+
+// transition moves the loan in the route to status to. The write only
+// applies if the loan is still in the status it was read in, so two
+// concurrent moves cannot both succeed.
+func (lc *LoanController) transition(c *gin.Context, to models.LoanStatus) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	var req models.LoanTransitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body: a reason is required")
+		return
+	}
+
+	loan, ok := lc.findLoan(c)
+	if !ok {
+		return
+	}
+	if !loan.Status.CanMoveTo(to) {
+		utils.HandleError(c, http.StatusConflict,
+			fmt.Sprintf("A loan application that is %s cannot become %s", loan.Status, to))
+		return
+	}
+
+	now := time.Now()
+	entry := models.LoanTransition{
+		From:    loan.Status,
+		To:      to,
+		Reason:  req.Reason,
+		ActorID: c.GetString("user_id"),
+		At:      now,
+	}
+
+	result := lc.config.MongoDB.Collection("loans").FindOneAndUpdate(
+		c,
+		bson.M{
+			"_id":        loan.ID,
+			"company_id": loan.CompanyID,
+			"branch_id":  loan.BranchID,
+			"status":     loan.Status,
+		},
+		bson.M{
+			"$set": bson.M{
+				"status":     to,
+				"updated_at": now,
+				"updated_by": c.GetString("user_id"),
+			},
+			"$push": bson.M{"transitions": entry},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedLoan models.LoanApplication
+	if err := result.Decode(&updatedLoan); err != nil {
+		if err == mongo.ErrNoDocuments {
+			respondPatchConflict(c)
+			return
+		}
+		utils.InternalError(c, "Error updating loan application")
+		return
+	}
+
+	recordChange(c, lc.config, models.EntityLoan, loan.ID, models.ChangeActionUpdate, loan, updatedLoan)
+
+	c.JSON(http.StatusOK, updatedLoan)
+}
+
+// checkApplication checks the customer of req is one the caller may see
+// and banks at the branch, and that the product is active and offers them
+// req's loan. It returns the product.
+func (lc *LoanController) checkApplication(c *gin.Context, companyID, branchID string,
+	req models.LoanApplicationRequest) (models.LoanProduct, bool) {
+	var product models.LoanProduct
+
+	scope, ok := customerScope(c, lc.config, companyID)
+	if !ok {
+		return product, false
+	}
+	scope["_id"] = req.CustomerID
+	var customer models.Customer
+	if err := lc.config.MongoDB.Collection("customers").FindOne(c, scope).Decode(&customer); err != nil {
+		utils.BadRequest(c, "Customer not found")
+		return product, false
+	}
+	if customer.BranchID != branchID {
+		utils.HandleError(c, http.StatusUnprocessableEntity, "Customer belongs to another branch office")
+		return product, false
+	}
+
+	err := lc.config.MongoDB.Collection("loan_products").FindOne(c, bson.M{
+		"_id":        req.ProductID,
+		"company_id": companyID,
+	}).Decode(&product)
+	if err != nil {
+		utils.BadRequest(c, "Loan product not found")
+		return product, false
+	}
+	if product.Status != models.LoanProductActive {
+		utils.HandleError(c, http.StatusUnprocessableEntity, "Loan product is retired")
+		return product, false
+	}
+
+	reasons := product.Ineligibility(customer, branchID, req.Principal, time.Now())
+	if !product.AllowsTerm(req.Term) {
+		reasons = append(reasons, fmt.Sprintf("term must be one of %v installments", product.Terms))
+	}
+	if len(reasons) > 0 {
+		utils.HandleError(c, http.StatusUnprocessableEntity, "Not eligible: "+strings.Join(reasons, "; "))
+		return product, false
+	}
+	return product, true
+}
+
+func (lc *LoanController) findLoan(c *gin.Context) (models.LoanApplication, bool) {
+	var loan models.LoanApplication
+	err := lc.config.MongoDB.Collection("loans").FindOne(c, bson.M{
+		"_id":        c.Param("loan_id"),
+		"company_id": c.Param("id"),
+		"branch_id":  c.Param("branch_id"),
+	}).Decode(&loan)
+	if err != nil {
+		utils.BadRequest(c, "Loan application not found")
+		return loan, false
+	}
+	return loan, true
+}
+
+func (lc *LoanController) checkBranch(c *gin.Context, companyID, branchID string) bool {
+	count, err := lc.config.MongoDB.Collection("branch_offices").CountDocuments(c, bson.M{
+		"_id":        branchID,
+		"company_id": companyID,
+	})
+	if err != nil {
+		utils.InternalError(c, "Error fetching branch offices")
+		return false
+	}
+	if count == 0 {
+		utils.BadRequest(c, "Branch office not found")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,477 @@
+package controllers
+
+import (
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Fields clients may filter and sort loan products on
+var loanProductQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"name":                       utils.FieldString,
+		"status":                     utils.FieldString,
+		"currency":                   utils.FieldString,
+		"interest_method":            utils.FieldString,
+		"repayment_frequency":        utils.FieldString,
+		"interest_rate":              utils.FieldNumber,
+		"min_principal":              utils.FieldNumber,
+		"max_principal":              utils.FieldNumber,
+		"terms":                      utils.FieldNumber,
+		"eligibility.customer_types": utils.FieldString,
+		"eligibility.branch_ids":     utils.FieldString,
+		"current_version":            utils.FieldNumber,
+		"created_at":                 utils.FieldTime,
+		"updated_at":                 utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"name":          true,
+		"interest_rate": true,
+		"max_principal": true,
+		"created_at":    true,
+		"updated_at":    true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
+// Fields clients may request on loan products
+var loanProductShapeSpec = utils.ShapeSpec{
+	Fields: map[string]bool{
+		"id": true, "company_id": true, "name": true, "description": true, "currency": true,
+		"min_principal": true, "max_principal": true, "terms": true, "interest_rate": true,
+		"interest_method": true, "repayment_frequency": true, "fees": true, "grace_periods": true,
+		"late_payment_grace_days": true, "eligibility": true, "status": true, "current_version": true,
+		"created_by": true, "updated_by": true, "created_at": true, "updated_at": true,
+	},
+}
+
+// Columns written when loan products are exported
+var loanProductExportColumns = []string{
+	"id", "company_id", "name", "currency", "min_principal", "max_principal", "terms", "interest_rate",
+	"interest_method", "repayment_frequency", "grace_periods", "late_payment_grace_days", "status",
+	"current_version", "created_at", "updated_at",
+}
+
+// LoanProductController manages the catalog of loans a company offers.
+// Every change to a product's terms is kept as a new version, so loans keep
+// the terms of the version they were issued against.
+type LoanProductController struct {
+	config *config.Config
+}
+
+func NewLoanProductController(config *config.Config) *LoanProductController {
+	return &LoanProductController{config: config}
+}
+
+// CreateLoanProduct adds a product to a company's catalog as version 1
+func (lc *LoanProductController) CreateLoanProduct(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, lc.config, companyID) {
+		return
+	}
+
+	var req models.LoanProductTerms
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+	if !lc.checkBranches(c, companyID, req.Eligibility.BranchIDs) {
+		return
+	}
+	normalizeProductTerms(&req)
+
+	now := time.Now()
+	product := models.LoanProduct{
+		ID:               primitive.NewObjectID().Hex(),
+		CompanyID:        companyID,
+		LoanProductTerms: req,
+		Status:           models.LoanProductActive,
+		CurrentVersion:   1,
+		CreatedBy:        c.GetString("user_id"),
+		UpdatedBy:        c.GetString("user_id"),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	version, err := lc.insertVersion(c, product, now)
+	if err != nil {
+		utils.InternalError(c, "Error creating loan product")
+		return
+	}
+	if _, err := lc.config.MongoDB.Collection("loan_products").InsertOne(c, product); err != nil {
+		lc.config.MongoDB.Collection("loan_product_versions").DeleteOne(c, bson.M{"_id": version.ID})
+		utils.InternalError(c, "Error creating loan product")
+		return
+	}
+
+	recordChange(c, lc.config, models.EntityLoanProduct, product.ID, models.ChangeActionCreate, nil, product)
+
+	c.JSON(http.StatusCreated, product)
+}
+
+// GetLoanProduct gets a product as it currently stands
+func (lc *LoanProductController) GetLoanProduct(c *gin.Context) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	shape, err := utils.ParseShape(c, loanProductShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	product, ok := lc.findProduct(c)
+	if !ok {
+		return
+	}
+
+	data, err := utils.ApplyShapeOne(c, lc.config.MongoDB.Collection("loan_products"), shape, product)
+	if err != nil {
+		utils.InternalError(c, "Error fetching loan product")
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// ListLoanProducts lists a company's loan products, retired ones included
+// unless filtered out with ?status=active
+func (lc *LoanProductController) ListLoanProducts(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, lc.config, companyID) {
+		return
+	}
+
+	query, err := utils.ParseListQuery(c, loanProductQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(bson.M{"company_id": companyID})
+
+	shape, err := utils.ParseShape(c, loanProductShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	sendGroupList[models.LoanProduct](c, lc.config.MongoDB.Collection("loan_products"), filter, query.Sort, shape,
+		"loan_products", loanProductExportColumns, "loan products")
+}
+
+// UpdateLoanProduct replaces a product's terms, making a new version if
+// they changed
+func (lc *LoanProductController) UpdateLoanProduct(c *gin.Context) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	var req models.LoanProductTerms
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
+	product, ok := lc.findProduct(c)
+	if !ok {
+		return
+	}
+
+	lc.writeTerms(c, product, req)
+}
+
+// PatchLoanProduct applies a JSON Merge Patch or JSON Patch to a product's
+// terms, making a new version if they changed
+func (lc *LoanProductController) PatchLoanProduct(c *gin.Context) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	product, ok := lc.findProduct(c)
+	if !ok {
+		return
+	}
+
+	var patched models.LoanProductTerms
+	if err := utils.BindPatch(c, product.LoanProductTerms, &patched); err != nil {
+		respondPatchError(c, err)
+		return
+	}
+
+	lc.writeTerms(c, product, patched)
+}
+
+// RetireLoanProduct stops a product being used for new loans. It stays
+// readable, with all its versions, for the loans already issued.
+func (lc *LoanProductController) RetireLoanProduct(c *gin.Context) {
+	lc.setStatus(c, models.LoanProductRetired)
+}
+
+// ReactivateLoanProduct makes a retired product available for new loans
+// again
+func (lc *LoanProductController) ReactivateLoanProduct(c *gin.Context) {
+	lc.setStatus(c, models.LoanProductActive)
+}
+
+// ListLoanProductVersions lists every version of a product, newest first
+func (lc *LoanProductController) ListLoanProductVersions(c *gin.Context) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	product, ok := lc.findProduct(c)
+	if !ok {
+		return
+	}
+
+	page, limit := utils.GetPaginationParams(c)
+	skip := (page - 1) * limit
+
+	filter := bson.M{"product_id": product.ID}
+	collection := lc.config.MongoDB.Collection("loan_product_versions")
+	total, err := collection.CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error counting loan product versions")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "version", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching loan product versions")
+		return
+	}
+	defer cursor.Close(c)
+
+	versions := []models.LoanProductVersion{}
+	if err = cursor.All(c, &versions); err != nil {
+		utils.InternalError(c, "Error parsing loan product versions")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, versions, total, page, limit)
+}
+
+// GetLoanProductVersion gets the terms of one version of a product
+func (lc *LoanProductController) GetLoanProductVersion(c *gin.Context) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil || number < 1 {
+		utils.BadRequest(c, "Invalid version")
+		return
+	}
+
+	version, err := findLoanProductVersion(c, lc.config, c.Param("id"), c.Param("product_id"), number)
+	if err != nil {
+		utils.BadRequest(c, "Version not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, version)
+}
+
+// writeTerms stores terms as the next version of product and makes them
+// the product's current terms. Nothing is written if they are unchanged.
+func (lc *LoanProductController) writeTerms(c *gin.Context, product models.LoanProduct, terms models.LoanProductTerms) {
+	if !lc.checkBranches(c, product.CompanyID, terms.Eligibility.BranchIDs) {
+		return
+	}
+	normalizeProductTerms(&terms)
+	if reflect.DeepEqual(terms, product.LoanProductTerms) {
+		c.JSON(http.StatusOK, product)
+		return
+	}
+
+	now := time.Now()
+	next := product
+	next.LoanProductTerms = terms
+	next.CurrentVersion = product.CurrentVersion + 1
+
+	// The unique index on product_id and version stops two concurrent
+	// changes from both claiming the next version
+	version, err := lc.insertVersion(c, next, now)
+	if mongo.IsDuplicateKeyError(err) {
+		respondPatchConflict(c)
+		return
+	}
+	if err != nil {
+		utils.InternalError(c, "Error updating loan product")
+		return
+	}
+
+	fields, err := utils.PatchFields(terms)
+	if err != nil {
+		utils.InternalError(c, "Error updating loan product")
+		return
+	}
+	fields["current_version"] = next.CurrentVersion
+	fields["updated_at"] = now
+	fields["updated_by"] = c.GetString("user_id")
+
+	result := lc.config.MongoDB.Collection("loan_products").FindOneAndUpdate(
+		c,
+		bson.M{
+			"_id":             product.ID,
+			"company_id":      product.CompanyID,
+			"current_version": product.CurrentVersion,
+		},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedProduct models.LoanProduct
+	if err := result.Decode(&updatedProduct); err != nil {
+		lc.config.MongoDB.Collection("loan_product_versions").DeleteOne(c, bson.M{"_id": version.ID})
+		if err == mongo.ErrNoDocuments {
+			respondPatchConflict(c)
+			return
+		}
+		utils.InternalError(c, "Error updating loan product")
+		return
+	}
+
+	recordChange(c, lc.config, models.EntityLoanProduct, product.ID, models.ChangeActionUpdate, product, updatedProduct)
+
+	c.JSON(http.StatusOK, updatedProduct)
+}
+
+// setStatus changes a product's status without making a new version, since
+// its terms stay the same
+func (lc *LoanProductController) setStatus(c *gin.Context, status models.LoanProductStatus) {
+	if !requireCompanyAccess(c, lc.config, c.Param("id")) {
+		return
+	}
+
+	product, ok := lc.findProduct(c)
+	if !ok {
+		return
+	}
+
+	result := lc.config.MongoDB.Collection("loan_products").FindOneAndUpdate(
+		c,
+		bson.M{"_id": product.ID, "company_id": product.CompanyID},
+		bson.M{"$set": bson.M{
+			"status":     status,
+			"updated_at": time.Now(),
+			"updated_by": c.GetString("user_id"),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedProduct models.LoanProduct
+	if err := result.Decode(&updatedProduct); err != nil {
+		utils.InternalError(c, "Error updating loan product")
+		return
+	}
+
+	recordChange(c, lc.config, models.EntityLoanProduct, product.ID, models.ChangeActionUpdate, product, updatedProduct)
+
+	c.JSON(http.StatusOK, updatedProduct)
+}
+
+// insertVersion stores the current terms of product as its version
+// product.CurrentVersion
+func (lc *LoanProductController) insertVersion(c *gin.Context, product models.LoanProduct,
+	at time.Time) (models.LoanProductVersion, error) {
+	version := models.LoanProductVersion{
+		ID:               primitive.NewObjectID().Hex(),
+		ProductID:        product.ID,
+		CompanyID:        product.CompanyID,
+		Version:          product.CurrentVersion,
+		LoanProductTerms: product.LoanProductTerms,
+		CreatedBy:        c.GetString("user_id"),
+		CreatedAt:        at,
+	}
+	_, err := lc.config.MongoDB.Collection("loan_product_versions").InsertOne(c, version)
+	return version, err
+}
+
+func (lc *LoanProductController) findProduct(c *gin.Context) (models.LoanProduct, bool) {
+	var product models.LoanProduct
+	err := lc.config.MongoDB.Collection("loan_products").FindOne(c, bson.M{
+		"_id":        c.Param("product_id"),
+		"company_id": c.Param("id"),
+	}).Decode(&product)
+	if err != nil {
+		utils.BadRequest(c, "Loan product not found")
+		return product, false
+	}
+	return product, true
+}
+
+// checkBranches checks the branch offices a product is limited to belong
+// to the company
+func (lc *LoanProductController) checkBranches(c *gin.Context, companyID string, branchIDs []string) bool {
+	if len(branchIDs) == 0 {
+		return true
+	}
+
+	count, err := lc.config.MongoDB.Collection("branch_offices").CountDocuments(c, bson.M{
+		"_id":        bson.M{"$in": branchIDs},
+		"company_id": companyID,
+	})
+	if err != nil {
+		utils.InternalError(c, "Error fetching branch offices")
+		return false
+	}
+	if int(count) != len(branchIDs) {
+		utils.BadRequest(c, "Invalid branch office ID in eligibility.branch_ids")
+		return false
+	}
+	return true
+}
+
+// normalizeProductTerms sorts the allowed terms and drops repeats, and
+// gives the lists a stable empty form so unchanged terms compare equal
+func normalizeProductTerms(terms *models.LoanProductTerms) {
+	sort.Ints(terms.Terms)
+	unique := terms.Terms[:0]
+	for i, n := range terms.Terms {
+		if i == 0 || n != terms.Terms[i-1] {
+			unique = append(unique, n)
+		}
+	}
+	terms.Terms = unique
+
+	if terms.Fees == nil {
+		terms.Fees = []models.ProductFee{}
+	}
+	if terms.Eligibility.CustomerTypes == nil {
+		terms.Eligibility.CustomerTypes = []models.CustomerType{}
+	}
+	if terms.Eligibility.BranchIDs == nil {
+		terms.Eligibility.BranchIDs = []string{}
+	}
+}
+
+// findLoanProductVersion loads one version of a company's loan product,
+// the terms a loan issued against it keeps
+func findLoanProductVersion(c *gin.Context, cfg *config.Config, companyID, productID string,
+	number int) (models.LoanProductVersion, error) {
+	var version models.LoanProductVersion
+	err := cfg.MongoDB.Collection("loan_product_versions").FindOne(c, bson.M{
+		"product_id": productID,
+		"company_id": companyID,
+		"version":    number,
+	}).Decode(&version)
+	return version, err
+}
@@ -0,0 +1,343 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"loan/config"
+	"loan/media"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var imageLimits = media.Limits{
+	MaxBytes:     5 << 20,
+	MinDimension: 64,
+	MaxDimension: 4096,
+}
+
+// Avatars are cropped square; logos keep their aspect ratio
+var (
+	avatarVariants = []media.Variant{
+		{Name: "lg", Size: 512, Square: true},
+		{Name: "md", Size: 256, Square: true},
+		{Name: "sm", Size: 64, Square: true},
+	}
+	logoVariants = []media.Variant{
+		{Name: "lg", Size: 1024},
+		{Name: "md", Size: 512},
+		{Name: "sm", Size: 128},
+	}
+)
+
+type MediaController struct {
+	config *config.Config
+}
+
+func NewMediaController(config *config.Config) *MediaController {
+	return &MediaController{config: config}
+}
+
+// UploadAvatar replaces the current user's avatar with an uploaded image
+func (mc *MediaController) UploadAvatar(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var user models.User
+	if err := mc.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": userID}).Decode(&user); err != nil {
+		utils.BadRequest(c, "User not found")
+		return
+	}
+
+	item, ok := mc.storeImage(c, models.MediaUserAvatar, userID, avatarVariants)
+	if !ok {
+		return
+	}
+
+	if !mc.setImageField(c, models.MediaUserAvatar, userID, item.Variants[0].URL, user) {
+		return
+	}
+
+	c.JSON(http.StatusOK, item)
+}
+
+// DeleteAvatar removes the current user's uploaded avatar
+func (mc *MediaController) DeleteAvatar(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var user models.User
+	if err := mc.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": userID}).Decode(&user); err != nil {
+		utils.BadRequest(c, "User not found")
+		return
+	}
+
+	if !mc.setImageField(c, models.MediaUserAvatar, userID, "", user) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Avatar deleted successfully"})
+}
+
+// UploadCompanyLogo replaces a company's logo with an uploaded image
+func (mc *MediaController) UploadCompanyLogo(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, mc.config, companyID) {
+		return
+	}
+
+	var company models.Company
+	if err := mc.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": companyID}).Decode(&company); err != nil {
+		utils.BadRequest(c, "Company not found")
+		return
+	}
+
+	item, ok := mc.storeImage(c, models.MediaCompanyLogo, companyID, logoVariants)
+	if !ok {
+		return
+	}
+
+	if !mc.setImageField(c, models.MediaCompanyLogo, companyID, item.Variants[0].URL, company) {
+		return
+	}
+
+	c.JSON(http.StatusOK, item)
+}
+
+// DeleteCompanyLogo removes a company's uploaded logo
+func (mc *MediaController) DeleteCompanyLogo(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, mc.config, companyID) {
+		return
+	}
+
+	var company models.Company
+	if err := mc.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": companyID}).Decode(&company); err != nil {
+		utils.BadRequest(c, "Company not found")
+		return
+	}
+
+	if !mc.setImageField(c, models.MediaCompanyLogo, companyID, "", company) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logo deleted successfully"})
+}
+
+// ServeMedia serves one variant of an uploaded image. Media URLs are never
+// reused for different content, so clients may cache them indefinitely.
+func (mc *MediaController) ServeMedia(c *gin.Context) {
+	var item models.Media
+	err := mc.config.MongoDB.Collection("media").FindOne(c, bson.M{"_id": c.Param("id")}).Decode(&item)
+	if err != nil {
+		utils.HandleError(c, http.StatusNotFound, "Media not found")
+		return
+	}
+
+	var variant *models.MediaVariant
+	for i := range item.Variants {
+		if item.Variants[i].Name == c.Param("variant") {
+			variant = &item.Variants[i]
+			break
+		}
+	}
+	if variant == nil {
+		utils.HandleError(c, http.StatusNotFound, "Media not found")
+		return
+	}
+
+	etag := `"` + variant.Checksum + `"`
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	blob, err := mc.config.Blobs.Get(c, variant.StorageKey)
+	if err != nil {
+		utils.InternalError(c, "Error reading media")
+		return
+	}
+	defer blob.Close()
+
+	c.DataFromReader(http.StatusOK, variant.Size, variant.ContentType, blob, map[string]string{
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// storeImage processes the uploaded "file" field into its variants, writes
+// them to the blob store and saves the media record. The first variant is
+// the largest and is the one linked from the owner.
+func (mc *MediaController) storeImage(c *gin.Context, kind models.MediaKind, ownerID string, variants []media.Variant) (*models.Media, bool) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		utils.BadRequest(c, "File is required")
+		return nil, false
+	}
+	if fileHeader.Size > imageLimits.MaxBytes {
+		utils.BadRequest(c, "File is too large")
+		return nil, false
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		utils.BadRequest(c, "Error reading file")
+		return nil, false
+	}
+	defer file.Close()
+
+	images, err := media.Process(file, imageLimits, variants)
+	if err != nil {
+		if errors.Is(err, media.ErrInvalidImage) {
+			utils.BadRequest(c, err.Error())
+		} else {
+			utils.InternalError(c, "Error processing image")
+		}
+		return nil, false
+	}
+
+	id, err := newMediaID()
+	if err != nil {
+		utils.InternalError(c, "Error storing image")
+		return nil, false
+	}
+
+	item := &models.Media{
+		ID:        id,
+		Kind:      kind,
+		OwnerID:   ownerID,
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: time.Now(),
+	}
+	for _, img := range images {
+		key := fmt.Sprintf("media/%s/%s", id, img.Variant)
+		if err := mc.config.Blobs.Put(c, key, bytes.NewReader(img.Data)); err != nil {
+			mc.deleteBlobs(c, item)
+			utils.InternalError(c, "Error storing image")
+			return nil, false
+		}
+
+		sum := sha256.Sum256(img.Data)
+		item.Variants = append(item.Variants, models.MediaVariant{
+			Name:        img.Variant,
+			URL:         fmt.Sprintf("/media/%s/%s", id, img.Variant),
+			ContentType: img.ContentType,
+			Width:       img.Width,
+			Height:      img.Height,
+			Size:        int64(len(img.Data)),
+			Checksum:    hex.EncodeToString(sum[:]),
+			StorageKey:  key,
+		})
+	}
+
+	if _, err := mc.config.MongoDB.Collection("media").InsertOne(c, item); err != nil {
+		mc.deleteBlobs(c, item)
+		utils.InternalError(c, "Error storing image")
+		return nil, false
+	}
+
+	utils.AddAuditResource(c, id)
+	return item, true
+}
+
+// setImageField points the owner at its new image URL, records the change
+// and removes the images it no longer uses
+func (mc *MediaController) setImageField(c *gin.Context, kind models.MediaKind, ownerID, url string, before interface{}) bool {
+	collection, field, entityType := "users", "avatar", models.EntityUser
+	if kind == models.MediaCompanyLogo {
+		collection, field, entityType = "companies", "logo_url", models.EntityCompany
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			field:        url,
+			"updated_at": time.Now(),
+		},
+	}
+	if kind == models.MediaCompanyLogo {
+		update["$set"].(bson.M)["updated_by"] = c.GetString("user_id")
+	}
+
+	result := mc.config.MongoDB.Collection(collection).FindOneAndUpdate(
+		c,
+		bson.M{"_id": ownerID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var after bson.M
+	if err := result.Decode(&after); err != nil {
+		utils.InternalError(c, "Error updating image")
+		return false
+	}
+
+	recordChange(c, mc.config, entityType, ownerID, models.ChangeActionUpdate, before, after)
+
+	keepID := ""
+	if url != "" {
+		keepID = mediaIDFromURL(url)
+	}
+	mc.purgeMedia(c, kind, ownerID, keepID)
+	return true
+}
+
+// purgeMedia deletes an owner's images other than keepID. Failures are
+// logged; an orphaned blob is harmless.
+func (mc *MediaController) purgeMedia(ctx context.Context, kind models.MediaKind, ownerID, keepID string) {
+	filter := bson.M{"kind": kind, "owner_id": ownerID}
+	if keepID != "" {
+		filter["_id"] = bson.M{"$ne": keepID}
+	}
+
+	cursor, err := mc.config.MongoDB.Collection("media").Find(ctx, filter)
+	if err != nil {
+		utils.Error("Error finding old media: " + err.Error())
+		return
+	}
+	var items []models.Media
+	if err := cursor.All(ctx, &items); err != nil {
+		utils.Error("Error finding old media: " + err.Error())
+		return
+	}
+
+	for i := range items {
+		mc.deleteBlobs(ctx, &items[i])
+		if _, err := mc.config.MongoDB.Collection("media").DeleteOne(ctx, bson.M{"_id": items[i].ID}); err != nil {
+			utils.Error("Error deleting media: " + err.Error())
+		}
+	}
+}
+
+func (mc *MediaController) deleteBlobs(ctx context.Context, item *models.Media) {
+	for _, v := range item.Variants {
+		if err := mc.config.Blobs.Delete(ctx, v.StorageKey); err != nil {
+			utils.Error("Error deleting media blob: " + err.Error())
+		}
+	}
+}
+
+// newMediaID returns a random hex ID. Unlike ObjectIDs these cannot be
+// guessed, which matters because /media is public.
+func newMediaID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// mediaIDFromURL extracts the ID from a /media/<id>/<variant> URL
+func mediaIDFromURL(url string) string {
+	parts := strings.Split(strings.TrimPrefix(url, "/media/"), "/")
+	return parts[0]
+}
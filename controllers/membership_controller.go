@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MembershipController manages which users work in a company, such as
+// consultants and auditors who serve several client companies
+type MembershipController struct {
+	config *config.Config
+}
+
+func NewMembershipController(config *config.Config) *MembershipController {
+	return &MembershipController{config: config}
+}
+
+// ListMembers lists the users who are members of a company
+func (mc *MembershipController) ListMembers(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, mc.config, companyID) {
+		return
+	}
+
+	page, limit := utils.GetPaginationParams(c)
+	skip := (page - 1) * limit
+
+	query, err := utils.ParseListQuery(c, userQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	filter := query.Merge(bson.M{"memberships.company_id": companyID})
+
+	collection := mc.config.MongoDB.Collection("users")
+	total, err := collection.CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error counting members")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(query.Sort).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching members")
+		return
+	}
+	defer cursor.Close(c)
+
+	members := []models.User{}
+	if err = cursor.All(c, &members); err != nil {
+		utils.InternalError(c, "Error parsing members")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, members, total, page, limit)
+}
+
+// SetMember adds a user to a company with the given roles, or changes the
+// roles of an existing member. Changing the roles in a user's home company
+// changes their own roles too.
+func (mc *MembershipController) SetMember(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, mc.config, companyID) {
+		return
+	}
+
+	var req models.MembershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+	for _, role := range req.Roles {
+		if role != models.RoleAdmin && role != models.RoleUser && role != models.RoleStaff {
+			utils.BadRequest(c, "Invalid membership role: "+string(role))
+			return
+		}
+	}
+
+	count, err := mc.config.MongoDB.Collection("companies").CountDocuments(c, bson.M{"_id": companyID})
+	if err != nil {
+		utils.InternalError(c, "Error fetching company")
+		return
+	}
+	if count == 0 {
+		utils.BadRequest(c, "Company not found")
+		return
+	}
+
+	var user models.User
+	err = mc.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": req.UserID}).Decode(&user)
+	if err != nil {
+		utils.BadRequest(c, "User not found")
+		return
+	}
+
+	updatedUser := user
+	updatedUser.Memberships = append([]models.CompanyMembership{}, user.Memberships...)
+	updatedUser.SetMembership(companyID, req.Roles, c.GetString("user_id"))
+	set := bson.M{
+		"memberships": updatedUser.Memberships,
+		"updated_at":  time.Now(),
+	}
+	if user.CompanyID == companyID {
+		// Super user is not a company role and is kept
+		roles := append([]models.Role{}, req.Roles...)
+		for _, role := range user.Roles {
+			if role == models.RoleSuperUser {
+				roles = append(roles, role)
+			}
+		}
+		set["roles"] = roles
+	}
+
+	result := mc.config.MongoDB.Collection("users").FindOneAndUpdate(c,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if err := result.Decode(&updatedUser); err != nil {
+		utils.InternalError(c, "Error updating user")
+		return
+	}
+
+	recordChange(c, mc.config, models.EntityUser, user.ID, models.ChangeActionUpdate, user, updatedUser)
+
+	updatedUser.Password = ""
+	c.JSON(http.StatusOK, updatedUser)
+}
+
+// RemoveMember takes a user out of a company. Users cannot be removed from
+// their home company this way; they are transferred out of it instead.
+func (mc *MembershipController) RemoveMember(c *gin.Context) {
+	companyID := c.Param("id")
+	if !requireCompanyAccess(c, mc.config, companyID) {
+		return
+	}
+
+	var user models.User
+	err := mc.config.MongoDB.Collection("users").FindOne(c, bson.M{
+		"_id":                    c.Param("user_id"),
+		"memberships.company_id": companyID,
+	}).Decode(&user)
+	if err != nil {
+		utils.BadRequest(c, "Member not found")
+		return
+	}
+	if user.CompanyID == companyID {
+		utils.HandleError(c, http.StatusConflict, "This is the user's home company; transfer them to move them out of it")
+		return
+	}
+
+	result := mc.config.MongoDB.Collection("users").FindOneAndUpdate(c,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$pull": bson.M{"memberships": bson.M{"company_id": companyID}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedUser models.User
+	if err := result.Decode(&updatedUser); err != nil {
+		utils.InternalError(c, "Error updating user")
+		return
+	}
+
+	recordChange(c, mc.config, models.EntityUser, user.ID, models.ChangeActionUpdate, user, updatedUser)
+
+	updatedUser.Password = ""
+	c.JSON(http.StatusOK, updatedUser)
+}
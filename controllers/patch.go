@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"loan/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondPatchError answers a failed utils.BindPatch
+func respondPatchError(c *gin.Context, err error) {
+	if err == utils.ErrUnsupportedPatch {
+		utils.HandleError(c, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	utils.BadRequest(c, err.Error())
+}
+
+// respondPatchConflict answers a patch whose document changed between being
+// read and written. Clients re-read and reapply the patch.
+func respondPatchConflict(c *gin.Context) {
+	utils.HandleError(c, http.StatusConflict, "Document was modified by another request, please retry")
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"loan/config"
+	"loan/models"
+	"loan/scheduler"
+	"loan/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SchedulerController struct {
+	config    *config.Config
+	scheduler *scheduler.Scheduler
+}
+
+func NewSchedulerController(config *config.Config, scheduler *scheduler.Scheduler) *SchedulerController {
+	return &SchedulerController{config: config, scheduler: scheduler}
+}
+
+// ListJobs lists the registered background jobs with their next and last run
+func (sc *SchedulerController) ListJobs(c *gin.Context) {
+	jobs, err := sc.scheduler.Jobs(c)
+	if err != nil {
+		utils.InternalError(c, "Error fetching jobs")
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+// TriggerJob runs a job now, outside its schedule
+func (sc *SchedulerController) TriggerJob(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	run, err := sc.scheduler.Trigger(c, c.Param("name"), userID.(string))
+	switch err {
+	case nil:
+	case scheduler.ErrUnknownJob:
+		utils.HandleError(c, http.StatusNotFound, "Job not found")
+		return
+	case scheduler.ErrLocked:
+		utils.HandleError(c, http.StatusConflict, "Job is already running")
+		return
+	default:
+		utils.InternalError(c, "Error starting job")
+		return
+	}
+
+	utils.AddAuditResource(c, run.ID)
+	c.JSON(http.StatusAccepted, run)
+}
+
+// ListJobRuns lists the run history of a job, newest first
+func (sc *SchedulerController) ListJobRuns(c *gin.Context) {
+	filter := bson.M{"job": c.Param("name")}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	page, limit := utils.GetPaginationParams(c)
+	skip := (page - 1) * limit
+
+	// Get total count
+	total, err := sc.config.MongoDB.Collection("job_runs").CountDocuments(c, filter)
+	if err != nil {
+		utils.InternalError(c, "Error counting job runs")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "started_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := sc.config.MongoDB.Collection("job_runs").Find(c, filter, opts)
+	if err != nil {
+		utils.InternalError(c, "Error fetching job runs")
+		return
+	}
+	defer cursor.Close(c)
+
+	var runs []models.JobRun
+	if err = cursor.All(c, &runs); err != nil {
+		utils.InternalError(c, "Error parsing job runs")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, runs, total, page, limit)
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"loan/config"
+	"loan/models"
+	"loan/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// callerCompanyIDs returns the companies the caller's queries are
+// restricted to: the company their token is scoped to, or their home
+// company for tokens without one, and every subsidiary below it, so access
+// inherits down a group. Super users can see every company and get nil.
+// When the caller has no company, or is no longer a member of the token's,
+// an error response is written and ok is false.
+func callerCompanyIDs(c *gin.Context, cfg *config.Config) (companyIDs []string, ok bool) {
+	if utils.HasRole(c, models.RoleSuperUser) {
+		return nil, true
+	}
+
+	var user models.User
+	err := cfg.MongoDB.Collection("users").FindOne(c, bson.M{"_id": c.GetString("user_id")}).Decode(&user)
+	if err != nil {
+		utils.BadRequest(c, "User not found")
+		return nil, false
+	}
+	companyID := c.GetString("company_id")
+	if companyID == "" {
+		companyID = user.CompanyID
+	} else if len(user.RolesIn(companyID)) == 0 {
+		utils.Forbidden(c, "You are no longer a member of this company")
+		return nil, false
+	}
+	if companyID == "" {
+		utils.Forbidden(c, "User is not associated with a company")
+		return nil, false
+	}
+
+	companyIDs, err = utils.CompanyGroupIDs(c, cfg.MongoDB, companyID)
+	if err != nil {
+		utils.InternalError(c, "Error fetching company group")
+		return nil, false
+	}
+	return companyIDs, true
+}
+
+// scopeFilter restricts field to the caller's companies. A nil companyIDs,
+// as returned for super users, leaves the filter unrestricted.
+func scopeFilter(filter bson.M, field string, companyIDs []string) bson.M {
+	if companyIDs != nil {
+		filter[field] = bson.M{"$in": companyIDs}
+	}
+	return filter
+}
+
+// scopeUserFilter restricts filter to users who belong to one of the
+// caller's companies, as their home company or through a membership. A nil
+// companyIDs leaves the filter unrestricted.
+func scopeUserFilter(filter bson.M, companyIDs []string) bson.M {
+	if companyIDs != nil {
+		filter["$or"] = bson.A{
+			bson.M{"company_id": bson.M{"$in": companyIDs}},
+			bson.M{"memberships.company_id": bson.M{"$in": companyIDs}},
+		}
+	}
+	return filter
+}
+
+// requireCompanyAccess writes a 403 and returns false unless the caller may
+// see companyID, which is their own company or one of its subsidiaries
+func requireCompanyAccess(c *gin.Context, cfg *config.Config, companyID string) bool {
+	companyIDs, ok := callerCompanyIDs(c, cfg)
+	if !ok {
+		return false
+	}
+	if companyIDs == nil {
+		return true
+	}
+	for _, id := range companyIDs {
+		if id == companyID {
+			return true
+		}
+	}
+	utils.Forbidden(c, "You do not have access to this company")
+	return false
+}
+
+// requireBranchAccess writes an error response and returns false unless the
+// caller may see companyID and branchID is one of its branch offices
+func requireBranchAccess(c *gin.Context, cfg *config.Config, companyID, branchID string) bool {
+	if !requireCompanyAccess(c, cfg, companyID) {
+		return false
+	}
+
+	count, err := cfg.MongoDB.Collection("branch_offices").CountDocuments(c, bson.M{
+		"_id":        branchID,
+		"company_id": companyID,
+	})
+	if err != nil {
+		utils.InternalError(c, "Error fetching branch office")
+		return false
+	}
+	if count == 0 {
+		utils.BadRequest(c, "Branch office not found")
+		return false
+	}
+	return true
+}
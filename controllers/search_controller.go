@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"loan/config"
+	"loan/search"
+	"loan/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxSearchLimit = 50
+
+type SearchController struct {
+	config *config.Config
+	index  search.Index
+}
+
+func NewSearchController(config *config.Config) *SearchController {
+	return &SearchController{
+		config: config,
+		index:  search.NewMongoIndex(config.MongoDB),
+	}
+}
+
+// Search finds companies, branch offices and users matching ?q=, limited to
+// the caller's company unless they are a super user
+func (sc *SearchController) Search(c *gin.Context) {
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		utils.BadRequest(c, "Search query is required")
+		return
+	}
+
+	limit := 10
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	query := search.Query{Text: text, Limit: limit}
+
+	if typesStr := c.Query("types"); typesStr != "" {
+		for _, t := range strings.Split(typesStr, ",") {
+			resultType := search.ResultType(strings.TrimSpace(t))
+			valid := false
+			for _, known := range search.AllTypes {
+				if resultType == known {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				utils.BadRequest(c, "Unknown search type: "+t)
+				return
+			}
+			query.Types = append(query.Types, resultType)
+		}
+	}
+
+	companyIDs, ok := callerCompanyIDs(c, sc.config)
+	if !ok {
+		return
+	}
+	query.CompanyIDs = companyIDs
+
+	results, err := sc.index.Search(c, query)
+	if err != nil {
+		utils.InternalError(c, "Error searching")
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
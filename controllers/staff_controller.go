@@ -3,6 +3,7 @@ package controllers
 import (
 	"loan/config"
 	"loan/models"
+	"loan/staffing"
 	"loan/utils"
 	"net/http"
 	"time"
@@ -13,21 +14,51 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Fields clients may filter and sort staff members on
+var staffQuerySpec = utils.QuerySpec{
+	Filterable: map[string]utils.FieldType{
+		"username":   utils.FieldString,
+		"full_name":  utils.FieldString,
+		"roles":      utils.FieldString,
+		"created_at": utils.FieldTime,
+		"updated_at": utils.FieldTime,
+	},
+	Sortable: map[string]bool{
+		"username":   true,
+		"full_name":  true,
+		"created_at": true,
+		"updated_at": true,
+	},
+	DefaultSort: bson.D{{Key: "created_at", Value: -1}},
+}
+
 type StaffController struct {
-	config *config.Config
+	config   *config.Config
+	staffing *staffing.Service
 }
 
 func NewStaffController(config *config.Config) *StaffController {
-	return &StaffController{config: config}
+	return &StaffController{
+		config:   config,
+		staffing: staffing.NewService(config.MongoDB),
+	}
 }
 
 // AssignStaffToBranch assigns a staff member to branch offices
 func (sc *StaffController) AssignStaffToBranch(c *gin.Context) {
+	if !requireCompanyAccess(c, sc.config, c.Param("id")) {
+		return
+	}
+
 	var assignment models.StaffAssignment
 	if err := c.ShouldBindJSON(&assignment); err != nil {
 		utils.BadRequest(c, "Invalid request body")
 		return
 	}
+	if assignment.CompanyID != c.Param("id") {
+		utils.BadRequest(c, "company_id does not match the company in the URL")
+		return
+	}
 
 	// Verify user exists and is a staff member
 	var user models.User
@@ -42,6 +73,14 @@ func (sc *StaffController) AssignStaffToBranch(c *gin.Context) {
 		return
 	}
 
+	// Moving someone out of another company goes through a transfer, which
+	// checks both companies are in the same group
+	if user.CompanyID != "" && user.CompanyID != assignment.CompanyID {
+		utils.HandleError(c, http.StatusConflict,
+			"Staff member belongs to another company; use /api/staff/"+user.ID+"/transfer to move them")
+		return
+	}
+
 	// Verify company exists
 	var company models.Company
 	err = sc.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": assignment.CompanyID}).Decode(&company)
@@ -51,27 +90,30 @@ func (sc *StaffController) AssignStaffToBranch(c *gin.Context) {
 	}
 
 	// Verify all branch offices exist and belong to the company
-	for _, branchID := range assignment.BranchOffices {
-		var branch models.BranchOffice
-		err = sc.config.MongoDB.Collection("branch_offices").FindOne(c,
-			bson.M{
-				"_id":        branchID,
-				"company_id": assignment.CompanyID,
-			}).Decode(&branch)
-		if err != nil {
-			utils.BadRequest(c, "Invalid branch office ID: "+branchID)
-			return
-		}
+	branchRoles, ok := sc.branchAssignments(c, assignment.CompanyID, assignment.BranchRoles, user)
+	if !ok {
+		return
+	}
+	if !sc.canChangeBranchRoles(c, user, assignment.CompanyID, branchRoles) {
+		return
 	}
 
-	// Update user's company and branch office assignments
+	// Update user's company and branch roles
 	update := bson.M{
 		"$set": bson.M{
-			"company_id":     assignment.CompanyID,
-			"branch_offices": assignment.BranchOffices,
-			"updated_at":     time.Now(),
+			"company_id":   assignment.CompanyID,
+			"branch_roles": branchRoles,
+			"updated_at":   time.Now(),
 		},
 	}
+	if user.Membership(assignment.CompanyID) == nil {
+		update["$push"] = bson.M{"memberships": models.CompanyMembership{
+			CompanyID: assignment.CompanyID,
+			Roles:     user.Roles,
+			JoinedAt:  time.Now(),
+			AddedBy:   c.GetString("user_id"),
+		}}
+	}
 
 	result := sc.config.MongoDB.Collection("users").FindOneAndUpdate(
 		c,
@@ -86,12 +128,9 @@ func (sc *StaffController) AssignStaffToBranch(c *gin.Context) {
 		return
 	}
 
-	// Record the assignment
-	assignment.AssignedAt = time.Now()
-	assignment.AssignedBy = c.GetString("user_id")
+	recordChange(c, sc.config, models.EntityUser, updatedUser.ID, models.ChangeActionUpdate, user, updatedUser)
 
-	_, err = sc.config.MongoDB.Collection("staff_assignments").InsertOne(c, assignment)
-	if err != nil {
+	if err := sc.staffing.RecordChange(c, user, updatedUser, c.GetString("user_id"), updatedUser.UpdatedAt); err != nil {
 		utils.InternalError(c, "Error recording assignment")
 		return
 	}
@@ -103,6 +142,9 @@ func (sc *StaffController) AssignStaffToBranch(c *gin.Context) {
 func (sc *StaffController) ListStaffByBranch(c *gin.Context) {
 	branchID := c.Param("branch_id")
 	companyID := c.Param("id")
+	if !requireCompanyAccess(c, sc.config, companyID) {
+		return
+	}
 
 	page, limit := utils.GetPaginationParams(c)
 	skip := (page - 1) * limit
@@ -119,11 +161,60 @@ func (sc *StaffController) ListStaffByBranch(c *gin.Context) {
 		return
 	}
 
-	// Find users assigned to this branch
-	filter := bson.M{
-		"roles":          models.RoleStaff,
-		"company_id":     companyID,
-		"branch_offices": branchID,
+	query, err := utils.ParseListQuery(c, staffQuerySpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	shape, err := utils.ParseShape(c, userShapeSpec)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	// Find users assigned to this branch, optionally in one role there
+	assigned := bson.M{"branch_id": branchID}
+	if role := c.Query("role"); role != "" {
+		if !models.BranchRole(role).Valid() {
+			utils.BadRequest(c, "Unknown branch role: "+role)
+			return
+		}
+		assigned["role"] = role
+	}
+	filter := query.Merge(bson.M{
+		"roles":        models.RoleStaff,
+		"company_id":   companyID,
+		"branch_roles": bson.M{"$elemMatch": assigned},
+	})
+
+	if format, ok := utils.GetExportFormat(c); ok {
+		utils.Export(c, format, sc.config.MongoDB.Collection("users"), filter, query.Sort,
+			"staff", userExportColumns)
+		return
+	}
+
+	if utils.IsCursorRequest(c) {
+		params, err := utils.GetCursorParams(c)
+		if err != nil {
+			utils.BadRequest(c, err.Error())
+			return
+		}
+
+		staff, page, err := utils.FindCursorPage[models.User](c, sc.config.MongoDB.Collection("users"), filter, params)
+		if err != nil {
+			utils.InternalError(c, "Error fetching staff members")
+			return
+		}
+
+		data, err := utils.ApplyShape(c, sc.config.MongoDB.Collection("users"), shape, staff)
+		if err != nil {
+			utils.InternalError(c, "Error fetching staff members")
+			return
+		}
+
+		utils.SendCursorResponse(c, data, page)
+		return
 	}
 
 	// Get total count
@@ -135,7 +226,7 @@ func (sc *StaffController) ListStaffByBranch(c *gin.Context) {
 
 	// Set options for pagination and sorting
 	opts := options.Find().
-		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSort(query.Sort).
 		SetSkip(int64(skip)).
 		SetLimit(int64(limit))
 
@@ -152,7 +243,13 @@ func (sc *StaffController) ListStaffByBranch(c *gin.Context) {
 		return
 	}
 
-	utils.SendPaginatedResponse(c, staff, total, page, limit)
+	data, err := utils.ApplyShape(c, sc.config.MongoDB.Collection("users"), shape, staff)
+	if err != nil {
+		utils.InternalError(c, "Error fetching staff members")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, data, total, page, limit)
 }
 
 // RemoveStaffFromBranch removes a staff member from a branch office
@@ -160,33 +257,27 @@ func (sc *StaffController) RemoveStaffFromBranch(c *gin.Context) {
 	userID := c.Param("user_id")
 	branchID := c.Param("branch_id")
 	companyID := c.Param("id")
+	if !requireCompanyAccess(c, sc.config, companyID) {
+		return
+	}
 
 	// Verify user exists and is assigned to the branch
 	var user models.User
 	err := sc.config.MongoDB.Collection("users").FindOne(c,
 		bson.M{
-			"_id":            userID,
-			"company_id":     companyID,
-			"branch_offices": branchID,
+			"_id":                    userID,
+			"company_id":             companyID,
+			"branch_roles.branch_id": branchID,
 		}).Decode(&user)
 	if err != nil {
 		utils.BadRequest(c, "Staff member not found")
 		return
 	}
 
-	// Remove branch from user's assignments
-	newBranches := make([]string, 0)
-	for _, branch := range user.BranchOffices {
-		if branch != branchID {
-			newBranches = append(newBranches, branch)
-		}
-	}
-
+	// Remove the user's role at the branch
 	update := bson.M{
-		"$set": bson.M{
-			"branch_offices": newBranches,
-			"updated_at":     time.Now(),
-		},
+		"$pull": bson.M{"branch_roles": bson.M{"branch_id": branchID}},
+		"$set":  bson.M{"updated_at": time.Now()},
 	}
 
 	result := sc.config.MongoDB.Collection("users").FindOneAndUpdate(
@@ -202,55 +293,153 @@ func (sc *StaffController) RemoveStaffFromBranch(c *gin.Context) {
 		return
 	}
 
+	recordChange(c, sc.config, models.EntityUser, updatedUser.ID, models.ChangeActionUpdate, user, updatedUser)
+
+	if err := sc.staffing.RecordChange(c, user, updatedUser, c.GetString("user_id"), updatedUser.UpdatedAt); err != nil {
+		utils.InternalError(c, "Error recording assignment")
+		return
+	}
+
 	c.JSON(http.StatusOK, updatedUser)
 }
 
-// RegisterStaff registers a new staff member
-func (sc *StaffController) RegisterStaff(c *gin.Context) {
-	var req models.RegisterRequest
+// TransferStaff moves a staff member from one branch office to another in
+// one step, within their company or to another company of the same group.
+// Their role moves with them unless a new one is given, and open work at
+// the old branch can be handed over to a colleague there.
+func (sc *StaffController) TransferStaff(c *gin.Context) {
+	var req models.StaffTransferRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.BadRequest(c, "Invalid request body")
 		return
 	}
+	if req.FromBranchID == req.ToBranchID {
+		utils.BadRequest(c, "from_branch_id and to_branch_id must differ")
+		return
+	}
 
-	// Ensure staff role is assigned
-	hasStaffRole := false
-	for _, role := range req.Roles {
-		if role == models.RoleStaff {
-			hasStaffRole = true
-			break
-		}
+	var user models.User
+	err := sc.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": c.Param("user_id")}).Decode(&user)
+	if err != nil {
+		utils.BadRequest(c, "User not found")
+		return
+	}
+	if !user.IsStaff() {
+		utils.BadRequest(c, "User is not a staff member")
+		return
 	}
-	if !hasStaffRole {
-		req.Roles = append(req.Roles, models.RoleStaff)
+	role := user.BranchRole(req.FromBranchID)
+	if role == "" {
+		utils.BadRequest(c, "Staff member has no role at from_branch_id")
+		return
+	}
+	if req.Role != "" {
+		role = req.Role
 	}
 
-	// Verify company exists if provided
-	if req.CompanyID != "" {
-		var company models.Company
-		err := sc.config.MongoDB.Collection("companies").FindOne(c, bson.M{"_id": req.CompanyID}).Decode(&company)
+	fromCompanyID := user.CompanyID
+	toCompanyID := req.ToCompanyID
+	if toCompanyID == "" {
+		toCompanyID = fromCompanyID
+	}
+	if !requireCompanyAccess(c, sc.config, fromCompanyID) {
+		return
+	}
+	if toCompanyID != fromCompanyID {
+		if !utils.HasRole(c, models.RoleAdmin) && !utils.HasRole(c, models.RoleSuperUser) {
+			utils.Forbidden(c, "Only admins can transfer staff between companies")
+			return
+		}
+		if !requireCompanyAccess(c, sc.config, toCompanyID) {
+			return
+		}
+		fromRoot, err := companyGroupRoot(c, sc.config, fromCompanyID)
 		if err != nil {
-			utils.BadRequest(c, "Company not found")
+			utils.InternalError(c, "Error fetching company group")
+			return
+		}
+		toRoot, err := companyGroupRoot(c, sc.config, toCompanyID)
+		if err != nil {
+			utils.InternalError(c, "Error fetching company group")
+			return
+		}
+		if fromRoot != toRoot {
+			utils.BadRequest(c, "Companies are not in the same group")
 			return
 		}
+	}
 
-		// Verify branch offices exist and belong to the company if provided
-		if len(req.BranchOffices) > 0 {
-			for _, branchID := range req.BranchOffices {
-				var branch models.BranchOffice
-				err = sc.config.MongoDB.Collection("branch_offices").FindOne(c,
-					bson.M{
-						"_id":        branchID,
-						"company_id": req.CompanyID,
-					}).Decode(&branch)
-				if err != nil {
-					utils.BadRequest(c, "Invalid branch office ID: "+branchID)
-					return
-				}
-			}
+	count, err := sc.config.MongoDB.Collection("branch_offices").CountDocuments(c, bson.M{
+		"_id":        req.ToBranchID,
+		"company_id": toCompanyID,
+	})
+	if err != nil {
+		utils.InternalError(c, "Error fetching branch offices")
+		return
+	}
+	if count == 0 {
+		utils.BadRequest(c, "Invalid to_branch_id")
+		return
+	}
+
+	if req.HandoverTo != "" {
+		var colleague models.User
+		err := sc.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": req.HandoverTo}).Decode(&colleague)
+		if err != nil || colleague.ID == user.ID || colleague.CompanyID != fromCompanyID ||
+			!colleague.HasAccessToBranch(req.FromBranchID) {
+			utils.BadRequest(c, "handover_to must be another staff member at from_branch_id")
+			return
 		}
 	}
 
+	now := time.Now()
+	userID := c.GetString("user_id")
+	transfer := models.StaffTransfer{
+		UserID:        user.ID,
+		FromCompanyID: fromCompanyID,
+		FromBranchID:  req.FromBranchID,
+		ToCompanyID:   toCompanyID,
+		ToBranchID:    req.ToBranchID,
+		Role:          role,
+		HandoverTo:    req.HandoverTo,
+		Reason:        req.Reason,
+		TransferredBy: userID,
+		TransferredAt: now,
+	}
+	branchRoles, revoked := staffing.TransferredRoles(user, transfer, req.RevokeOtherRoles, userID, now)
+	transfer.RevokedRoles = revoked
+
+	if toCompanyID == fromCompanyID && !sc.canChangeBranchRoles(c, user, fromCompanyID, branchRoles) {
+		return
+	}
+
+	updatedUser, err := sc.staffing.Transfer(c, user, &transfer, branchRoles)
+	if err == staffing.ErrStaleUser {
+		utils.HandleError(c, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		utils.InternalError(c, "Error transferring staff member")
+		return
+	}
+
+	recordChange(c, sc.config, models.EntityUser, updatedUser.ID, models.ChangeActionUpdate, user, updatedUser)
+
+	c.JSON(http.StatusOK, models.StaffTransferResponse{
+		Transfer: transfer,
+		User:     updatedUser,
+	})
+}
+
+// RegisterStaff signs up a new staff member. They belong to no company and
+// hold no branch roles until assigned to a branch office.
+func (sc *StaffController) RegisterStaff(c *gin.Context) {
+	var req models.StaffRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "Invalid request body")
+		return
+	}
+
 	// Check if username already exists
 	var existingUser models.User
 	err := sc.config.MongoDB.Collection("users").FindOne(c, bson.M{"username": req.Username}).Decode(&existingUser)
@@ -259,17 +448,18 @@ func (sc *StaffController) RegisterStaff(c *gin.Context) {
 		return
 	}
 
-	// Create new user
+	// Create new user with only the staff role; companies and branch roles
+	// are granted afterwards by someone who may grant them
 	user := models.User{
-		ID:            primitive.NewObjectID().Hex(),
-		Username:      req.Username,
-		Password:      req.Password,
-		FullName:      req.FullName,
-		Roles:         req.Roles,
-		CompanyID:     req.CompanyID,
-		BranchOffices: req.BranchOffices,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+		ID:          primitive.NewObjectID().Hex(),
+		Username:    req.Username,
+		Password:    req.Password,
+		FullName:    req.FullName,
+		Roles:       []models.Role{models.RoleStaff},
+		Memberships: []models.CompanyMembership{},
+		BranchRoles: []models.BranchAssignment{},
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
 	}
 
 	// Hash password
@@ -285,10 +475,12 @@ func (sc *StaffController) RegisterStaff(c *gin.Context) {
 		return
 	}
 
+	recordChange(c, sc.config, models.EntityUser, user.ID, models.ChangeActionCreate, nil, user)
+
 	user.Password = "" // Don't send password back
 
 	// Generate token
-	token, err := config.GenerateToken(user.ID, user.Roles)
+	token, err := config.GenerateToken(user.ID, user.Roles, "")
 	if err != nil {
 		utils.InternalError(c, "Error generating token")
 		return
@@ -299,3 +491,93 @@ func (sc *StaffController) RegisterStaff(c *gin.Context) {
 		User:  user,
 	})
 }
+
+// branchAssignments checks that each requested branch office belongs to the
+// company and turns the requests into assignments. Assignments the user
+// already holds keep when and by whom they were made. When a request is
+// invalid an error response is written and ok is false.
+func (sc *StaffController) branchAssignments(c *gin.Context, companyID string, requests []models.BranchRoleRequest,
+	user models.User) ([]models.BranchAssignment, bool) {
+	assignments := make([]models.BranchAssignment, 0, len(requests))
+	seen := make(map[string]bool, len(requests))
+
+	for _, req := range requests {
+		if seen[req.BranchID] {
+			utils.BadRequest(c, "Branch office appears more than once: "+req.BranchID)
+			return nil, false
+		}
+		seen[req.BranchID] = true
+
+		count, err := sc.config.MongoDB.Collection("branch_offices").CountDocuments(c,
+			bson.M{
+				"_id":        req.BranchID,
+				"company_id": companyID,
+			})
+		if err != nil {
+			utils.InternalError(c, "Error fetching branch offices")
+			return nil, false
+		}
+		if count == 0 {
+			utils.BadRequest(c, "Invalid branch office ID: "+req.BranchID)
+			return nil, false
+		}
+
+		assignment := models.BranchAssignment{
+			BranchID:   req.BranchID,
+			Role:       req.Role,
+			AssignedAt: time.Now(),
+			AssignedBy: c.GetString("user_id"),
+		}
+		if user.CompanyID == companyID {
+			for _, existing := range user.BranchRoles {
+				if existing.BranchID == req.BranchID && existing.Role == req.Role {
+					assignment = existing
+				}
+			}
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, true
+}
+
+// canChangeBranchRoles lets admins and super users change any assignment.
+// Anyone else must be a branch manager at every branch office whose
+// assignment for user is added, changed or removed. Otherwise a 403 is
+// written and false returned.
+func (sc *StaffController) canChangeBranchRoles(c *gin.Context, user models.User, companyID string,
+	assignments []models.BranchAssignment) bool {
+	if utils.HasRole(c, models.RoleAdmin) || utils.HasRole(c, models.RoleSuperUser) {
+		return true
+	}
+
+	before := make(map[string]models.BranchRole)
+	if user.CompanyID == companyID {
+		for _, a := range user.BranchRoles {
+			before[a.BranchID] = a.Role
+		}
+	}
+	changed := make(map[string]bool)
+	for _, a := range assignments {
+		if before[a.BranchID] != a.Role {
+			changed[a.BranchID] = true
+		}
+		delete(before, a.BranchID)
+	}
+	for branchID := range before {
+		changed[branchID] = true
+	}
+
+	var caller models.User
+	err := sc.config.MongoDB.Collection("users").FindOne(c, bson.M{"_id": c.GetString("user_id")}).Decode(&caller)
+	if err != nil {
+		utils.BadRequest(c, "User not found")
+		return false
+	}
+	for branchID := range changed {
+		if !caller.HasBranchRole(branchID, models.BranchRoleManager) {
+			utils.Forbidden(c, "Only a branch manager can change staff at branch office "+branchID)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package jobs
+
+import (
+	"context"
+	"loan/config"
+	"loan/models"
+	"loan/scheduler"
+	"loan/servicing"
+	"loan/staffing"
+	"loan/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// How long finished import jobs and job runs are kept
+	importJobRetention = 30 * 24 * time.Hour
+	jobRunRetention    = 90 * 24 * time.Hour
+
+	// How far ahead document expiry reminders look
+	documentReminderWindow = 30 * 24 * time.Hour
+)
+
+// Register adds the built-in background jobs to the scheduler
+func Register(s *scheduler.Scheduler, cfg *config.Config) error {
+	if err := s.Register("purge_import_jobs", "30 2 * * *", 0, purgeImportJobs(cfg)); err != nil {
+		return err
+	}
+	if err := s.Register("purge_job_runs", "45 2 * * *", 0, purgeJobRuns(cfg)); err != nil {
+		return err
+	}
+	if err := s.Register("document_expiry_reminders", "0 7 * * *", 0, documentExpiryReminders(cfg)); err != nil {
+		return err
+	}
+	if err := s.Register("apply_staff_assignments", "*/5 * * * *", 0, applyStaffAssignments(cfg)); err != nil {
+		return err
+	}
+	if err := s.Register("update_loan_accounts", "15 0 * * *", 0, updateLoanAccounts(cfg)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// purgeImportJobs deletes import job records and their row errors once they
+// have been finished for longer than the retention period
+func purgeImportJobs(cfg *config.Config) scheduler.JobFunc {
+	return func(ctx context.Context) error {
+		result, err := cfg.MongoDB.Collection("import_jobs").DeleteMany(ctx, bson.M{
+			"finished_at": bson.M{"$lt": time.Now().Add(-importJobRetention)},
+		})
+		if err != nil {
+			return err
+		}
+		utils.Info("Purged import jobs", utils.Fields(map[string]interface{}{"deleted": result.DeletedCount}))
+		return nil
+	}
+}
+
+// purgeJobRuns keeps the run history from growing without bound
+func purgeJobRuns(cfg *config.Config) scheduler.JobFunc {
+	return func(ctx context.Context) error {
+		result, err := cfg.MongoDB.Collection("job_runs").DeleteMany(ctx, bson.M{
+			"status":     bson.M{"$ne": models.JobRunRunning},
+			"started_at": bson.M{"$lt": time.Now().Add(-jobRunRetention)},
+		})
+		if err != nil {
+			return err
+		}
+		utils.Info("Purged job runs", utils.Fields(map[string]interface{}{"deleted": result.DeletedCount}))
+		return nil
+	}
+}
+
+// documentExpiryReminders reports company documents that expire soon. It
+// only logs for now; notifications hook in here once they exist.
+func documentExpiryReminders(cfg *config.Config) scheduler.JobFunc {
+	return func(ctx context.Context) error {
+		now := time.Now()
+		cursor, err := cfg.MongoDB.Collection("company_documents").Find(ctx, bson.M{
+			"expiry_date": bson.M{"$gte": now, "$lte": now.Add(documentReminderWindow)},
+		})
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var doc models.CompanyDocument
+			if err := cursor.Decode(&doc); err != nil {
+				return err
+			}
+			utils.Warn("Company document expires soon", utils.Fields(map[string]interface{}{
+				"company_id":  doc.CompanyID,
+				"document_id": doc.ID,
+				"type":        doc.Type,
+				"expiry_date": doc.ExpiryDate,
+			}))
+		}
+		return cursor.Err()
+	}
+}
+
+// applyStaffAssignments starts scheduled staff assignments and ends
+// temporary cover once their effective dates are reached
+func applyStaffAssignments(cfg *config.Config) scheduler.JobFunc {
+	service := staffing.NewService(cfg.MongoDB)
+	return func(ctx context.Context) error {
+		started, ended, err := service.ApplyDue(ctx, time.Now())
+		if started > 0 || ended > 0 {
+			utils.Info("Applied staff assignments", utils.Fields(map[string]interface{}{
+				"started": started,
+				"ended":   ended,
+			}))
+		}
+		return err
+	}
+}
+
+// updateLoanAccounts applies credit to installments that have fallen due
+// and marks and penalizes the overdue ones
+func updateLoanAccounts(cfg *config.Config) scheduler.JobFunc {
+	service := servicing.NewService(cfg.MongoDB)
+	return func(ctx context.Context) error {
+		changed, err := service.ApplyDue(ctx, time.Now())
+		if changed > 0 {
+			utils.Info("Updated loan accounts", utils.Fields(map[string]interface{}{"changed": changed}))
+		}
+		return err
+	}
+}
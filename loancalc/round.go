@@ -0,0 +1,57 @@
+package loancalc
+
+import (
+	"fmt"
+	"loan/models"
+	"math"
+)
+
+const defaultDecimals = 2
+
+// rounder rounds amounts to a number of decimals in one rounding mode
+type rounder struct {
+	scale float64
+	mode  models.RoundingMode
+}
+
+func newRounder(r models.Rounding) (rounder, error) {
+	decimals := defaultDecimals
+	if r.Decimals != nil {
+		decimals = *r.Decimals
+	}
+	if decimals < 0 || decimals > 4 {
+		return rounder{}, fmt.Errorf("rounding decimals must be between 0 and 4")
+	}
+
+	mode := r.Mode
+	switch mode {
+	case "":
+		mode = models.RoundHalfUp
+	case models.RoundHalfUp, models.RoundHalfEven, models.RoundDown, models.RoundUp:
+	default:
+		return rounder{}, fmt.Errorf("unknown rounding mode %q", r.Mode)
+	}
+	return rounder{scale: math.Pow10(decimals), mode: mode}, nil
+}
+
+// round rounds x. Noise from float arithmetic below a millionth of the
+// smallest unit is dropped first, so 2.675 rounds half up to 2.68 even
+// though its float is slightly below it.
+func (r rounder) round(x float64) float64 {
+	units := math.Round(x*r.scale*1e6) / 1e6
+	switch r.mode {
+	case models.RoundHalfEven:
+		units = math.RoundToEven(units)
+	case models.RoundDown:
+		units = math.Trunc(units)
+	case models.RoundUp:
+		if units < 0 {
+			units = math.Floor(units)
+		} else {
+			units = math.Ceil(units)
+		}
+	default:
+		units = math.Round(units)
+	}
+	return units / r.scale
+}
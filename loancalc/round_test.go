@@ -0,0 +1,53 @@
+package loancalc
+
+import (
+	"loan/models"
+	"testing"
+)
+
+func TestRound(t *testing.T) {
+	two, zero, three := 2, 0, 3
+	tests := []struct {
+		name     string
+		rounding models.Rounding
+		in, want float64
+	}{
+		{"defaults to half up at 2 decimals", models.Rounding{}, 2.675, 2.68},
+		{"half up rounds halves away from zero", models.Rounding{Decimals: &two, Mode: models.RoundHalfUp}, -2.675, -2.68},
+		{"half up below a half", models.Rounding{Decimals: &two, Mode: models.RoundHalfUp}, 2.674, 2.67},
+		{"half even rounds a half to even", models.Rounding{Decimals: &two, Mode: models.RoundHalfEven}, 2.665, 2.66},
+		{"half even rounds the other half up", models.Rounding{Decimals: &two, Mode: models.RoundHalfEven}, 2.675, 2.68},
+		{"down truncates", models.Rounding{Decimals: &two, Mode: models.RoundDown}, 2.679, 2.67},
+		{"down truncates negatives", models.Rounding{Decimals: &two, Mode: models.RoundDown}, -2.679, -2.67},
+		{"down keeps whole units lost to float noise", models.Rounding{Decimals: &two, Mode: models.RoundDown}, 0.1 + 0.2, 0.3},
+		{"up rounds away from zero", models.Rounding{Decimals: &two, Mode: models.RoundUp}, 2.671, 2.68},
+		{"up rounds negatives away from zero", models.Rounding{Decimals: &two, Mode: models.RoundUp}, -2.671, -2.68},
+		{"up leaves exact amounts", models.Rounding{Decimals: &two, Mode: models.RoundUp}, 1.1 * 3, 3.3},
+		{"zero decimals", models.Rounding{Decimals: &zero, Mode: models.RoundHalfUp}, 1234.5, 1235},
+		{"three decimals", models.Rounding{Decimals: &three, Mode: models.RoundDown}, 1.23456, 1.234},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newRounder(tt.rounding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.round(tt.in); got != tt.want {
+				t.Fatalf("round(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRounderRejectsBadRounding(t *testing.T) {
+	five, negative := 5, -1
+	for _, r := range []models.Rounding{
+		{Decimals: &five},
+		{Decimals: &negative},
+		{Mode: "bankers"},
+	} {
+		if _, err := newRounder(r); err == nil {
+			t.Errorf("newRounder(%+v) succeeded, want an error", r)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Package loancalc draws up loan installment schedules. It only does
+// arithmetic and can be used without a database.
+package loancalc
+
+import (
+	"errors"
+	"fmt"
+	"loan/models"
+	"math"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+var periodsPerYear = map[models.RepaymentFrequency]float64{
+	models.RepaymentWeekly:   52,
+	models.RepaymentBiweekly: 26,
+	models.RepaymentMonthly:  12,
+}
+
+// Installment is one payment of a schedule. Balance is the principal still
+// owed after it. During a full grace period nothing is paid and the
+// interest is capitalized instead, or for flat-rate loans deferred to the
+// installments that follow.
+type Installment struct {
+	Number      int     `json:"number"`
+	DueDate     string  `json:"due_date"`
+	Payment     float64 `json:"payment"`
+	Principal   float64 `json:"principal"`
+	Interest    float64 `json:"interest"`
+	Capitalized float64 `json:"capitalized,omitempty"`
+	Balance     float64 `json:"balance"`
+	Grace       bool    `json:"grace,omitempty"`
+}
+
+// Totals add up a schedule. Principal includes any capitalized interest.
+type Totals struct {
+	Installments int     `json:"installments"`
+	Principal    float64 `json:"principal"`
+	Interest     float64 `json:"interest"`
+	Capitalized  float64 `json:"capitalized,omitempty"`
+	Payment      float64 `json:"payment"`
+}
+
+type Schedule struct {
+	Installments []Installment `json:"installments"`
+	Totals       Totals        `json:"totals"`
+}
+
+// Generate draws up the installments of the loan req describes.
+//
+// Flat-rate loans charge the same interest, on the original principal,
+// every period. Declining-balance loans repay equal principal and charge
+// interest on what is still owed. Annuity loans charge interest the same
+// way but pay equal installments. Every amount is rounded, and the last
+// installment repays whatever principal rounding has left, so the
+// principal repaid always adds up to what was lent.
+//
+// Interest on the balance is rounded as it adds up rather than line by
+// line, so rounding down or up never loses or adds more than one unit in
+// total; an installment's interest may differ from its balance times the
+// rate by a unit. Flat-rate interest is rounded once and charged the same
+// every period.
+func Generate(req models.ScheduleRequest) (Schedule, error) {
+	if err := check(req); err != nil {
+		return Schedule{}, err
+	}
+	round, err := newRounder(req.Rounding)
+	if err != nil {
+		return Schedule{}, err
+	}
+	start, err := time.Parse(dateLayout, req.StartDate)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("start_date must be a date like %s", dateLayout)
+	}
+
+	rate := req.InterestRate / 100 / periodsPerYear[req.RepaymentFrequency]
+	n := req.Installments
+	grace := req.GracePeriods
+	amortizing := n - grace
+	fullGrace := req.GraceType == models.GraceFull
+
+	balance := round.round(req.Principal)
+	balloon := round.round(req.BalloonAmount)
+	flatInterest := round.round(balance * rate)
+
+	var accrued, charged float64       // Interest on the balance owed and charged so far
+	var deferred, deferredLeft float64 // Flat-rate interest put off by a full grace
+	var portion, payment float64       // Set once the grace periods are over
+
+	schedule := Schedule{Installments: make([]Installment, 0, n)}
+	for i := 1; i <= n; i++ {
+		inst := Installment{Number: i, DueDate: dueDate(start, req.RepaymentFrequency, i).Format(dateLayout)}
+
+		interest := flatInterest
+		if req.InterestMethod != models.InterestFlat {
+			accrued += balance * rate
+			interest = round.round(round.round(accrued) - charged)
+			charged = round.round(charged + interest)
+		}
+
+		if i <= grace {
+			inst.Grace = true
+			switch {
+			case !fullGrace:
+				inst.Interest = interest
+			case req.InterestMethod == models.InterestFlat:
+				deferred = round.round(deferred + interest)
+				deferredLeft = deferred
+			default:
+				inst.Capitalized = interest
+				balance = round.round(balance + interest)
+			}
+		} else {
+			if i == grace+1 {
+				portion = round.round((balance - balloon) / float64(amortizing))
+				payment = round.round(annuity(balance, balloon, rate, amortizing))
+			}
+
+			principal := portion
+			if req.InterestMethod == models.InterestAnnuity {
+				principal = round.round(payment - interest)
+			}
+			if deferred > 0 {
+				share := round.round(deferred / float64(amortizing))
+				if i == n {
+					share = deferredLeft
+				}
+				interest = round.round(interest + share)
+				deferredLeft = round.round(deferredLeft - share)
+			}
+			if i == n || principal > balance {
+				principal = balance
+			}
+			if principal < 0 {
+				principal = 0
+			}
+
+			inst.Principal = principal
+			inst.Interest = interest
+			balance = round.round(balance - principal)
+		}
+
+		inst.Payment = round.round(inst.Principal + inst.Interest)
+		inst.Balance = balance
+		schedule.Installments = append(schedule.Installments, inst)
+
+		schedule.Totals.Principal = round.round(schedule.Totals.Principal + inst.Principal)
+		schedule.Totals.Interest = round.round(schedule.Totals.Interest + inst.Interest)
+		schedule.Totals.Capitalized = round.round(schedule.Totals.Capitalized + inst.Capitalized)
+		schedule.Totals.Payment = round.round(schedule.Totals.Payment + inst.Payment)
+	}
+	schedule.Totals.Installments = n
+	return schedule, nil
+}
+
+// check checks req the way its binding tags do, for callers that did not
+// bind it from a request
+func check(req models.ScheduleRequest) error {
+	switch {
+	case req.Principal <= 0:
+		return errors.New("principal must be greater than 0")
+	case req.InterestRate < 0:
+		return errors.New("interest_rate cannot be negative")
+	case !req.InterestMethod.Valid():
+		return fmt.Errorf("unknown interest method %q", req.InterestMethod)
+	case !req.RepaymentFrequency.Valid():
+		return fmt.Errorf("unknown repayment frequency %q", req.RepaymentFrequency)
+	case req.Installments <= 0:
+		return errors.New("installments must be greater than 0")
+	case req.GracePeriods < 0 || req.GracePeriods >= req.Installments:
+		return errors.New("grace_periods must leave at least one installment to repay principal")
+	case req.GraceType != "" && req.GraceType != models.GracePrincipal && req.GraceType != models.GraceFull:
+		return fmt.Errorf("unknown grace type %q", req.GraceType)
+	case req.BalloonAmount < 0 || req.BalloonAmount >= req.Principal:
+		return errors.New("balloon_amount must be less than the principal")
+	}
+	return nil
+}
+
+// annuity is the equal installment that repays principal over n periods at
+// rate per period, leaving balloon to be repaid on top of the last one
+func annuity(principal, balloon, rate float64, n int) float64 {
+	if rate == 0 {
+		return (principal - balloon) / float64(n)
+	}
+	discount := math.Pow(1+rate, -float64(n))
+	return (principal - balloon*discount) * rate / (1 - discount)
+}
+
+// dueDate is when installment i is due. Monthly installments fall on the
+// day of the month of start, or the month's last day when it is shorter.
+func dueDate(start time.Time, frequency models.RepaymentFrequency, i int) time.Time {
+	switch frequency {
+	case models.RepaymentWeekly:
+		return start.AddDate(0, 0, 7*i)
+	case models.RepaymentBiweekly:
+		return start.AddDate(0, 0, 14*i)
+	}
+
+	first := time.Date(start.Year(), start.Month()+time.Month(i), 1, 0, 0, 0, 0, start.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	day := start.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return first.AddDate(0, 0, day-1)
+}
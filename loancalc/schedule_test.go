@@ -0,0 +1,255 @@
+package loancalc
+
+import (
+	"loan/models"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	zero := 0
+	tests := []struct {
+		name   string
+		req    models.ScheduleRequest
+		want   []Installment
+		totals Totals
+	}{
+		{
+			name: "flat rate from the end of January",
+			req: models.ScheduleRequest{Principal: 1200, InterestRate: 12, InterestMethod: models.InterestFlat,
+				RepaymentFrequency: models.RepaymentMonthly, Installments: 3, StartDate: "2026-01-31"},
+			want: []Installment{
+				{1, "2026-02-28", 412, 400, 12, 0, 800, false},
+				{2, "2026-03-31", 412, 400, 12, 0, 400, false},
+				{3, "2026-04-30", 412, 400, 12, 0, 0, false},
+			},
+			totals: Totals{Installments: 3, Principal: 1200, Interest: 36, Payment: 1236},
+		},
+		{
+			name: "declining balance",
+			req: models.ScheduleRequest{Principal: 1200, InterestRate: 12, InterestMethod: models.InterestDecliningBalance,
+				RepaymentFrequency: models.RepaymentMonthly, Installments: 3, StartDate: "2026-01-31"},
+			want: []Installment{
+				{1, "2026-02-28", 412, 400, 12, 0, 800, false},
+				{2, "2026-03-31", 408, 400, 8, 0, 400, false},
+				{3, "2026-04-30", 404, 400, 4, 0, 0, false},
+			},
+			totals: Totals{Installments: 3, Principal: 1200, Interest: 24, Payment: 1224},
+		},
+		{
+			name: "annuity",
+			req: models.ScheduleRequest{Principal: 1000, InterestRate: 12, InterestMethod: models.InterestAnnuity,
+				RepaymentFrequency: models.RepaymentMonthly, Installments: 3, StartDate: "2026-01-31"},
+			want: []Installment{
+				{1, "2026-02-28", 340.02, 330.02, 10, 0, 669.98, false},
+				{2, "2026-03-31", 340.02, 333.32, 6.7, 0, 336.66, false},
+				{3, "2026-04-30", 340.03, 336.66, 3.37, 0, 0, false},
+			},
+			totals: Totals{Installments: 3, Principal: 1000, Interest: 20.07, Payment: 1020.07},
+		},
+		{
+			name: "principal grace",
+			req: models.ScheduleRequest{Principal: 1000, InterestRate: 12, InterestMethod: models.InterestDecliningBalance,
+				RepaymentFrequency: models.RepaymentMonthly, Installments: 4, StartDate: "2026-01-01",
+				GracePeriods: 2, GraceType: models.GracePrincipal},
+			want: []Installment{
+				{1, "2026-02-01", 10, 0, 10, 0, 1000, true},
+				{2, "2026-03-01", 10, 0, 10, 0, 1000, true},
+				{3, "2026-04-01", 510, 500, 10, 0, 500, false},
+				{4, "2026-05-01", 505, 500, 5, 0, 0, false},
+			},
+			totals: Totals{Installments: 4, Principal: 1000, Interest: 35, Payment: 1035},
+		},
+		{
+			name: "full grace capitalizes interest",
+			req: models.ScheduleRequest{Principal: 1000, InterestRate: 12, InterestMethod: models.InterestDecliningBalance,
+				RepaymentFrequency: models.RepaymentMonthly, Installments: 4, StartDate: "2026-01-01",
+				GracePeriods: 2, GraceType: models.GraceFull},
+			want: []Installment{
+				{1, "2026-02-01", 0, 0, 0, 10, 1010, true},
+				{2, "2026-03-01", 0, 0, 0, 10.1, 1020.1, true},
+				{3, "2026-04-01", 520.25, 510.05, 10.2, 0, 510.05, false},
+				{4, "2026-05-01", 515.15, 510.05, 5.1, 0, 0, false},
+			},
+			totals: Totals{Installments: 4, Principal: 1020.1, Interest: 15.3, Capitalized: 20.1, Payment: 1035.4},
+		},
+		{
+			name: "full grace defers flat-rate interest",
+			req: models.ScheduleRequest{Principal: 1000, InterestRate: 12, InterestMethod: models.InterestFlat,
+				RepaymentFrequency: models.RepaymentMonthly, Installments: 4, StartDate: "2026-01-01",
+				GracePeriods: 2, GraceType: models.GraceFull},
+			want: []Installment{
+				{1, "2026-02-01", 0, 0, 0, 0, 1000, true},
+				{2, "2026-03-01", 0, 0, 0, 0, 1000, true},
+				{3, "2026-04-01", 520, 500, 20, 0, 500, false},
+				{4, "2026-05-01", 520, 500, 20, 0, 0, false},
+			},
+			totals: Totals{Installments: 4, Principal: 1000, Interest: 40, Payment: 1040},
+		},
+		{
+			name: "annuity with a balloon",
+			req: models.ScheduleRequest{Principal: 1000, InterestRate: 12, InterestMethod: models.InterestAnnuity,
+				RepaymentFrequency: models.RepaymentMonthly, Installments: 3, StartDate: "2026-01-01",
+				BalloonAmount: 400},
+			want: []Installment{
+				{1, "2026-02-01", 208.01, 198.01, 10, 0, 801.99, false},
+				{2, "2026-03-01", 208.01, 199.99, 8.02, 0, 602, false},
+				{3, "2026-04-01", 608.02, 602, 6.02, 0, 0, false},
+			},
+			totals: Totals{Installments: 3, Principal: 1000, Interest: 24.04, Payment: 1024.04},
+		},
+		{
+			name: "weekly flat rate",
+			req: models.ScheduleRequest{Principal: 1000, InterestRate: 26, InterestMethod: models.InterestFlat,
+				RepaymentFrequency: models.RepaymentWeekly, Installments: 3, StartDate: "2026-01-01"},
+			want: []Installment{
+				{1, "2026-01-08", 338.33, 333.33, 5, 0, 666.67, false},
+				{2, "2026-01-15", 338.33, 333.33, 5, 0, 333.34, false},
+				{3, "2026-01-22", 338.34, 333.34, 5, 0, 0, false},
+			},
+			totals: Totals{Installments: 3, Principal: 1000, Interest: 15, Payment: 1015},
+		},
+		{
+			name: "whole amounts rounded up",
+			req: models.ScheduleRequest{Principal: 1000, InterestRate: 10, InterestMethod: models.InterestFlat,
+				RepaymentFrequency: models.RepaymentBiweekly, Installments: 3, StartDate: "2026-01-01",
+				Rounding: models.Rounding{Decimals: &zero, Mode: models.RoundUp}},
+			want: []Installment{
+				{1, "2026-01-15", 338, 334, 4, 0, 666, false},
+				{2, "2026-01-29", 338, 334, 4, 0, 332, false},
+				{3, "2026-02-12", 336, 332, 4, 0, 0, false},
+			},
+			totals: Totals{Installments: 3, Principal: 1000, Interest: 12, Payment: 1012},
+		},
+		{
+			name: "whole amounts rounded half to even",
+			req: models.ScheduleRequest{Principal: 1000, InterestRate: 10, InterestMethod: models.InterestDecliningBalance,
+				RepaymentFrequency: models.RepaymentMonthly, Installments: 3, StartDate: "2026-01-01",
+				Rounding: models.Rounding{Decimals: &zero, Mode: models.RoundHalfEven}},
+			want: []Installment{
+				{1, "2026-02-01", 341, 333, 8, 0, 667, false},
+				{2, "2026-03-01", 339, 333, 6, 0, 334, false},
+				{3, "2026-04-01", 337, 334, 3, 0, 0, false},
+			},
+			totals: Totals{Installments: 3, Principal: 1000, Interest: 17, Payment: 1017},
+		},
+		{
+			// Rounded line by line, installments 2 to 7 would charge no
+			// interest at all
+			name: "whole amounts rounded down carry interest forward",
+			req: models.ScheduleRequest{Principal: 100, InterestRate: 12, InterestMethod: models.InterestAnnuity,
+				RepaymentFrequency: models.RepaymentMonthly, Installments: 7, StartDate: "2026-01-01",
+				Rounding: models.Rounding{Decimals: &zero, Mode: models.RoundDown}},
+			want: []Installment{
+				{1, "2026-02-01", 14, 13, 1, 0, 87, false},
+				{2, "2026-03-01", 14, 14, 0, 0, 73, false},
+				{3, "2026-04-01", 14, 13, 1, 0, 60, false},
+				{4, "2026-05-01", 14, 13, 1, 0, 47, false},
+				{5, "2026-06-01", 14, 14, 0, 0, 33, false},
+				{6, "2026-07-01", 14, 13, 1, 0, 20, false},
+				{7, "2026-08-01", 20, 20, 0, 0, 0, false},
+			},
+			totals: Totals{Installments: 7, Principal: 100, Interest: 4, Payment: 104},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Generate(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(schedule.Installments, tt.want) {
+				t.Errorf("installments =\n%v\nwant\n%v", schedule.Installments, tt.want)
+			}
+			if schedule.Totals != tt.totals {
+				t.Errorf("totals = %+v, want %+v", schedule.Totals, tt.totals)
+			}
+		})
+	}
+}
+
+func TestGenerateRejectsBadRequests(t *testing.T) {
+	valid := models.ScheduleRequest{Principal: 1000, InterestRate: 12, InterestMethod: models.InterestAnnuity,
+		RepaymentFrequency: models.RepaymentMonthly, Installments: 12, StartDate: "2026-01-01"}
+	tests := map[string]func(*models.ScheduleRequest){
+		"no principal":          func(r *models.ScheduleRequest) { r.Principal = 0 },
+		"negative rate":         func(r *models.ScheduleRequest) { r.InterestRate = -1 },
+		"unknown method":        func(r *models.ScheduleRequest) { r.InterestMethod = "compound" },
+		"unknown frequency":     func(r *models.ScheduleRequest) { r.RepaymentFrequency = "daily" },
+		"no installments":       func(r *models.ScheduleRequest) { r.Installments = 0 },
+		"grace to the end":      func(r *models.ScheduleRequest) { r.GracePeriods = 12 },
+		"unknown grace type":    func(r *models.ScheduleRequest) { r.GracePeriods, r.GraceType = 1, "partial" },
+		"balloon of everything": func(r *models.ScheduleRequest) { r.BalloonAmount = 1000 },
+		"bad start date":        func(r *models.ScheduleRequest) { r.StartDate = "01/01/2026" },
+		"bad rounding":          func(r *models.ScheduleRequest) { r.Rounding.Mode = "bankers" },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := valid
+			change(&req)
+			if _, err := Generate(req); err == nil {
+				t.Fatal("Generate succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestAnnuity(t *testing.T) {
+	tests := []struct {
+		name                     string
+		principal, balloon, rate float64
+		n                        int
+		want                     float64
+	}{
+		{"no interest", 1200, 0, 0, 12, 100},
+		{"no interest with a balloon", 1200, 600, 0, 12, 50},
+		{"one percent a period", 1000, 0, 0.01, 3, 340.0221},
+		{"one percent a period with a balloon", 1000, 400, 0.01, 3, 208.0133},
+		{"a single period", 1000, 0, 0.05, 1, 1050},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := annuity(tt.principal, tt.balloon, tt.rate, tt.n)
+			if math.Abs(got-tt.want) > 0.0001 {
+				t.Fatalf("annuity = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDueDate(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     string
+		frequency models.RepaymentFrequency
+		want      []string
+	}{
+		{"monthly from the 31st", "2026-01-31", models.RepaymentMonthly,
+			[]string{"2026-02-28", "2026-03-31", "2026-04-30", "2026-05-31"}},
+		{"monthly from the 31st in a leap year", "2028-01-31", models.RepaymentMonthly,
+			[]string{"2028-02-29", "2028-03-31", "2028-04-30", "2028-05-31"}},
+		{"monthly from the 30th", "2026-01-30", models.RepaymentMonthly,
+			[]string{"2026-02-28", "2026-03-30", "2026-04-30", "2026-05-30"}},
+		{"monthly across the year end", "2026-11-30", models.RepaymentMonthly,
+			[]string{"2026-12-30", "2027-01-30", "2027-02-28", "2027-03-30"}},
+		{"weekly", "2026-01-31", models.RepaymentWeekly,
+			[]string{"2026-02-07", "2026-02-14", "2026-02-21", "2026-02-28"}},
+		{"biweekly", "2026-01-31", models.RepaymentBiweekly,
+			[]string{"2026-02-14", "2026-02-28", "2026-03-14", "2026-03-28"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, err := time.Parse(dateLayout, tt.start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range tt.want {
+				if got := dueDate(start, tt.frequency, i+1).Format(dateLayout); got != want {
+					t.Errorf("installment %d is due %s, want %s", i+1, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"loan/config"
+	"loan/jobs"
 	"loan/middleware"
 	"loan/routes"
+	"loan/scheduler"
 	"loan/utils"
 	"os"
 
@@ -21,6 +24,13 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
+	// Start background jobs
+	sched := scheduler.New(cfg.MongoDB)
+	if err := jobs.Register(sched, cfg); err != nil {
+		utils.Fatal("Failed to register jobs: " + err.Error())
+	}
+	sched.Start(context.Background())
+
 	// Setup Gin
 	router := gin.Default()
 
@@ -32,7 +42,7 @@ func main() {
 	router.Use(middleware.RateLimitMiddleware(limiter))
 
 	// Setup routes
-	routes.SetupRoutes(router, cfg)
+	routes.SetupRoutes(router, cfg, sched)
 
 	// Start server
 	utils.Info("Starting server on :8080")
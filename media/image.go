@@ -0,0 +1,144 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrInvalidImage wraps every error caused by the upload itself rather than
+// by the server, so callers can answer with a 400
+var ErrInvalidImage = errors.New("invalid image")
+
+// Variant is one resized copy of an upload. Square variants are centre
+// cropped; the rest keep their aspect ratio and fit within Size.
+type Variant struct {
+	Name   string
+	Size   int
+	Square bool
+}
+
+type Limits struct {
+	MaxBytes     int64
+	MinDimension int
+	MaxDimension int
+}
+
+// Image is an encoded variant ready to store
+type Image struct {
+	Variant     string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// Formats accepted on upload. WebP has no encoder in the standard library
+// so it is stored as PNG.
+var outputFormats = map[string]string{
+	"image/jpeg": "image/jpeg",
+	"image/png":  "image/png",
+	"image/webp": "image/png",
+}
+
+// Process validates an uploaded image and renders each variant. Images are
+// decoded and re-encoded from pixels, which drops EXIF and any other
+// metadata; JPEG orientation is applied first so photos stay upright.
+func Process(r io.Reader, limits Limits, variants []Variant) ([]Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limits.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limits.MaxBytes {
+		return nil, fmt.Errorf("%w: file is larger than %d bytes", ErrInvalidImage, limits.MaxBytes)
+	}
+
+	contentType := strings.Split(http.DetectContentType(data), ";")[0]
+	outputType, ok := outputFormats[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported file type %s", ErrInvalidImage, contentType)
+	}
+
+	// Check dimensions before decoding so a small file cannot expand into
+	// a huge bitmap
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if cfg.Width < limits.MinDimension || cfg.Height < limits.MinDimension {
+		return nil, fmt.Errorf("%w: image must be at least %dx%d pixels", ErrInvalidImage, limits.MinDimension, limits.MinDimension)
+	}
+	if cfg.Width > limits.MaxDimension || cfg.Height > limits.MaxDimension {
+		return nil, fmt.Errorf("%w: image must be at most %dx%d pixels", ErrInvalidImage, limits.MaxDimension, limits.MaxDimension)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if contentType == "image/jpeg" {
+		src = orient(src, jpegOrientation(data))
+	}
+
+	images := make([]Image, 0, len(variants))
+	for _, v := range variants {
+		resized := resize(src, v)
+
+		var buf bytes.Buffer
+		if outputType == "image/jpeg" {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, Image{
+			Variant:     v.Name,
+			ContentType: outputType,
+			Width:       resized.Bounds().Dx(),
+			Height:      resized.Bounds().Dy(),
+			Data:        buf.Bytes(),
+		})
+	}
+
+	return images, nil
+}
+
+// resize scales src down to the variant size. Images smaller than the
+// variant are never scaled up.
+func resize(src image.Image, v Variant) image.Image {
+	srcRect := src.Bounds()
+	w, h := srcRect.Dx(), srcRect.Dy()
+
+	if v.Square {
+		side := min(w, h)
+		x := srcRect.Min.X + (w-side)/2
+		y := srcRect.Min.Y + (h-side)/2
+		srcRect = image.Rect(x, y, x+side, y+side)
+		w, h = side, side
+	}
+
+	dw, dh := w, h
+	if w > v.Size || h > v.Size {
+		if w >= h {
+			dw, dh = v.Size, max(1, h*v.Size/w)
+		} else {
+			dw, dh = max(1, w*v.Size/h), v.Size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Src, nil)
+	return dst
+}
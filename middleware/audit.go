@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"loan/models"
+	"loan/utils"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	auditBatchSize     = 100
+	auditFlushInterval = time.Second
+)
+
+// AuditLogger buffers audit entries and writes them to Mongo in the
+// background. When the buffer is full new entries are dropped rather than
+// slowing down the request path.
+type AuditLogger struct {
+	collection *mongo.Collection
+	entries    chan models.AuditLog
+	dropped    uint64
+}
+
+func NewAuditLogger(collection *mongo.Collection, bufferSize int) *AuditLogger {
+	a := &AuditLogger{
+		collection: collection,
+		entries:    make(chan models.AuditLog, bufferSize),
+	}
+	go a.run()
+	return a
+}
+
+// Log queues an entry without blocking
+func (a *AuditLogger) Log(entry models.AuditLog) {
+	select {
+	case a.entries <- entry:
+	default:
+		dropped := atomic.AddUint64(&a.dropped, 1)
+		utils.Warn("Audit buffer full, dropping entry", utils.Fields(map[string]interface{}{
+			"route":   entry.Route,
+			"dropped": dropped,
+		}))
+	}
+}
+
+func (a *AuditLogger) run() {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]interface{}, 0, auditBatchSize)
+	for {
+		select {
+		case entry := <-a.entries:
+			batch = append(batch, entry)
+			if len(batch) >= auditBatchSize {
+				batch = a.flush(batch)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				batch = a.flush(batch)
+			}
+		}
+	}
+}
+
+func (a *AuditLogger) flush(batch []interface{}) []interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := a.collection.InsertMany(ctx, batch); err != nil {
+		utils.Error("Error writing audit logs: "+err.Error(), utils.Fields(map[string]interface{}{
+			"count": len(batch),
+		}))
+	}
+	return batch[:0]
+}
+
+// AuditMiddleware records every state-changing request after it completes
+func AuditMiddleware(a *AuditLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isStateChanging(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		// Start timer
+		start := time.Now()
+
+		// Process request
+		c.Next()
+
+		params := make(map[string]string, len(c.Params))
+		resourceIDs := make([]string, 0, len(c.Params))
+		for _, p := range c.Params {
+			params[p.Key] = p.Value
+			resourceIDs = append(resourceIDs, p.Value)
+		}
+		resourceIDs = append(resourceIDs, utils.AuditResources(c)...)
+
+		var roles []models.Role
+		if r, ok := c.Get("roles"); ok {
+			roles, _ = r.([]models.Role)
+		}
+
+		a.Log(models.AuditLog{
+			ID:          primitive.NewObjectID().Hex(),
+			ActorID:     c.GetString("user_id"),
+			Roles:       roles,
+			Method:      c.Request.Method,
+			Route:       c.FullPath(),
+			Path:        c.Request.URL.Path,
+			Params:      params,
+			ResourceIDs: resourceIDs,
+			Status:      c.Writer.Status(),
+			ClientIP:    c.ClientIP(),
+			UserAgent:   c.Request.UserAgent(),
+			LatencyMs:   time.Since(start).Milliseconds(),
+			CreatedAt:   start,
+		})
+	}
+}
+
+func isStateChanging(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -2,10 +2,13 @@ package middleware
 
 import (
 	"loan/config"
+	"loan/models"
 	"loan/utils"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func AuthMiddleware() gin.HandlerFunc {
@@ -31,9 +34,118 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Set both user ID and roles in context
+		// Set user ID, roles and the company they apply in in context
 		c.Set("user_id", claims.UserID)
 		c.Set("roles", claims.Roles)
+		c.Set("company_id", claims.CompanyID)
 		c.Next()
 	}
 }
+
+// RequireRoles only lets through users holding at least one of the given roles
+func RequireRoles(roles ...models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userRoles, _ := c.Get("roles")
+		granted, _ := userRoles.([]models.Role)
+
+		for _, have := range granted {
+			for _, want := range roles {
+				if have == want {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		utils.Forbidden(c, "Insufficient permissions")
+		c.Abort()
+	}
+}
+
+// RequireBranchRoles only lets through users holding one of the given roles
+// at the branch office in the :branch_id route parameter, which must belong
+// to the company in :id. Super users are let through at every branch, and
+// admins at every branch of their company and its subsidiaries.
+func RequireBranchRoles(db *mongo.Database, roles ...models.BranchRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		companyID := c.Param("id")
+		branchID := c.Param("branch_id")
+
+		count, err := db.Collection("branch_offices").CountDocuments(c, bson.M{
+			"_id":        branchID,
+			"company_id": companyID,
+		})
+		if err != nil {
+			utils.InternalError(c, "Error checking permissions")
+			c.Abort()
+			return
+		}
+		if count == 0 {
+			utils.BadRequest(c, "Branch office not found")
+			c.Abort()
+			return
+		}
+
+		if utils.HasRole(c, models.RoleSuperUser) {
+			c.Next()
+			return
+		}
+		if utils.HasRole(c, models.RoleAdmin) {
+			ok, err := adminOf(c, db, companyID)
+			if err != nil {
+				utils.InternalError(c, "Error checking permissions")
+				c.Abort()
+				return
+			}
+			if ok {
+				c.Next()
+				return
+			}
+		}
+
+		ok, err := utils.UserHasBranchRole(c, db, c.GetString("user_id"), branchID, roles...)
+		if err != nil {
+			utils.InternalError(c, "Error checking permissions")
+			c.Abort()
+			return
+		}
+		if !ok {
+			utils.Forbidden(c, "Insufficient permissions at this branch office")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminOf reports whether companyID is in the group of the company an admin
+// administers: the company their token is scoped to, or else their home
+// company
+func adminOf(c *gin.Context, db *mongo.Database, companyID string) (bool, error) {
+	adminCompanyID := c.GetString("company_id")
+	if adminCompanyID == "" {
+		var user models.User
+		err := db.Collection("users").FindOne(c, bson.M{"_id": c.GetString("user_id")}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		adminCompanyID = user.CompanyID
+	}
+	if adminCompanyID == "" {
+		return false, nil
+	}
+
+	groupIDs, err := utils.CompanyGroupIDs(c, db, adminCompanyID)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range groupIDs {
+		if id == companyID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,77 @@
+package migrations
+
+import (
+	"context"
+	"loan/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// assignmentPeriods turns staff_assignments into the store of assignment
+// periods. The rows it held were a write-only log of assignment requests;
+// they are moved to staff_assignment_log rather than thrown away. Every
+// branch role users hold now becomes an active period starting when it was
+// assigned, so timelines begin from the current state.
+func assignmentPeriods(ctx context.Context, db *mongo.Database, env Env) error {
+	assignments := db.Collection("staff_assignments")
+	legacy := bson.M{"status": bson.M{"$exists": false}}
+
+	cursor, err := assignments.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: legacy}},
+		{{Key: "$merge", Value: bson.M{"into": "staff_assignment_log"}}},
+	})
+	if err != nil {
+		return err
+	}
+	cursor.Close(ctx)
+	if _, err := assignments.DeleteMany(ctx, legacy); err != nil {
+		return err
+	}
+
+	cursor, err = db.Collection("users").Find(ctx, bson.M{
+		"roles":          models.RoleStaff,
+		"branch_roles.0": bson.M{"$exists": true},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var periods []interface{}
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		for _, assignment := range user.BranchRoles {
+			periods = append(periods, models.AssignmentPeriod{
+				ID:            primitive.NewObjectID().Hex(),
+				UserID:        user.ID,
+				CompanyID:     user.CompanyID,
+				BranchID:      assignment.BranchID,
+				Role:          assignment.Role,
+				EffectiveFrom: assignment.AssignedAt,
+				Status:        models.AssignmentActive,
+				AssignedBy:    assignment.AssignedBy,
+				CreatedAt:     time.Now(),
+				UpdatedAt:     time.Now(),
+			})
+		}
+		if len(periods) >= 500 {
+			if _, err := assignments.InsertMany(ctx, periods); err != nil {
+				return err
+			}
+			periods = periods[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(periods) > 0 {
+		_, err = assignments.InsertMany(ctx, periods)
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"context"
+	"loan/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// branchRoles replaces each user's branch_offices list of IDs with
+// branch_roles. Nobody's responsibilities at a branch were recorded before,
+// so every existing membership becomes a teller role, the least privileged
+// one; managers have to be promoted by hand. The index over the old field
+// is dropped so EnsureIndexes can create its replacement.
+func branchRoles(ctx context.Context, db *mongo.Database, env Env) error {
+	users := db.Collection("users")
+
+	_, err := users.UpdateMany(ctx,
+		bson.M{"branch_offices": bson.M{"$exists": true}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"branch_roles": bson.M{"$map": bson.M{
+					"input": bson.M{"$ifNull": bson.A{"$branch_offices", bson.A{}}},
+					"as":    "branch_id",
+					"in": bson.M{
+						"branch_id":   "$$branch_id",
+						"role":        models.BranchRoleTeller,
+						"assigned_at": "$updated_at",
+						"assigned_by": "",
+					},
+				}},
+			}}},
+			{{Key: "$unset", Value: "branch_offices"}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Missing if the database was created after the new index, which is fine
+	users.Indexes().DropOne(ctx, "company_id_1_branch_offices_1_created_at_-1__id_-1")
+	return nil
+}
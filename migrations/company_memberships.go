@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"context"
+	"loan/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// companyMemberships gives users a memberships list. Users with a company
+// become members of it with the roles they have now, less super user,
+// which is not tied to a company.
+func companyMemberships(ctx context.Context, db *mongo.Database, env Env) error {
+	users := db.Collection("users")
+
+	_, err := users.UpdateMany(ctx,
+		bson.M{
+			"memberships": bson.M{"$exists": false},
+			"company_id":  bson.M{"$nin": bson.A{"", nil}},
+		},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"memberships": bson.A{bson.M{
+					"company_id": "$company_id",
+					"roles": bson.M{"$filter": bson.M{
+						"input": bson.M{"$ifNull": bson.A{"$roles", bson.A{}}},
+						"cond":  bson.M{"$ne": bson.A{"$$this", models.RoleSuperUser}},
+					}},
+					"joined_at": "$created_at",
+					"added_by":  "",
+				}},
+			}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = users.UpdateMany(ctx,
+		bson.M{"memberships": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"memberships": bson.A{}}},
+	)
+	return err
+}
@@ -0,0 +1,151 @@
+package migrations
+
+import (
+	"context"
+	"loan/utils"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const migrationBatchSize = 500
+
+// structuredCompanyProfile brings companies created before the structured
+// profile in line with its validation:
+//
+//   - trading_name, type and registration_number are added empty
+//   - country is set to the configured default where missing
+//   - tax_id is normalized
+//   - business_type is matched case-insensitively against the configured
+//     list; values that do not match become "other" and the original is
+//     kept in legacy_business_type
+//
+// Invalid tax IDs and duplicate tax IDs cannot be fixed automatically.
+// They are logged for someone to resolve; the unique tax ID index is not
+// created until the duplicates are gone.
+func structuredCompanyProfile(ctx context.Context, db *mongo.Database, env Env) error {
+	collection := db.Collection("companies")
+
+	allowed := make(map[string]string, len(env.BusinessTypes))
+	for _, t := range env.BusinessTypes {
+		allowed[strings.ToLower(t)] = t
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := collection.BulkWrite(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var company bson.M
+		if err := cursor.Decode(&company); err != nil {
+			return err
+		}
+		set := bson.M{}
+
+		for _, field := range []string{"trading_name", "type", "registration_number"} {
+			if _, ok := company[field]; !ok {
+				set[field] = ""
+			}
+		}
+
+		country, _ := company["country"].(string)
+		if country == "" {
+			country = env.DefaultCountry
+			set["country"] = country
+		}
+
+		taxID, _ := company["tax_id"].(string)
+		if normalized := utils.NormalizeTaxID(taxID); normalized != taxID {
+			set["tax_id"] = normalized
+		}
+		if err := utils.ValidateTaxID(country, taxID); err != nil {
+			utils.Warn("Company has an invalid tax ID", utils.Fields(map[string]interface{}{
+				"company_id": company["_id"],
+				"error":      err.Error(),
+			}))
+		}
+
+		businessType, _ := company["business_type"].(string)
+		key := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(businessType)), " ", "_")
+		if match, ok := allowed[key]; ok {
+			if match != businessType {
+				set["business_type"] = match
+			}
+		} else if other, ok := allowed["other"]; ok {
+			set["business_type"] = other
+			set["legacy_business_type"] = businessType
+		} else {
+			utils.Warn("Company has an unknown business type", utils.Fields(map[string]interface{}{
+				"company_id":    company["_id"],
+				"business_type": businessType,
+			}))
+		}
+
+		if len(set) > 0 {
+			batch = append(batch, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": company["_id"]}).
+				SetUpdate(bson.M{"$set": set}))
+		}
+		if len(batch) >= migrationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return reportDuplicateTaxIDs(ctx, collection)
+}
+
+func reportDuplicateTaxIDs(ctx context.Context, collection *mongo.Collection) error {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"tax_id": bson.M{"$gt": ""}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         bson.M{"country": "$country", "tax_id": "$tax_id"},
+			"company_ids": bson.M{"$push": "$_id"},
+			"count":       bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var group struct {
+			Key struct {
+				Country string `bson:"country"`
+				TaxID   string `bson:"tax_id"`
+			} `bson:"_id"`
+			CompanyIDs []string `bson:"company_ids"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+		utils.Warn("Companies share a tax ID", utils.Fields(map[string]interface{}{
+			"country":     group.Key.Country,
+			"tax_id":      group.Key.TaxID,
+			"company_ids": group.CompanyIDs,
+		}))
+	}
+	return cursor.Err()
+}
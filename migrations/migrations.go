@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"loan/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Env carries the configuration migrations need to fill in new fields
+type Env struct {
+	BusinessTypes  []string
+	DefaultCountry string
+}
+
+// Migration is a one-off change to stored data. Migrations run in order at
+// startup and each runs once per database.
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database, env Env) error
+}
+
+// migrations lists every migration in the order it must run. Append only;
+// never rename or reorder an entry that has shipped.
+var migrations = []Migration{
+	{
+		ID:          "20261019_structured_company_profile",
+		Description: "Add company type, registration and country; normalize tax IDs and business types",
+		Up:          structuredCompanyProfile,
+	},
+	{
+		ID:          "20261019_branch_roles",
+		Description: "Replace users' branch_offices with per-branch roles",
+		Up:          branchRoles,
+	},
+	{
+		ID:          "20261019_staff_assignment_periods",
+		Description: "Store effective-dated staff assignment periods; move old assignment rows to staff_assignment_log",
+		Up:          assignmentPeriods,
+	},
+	{
+		ID:          "20261019_company_memberships",
+		Description: "Give each user a membership of their home company with their current roles",
+		Up:          companyMemberships,
+	},
+}
+
+type record struct {
+	ID          string     `bson:"_id"`
+	Description string     `bson:"description"`
+	Status      string     `bson:"status"`
+	StartedAt   time.Time  `bson:"started_at"`
+	FinishedAt  *time.Time `bson:"finished_at,omitempty"`
+}
+
+// Run applies pending migrations. Each is claimed by inserting its record
+// first, so when several replicas start together only one of them runs it.
+// A failed migration's record is removed so the next start retries it.
+func Run(ctx context.Context, db *mongo.Database, env Env) error {
+	collection := db.Collection("migrations")
+
+	for _, m := range migrations {
+		_, err := collection.InsertOne(ctx, record{
+			ID:          m.ID,
+			Description: m.Description,
+			Status:      "running",
+			StartedAt:   time.Now(),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		utils.Info("Running migration " + m.ID)
+		if err := m.Up(ctx, db, env); err != nil {
+			collection.DeleteOne(ctx, bson.M{"_id": m.ID})
+			return fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": m.ID}, bson.M{"$set": bson.M{
+			"status":      "done",
+			"finished_at": time.Now(),
+		}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+)
+
+type AssignmentStatus string
+
+const (
+	AssignmentScheduled AssignmentStatus = "scheduled" // Starts in the future
+	AssignmentActive    AssignmentStatus = "active"
+	AssignmentEnded     AssignmentStatus = "ended"
+	AssignmentCancelled AssignmentStatus = "cancelled" // Withdrawn before it started
+)
+
+// AssignmentPeriod is a stretch of time during which a staff member holds
+// a role at a branch office. Together a user's periods make up their
+// assignment timeline. A period without EffectiveTo runs until someone
+// changes the role; one with EffectiveTo is temporary cover and is ended by
+// the scheduler, which then gives back the role held before it.
+type AssignmentPeriod struct {
+	ID            string           `bson:"_id,omitempty" json:"id"`
+	UserID        string           `bson:"user_id" json:"user_id"`
+	CompanyID     string           `bson:"company_id" json:"company_id"`
+	BranchID      string           `bson:"branch_id" json:"branch_id"`
+	Role          BranchRole       `bson:"role" json:"role"`
+	EffectiveFrom time.Time        `bson:"effective_from" json:"effective_from"`
+	EffectiveTo   *time.Time       `bson:"effective_to,omitempty" json:"effective_to,omitempty"`
+	Status        AssignmentStatus `bson:"status" json:"status"`
+	PreviousRole  BranchRole       `bson:"previous_role,omitempty" json:"previous_role,omitempty"`
+	AssignedBy    string           `bson:"assigned_by" json:"assigned_by"`
+	EndedBy       string           `bson:"ended_by,omitempty" json:"ended_by,omitempty"`
+	CreatedAt     time.Time        `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time        `bson:"updated_at" json:"updated_at"`
+}
+
+// BranchStaffPeriod is an assignment period with the name of the staff
+// member holding it
+type BranchStaffPeriod struct {
+	AssignmentPeriod `bson:",inline"`
+	Username         string `bson:"username" json:"username"`
+	FullName         string `bson:"full_name" json:"full_name"`
+}
+
+// ScheduleAssignmentRequest gives a staff member a role at a branch office
+// from one time, and until another when effective_to is set
+type ScheduleAssignmentRequest struct {
+	UserID        string     `json:"user_id" binding:"required"`
+	Role          BranchRole `json:"role" binding:"required,branch_role"`
+	EffectiveFrom time.Time  `json:"effective_from" binding:"required"`
+	EffectiveTo   *time.Time `json:"effective_to"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog records one state-changing API call
+type AuditLog struct {
+	ID          string            `bson:"_id,omitempty" json:"id"`
+	ActorID     string            `bson:"actor_id" json:"actor_id"`
+	Roles       []Role            `bson:"roles" json:"roles"`
+	Method      string            `bson:"method" json:"method"`
+	Route       string            `bson:"route" json:"route"`
+	Path        string            `bson:"path" json:"path"`
+	Params      map[string]string `bson:"params" json:"params"`
+	ResourceIDs []string          `bson:"resource_ids" json:"resource_ids"`
+	Status      int               `bson:"status" json:"status"`
+	ClientIP    string            `bson:"client_ip" json:"client_ip"`
+	UserAgent   string            `bson:"user_agent" json:"user_agent"`
+	LatencyMs   int64             `bson:"latency_ms" json:"latency_ms"`
+	CreatedAt   time.Time         `bson:"created_at" json:"created_at"`
+}
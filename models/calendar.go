@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+// OpeningHours is a branch office's weekly schedule. Times are wall-clock
+// times in Timezone, an IANA name such as "Asia/Phnom_Penh".
+type OpeningHours struct {
+	Timezone string          `bson:"timezone" json:"timezone" binding:"required"`
+	Weekly   []OpeningPeriod `bson:"weekly" json:"weekly" binding:"dive"`
+}
+
+// OpeningPeriod is one opening on a day of the week. A day may have several,
+// for example to close over lunch. Open and Close are "15:04"; Close may be
+// "24:00" and must be after Open.
+type OpeningPeriod struct {
+	Day   string `bson:"day" json:"day" binding:"required,oneof=monday tuesday wednesday thursday friday saturday sunday"`
+	Open  string `bson:"open" json:"open" binding:"required"`
+	Close string `bson:"close" json:"close" binding:"required"`
+}
+
+type HolidaySource string
+
+const (
+	HolidayManual HolidaySource = "manual"
+	HolidayICS    HolidaySource = "ics"
+)
+
+// Holiday closes a company's branches, or a single branch when BranchID is
+// set, for a whole day. Date is "2006-01-02" in the branch's timezone.
+type Holiday struct {
+	ID        string        `bson:"_id,omitempty" json:"id"`
+	CompanyID string        `bson:"company_id" json:"company_id"`
+	BranchID  string        `bson:"branch_id" json:"branch_id"`
+	Date      string        `bson:"date" json:"date"`
+	Name      string        `bson:"name" json:"name"`
+	Source    HolidaySource `bson:"source" json:"source"`
+	UID       string        `bson:"uid,omitempty" json:"uid,omitempty"` // iCalendar UID of an imported event
+	CreatedBy string        `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+}
+
+type CreateHolidayRequest struct {
+	Date string `json:"date" binding:"required,datetime=2006-01-02"`
+	Name string `json:"name" binding:"required"`
+}
+
+// HolidayImportResult summarizes an iCalendar import
+type HolidayImportResult struct {
+	Events  int `json:"events"`
+	Dates   int `json:"dates"`
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+type EntityType string
+
+const (
+	EntityCompany      EntityType = "company"
+	EntityBranchOffice EntityType = "branch_office"
+	EntityUser         EntityType = "user"
+	EntityDocument     EntityType = "company_document"
+	EntityCustomer     EntityType = "customer"
+	EntityLoanProduct  EntityType = "loan_product"
+	EntityLoan         EntityType = "loan"
+	EntityLoanAccount  EntityType = "loan_account"
+)
+
+type ChangeAction string
+
+const (
+	ChangeActionCreate ChangeAction = "create"
+	ChangeActionUpdate ChangeAction = "update"
+	ChangeActionDelete ChangeAction = "delete"
+)
+
+// ChangeLog is an immutable record of a single create, update or delete
+type ChangeLog struct {
+	ID         string        `bson:"_id,omitempty" json:"id"`
+	EntityType EntityType    `bson:"entity_type" json:"entity_type"`
+	EntityID   string        `bson:"entity_id" json:"entity_id"`
+	Action     ChangeAction  `bson:"action" json:"action"`
+	Changes    []FieldChange `bson:"changes" json:"changes"`
+	ActorID    string        `bson:"actor_id" json:"actor_id"`
+	ClientIP   string        `bson:"client_ip" json:"client_ip"`
+	CreatedAt  time.Time     `bson:"created_at" json:"created_at"`
+}
+
+// FieldChange holds the before and after value of a single field
+type FieldChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before" json:"before"`
+	After  interface{} `bson:"after" json:"after"`
+}
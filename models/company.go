@@ -12,32 +12,62 @@ const (
 	CompanyTypePartnership CompanyType = "partnership"
 )
 
+func (t CompanyType) Valid() bool {
+	switch t {
+	case CompanyTypeCorporation, CompanyTypeLLC, CompanyTypePartnership:
+		return true
+	}
+	return false
+}
+
+// Company is a lender. TaxID is stored normalized (see
+// utils.NormalizeTaxID) and is unique within its country. A company in a
+// holding group points at its parent; the group's top company has none.
 type Company struct {
-	ID           string    `bson:"_id,omitempty" json:"id"`
-	Name         string    `bson:"name" json:"name" binding:"required"`
-	Address      string    `bson:"address" json:"address" binding:"required"`
-	Phone        string    `bson:"phone" json:"phone" binding:"required"`
-	Email        string    `bson:"email" json:"email" binding:"required,email"`
-	Website      string    `bson:"website" json:"website"`
-	TaxID        string    `bson:"tax_id" json:"tax_id" binding:"required"`
-	BusinessType string    `bson:"business_type" json:"business_type" binding:"required"`
-	CreatedBy    string    `bson:"created_by" json:"created_by"`
-	UpdatedBy    string    `bson:"updated_by" json:"updated_by"`
-	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt    time.Time `bson:"updated_at" json:"updated_at"`
+	ID                 string      `bson:"_id,omitempty" json:"id"`
+	ParentCompanyID    string      `bson:"parent_company_id" json:"parent_company_id"`
+	Name               string      `bson:"name" json:"name" binding:"required"`
+	TradingName        string      `bson:"trading_name" json:"trading_name"`
+	Type               CompanyType `bson:"type" json:"type"`
+	RegistrationNumber string      `bson:"registration_number" json:"registration_number"`
+	Country            string      `bson:"country" json:"country"` // ISO 3166-1 alpha-2
+	Address            string      `bson:"address" json:"address" binding:"required"`
+	Phone              string      `bson:"phone" json:"phone" binding:"required"`
+	Email              string      `bson:"email" json:"email" binding:"required,email"`
+	Website            string      `bson:"website" json:"website"`
+	TaxID              string      `bson:"tax_id" json:"tax_id" binding:"required"`
+	BusinessType       string      `bson:"business_type" json:"business_type" binding:"required"`
+	LogoURL            string      `bson:"logo_url,omitempty" json:"logo_url,omitempty"` // Set by uploading a logo
+	CreatedBy          string      `bson:"created_by" json:"created_by"`
+	UpdatedBy          string      `bson:"updated_by" json:"updated_by"`
+	CreatedAt          time.Time   `bson:"created_at" json:"created_at"`
+	UpdatedAt          time.Time   `bson:"updated_at" json:"updated_at"`
 }
 
+// BranchOffice is a lender's branch. Address is the one-line address used
+// for display and search; when only PostalAddress is given it is filled in
+// from it. Location is optional and backs the nearby and map queries.
 type BranchOffice struct {
-	ID        string    `bson:"_id,omitempty" json:"id"`
-	CompanyID string    `bson:"company_id" json:"company_id"`
-	Name      string    `bson:"name" json:"name" binding:"required"`
-	Address   string    `bson:"address" json:"address" binding:"required"`
-	Phone     string    `bson:"phone" json:"phone" binding:"required"`
-	Email     string    `bson:"email" json:"email" binding:"required,email"`
-	CreatedBy string    `bson:"created_by" json:"created_by"`
-	UpdatedBy string    `bson:"updated_by" json:"updated_by"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	ID            string         `bson:"_id,omitempty" json:"id"`
+	CompanyID     string         `bson:"company_id" json:"company_id"`
+	Name          string         `bson:"name" json:"name" binding:"required"`
+	Address       string         `bson:"address" json:"address" binding:"required_without=PostalAddress"`
+	PostalAddress *PostalAddress `bson:"postal_address,omitempty" json:"postal_address,omitempty"`
+	Location      *GeoPoint      `bson:"location,omitempty" json:"location,omitempty"`
+	OpeningHours  *OpeningHours  `bson:"opening_hours,omitempty" json:"opening_hours,omitempty"`
+	Phone         string         `bson:"phone" json:"phone" binding:"required"`
+	Email         string         `bson:"email" json:"email" binding:"required,email"`
+	CreatedBy     string         `bson:"created_by" json:"created_by"`
+	UpdatedBy     string         `bson:"updated_by" json:"updated_by"`
+	CreatedAt     time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time      `bson:"updated_at" json:"updated_at"`
+}
+
+// CompanyNode is a company found by walking a group hierarchy. Depth is
+// the number of levels from the company the walk started at.
+type CompanyNode struct {
+	Company `bson:",inline"`
+	Depth   int `bson:"depth" json:"depth"`
 }
 
 type CompanyInfo struct {
@@ -80,21 +110,74 @@ type CompanyUpdate struct {
 }
 
 type CreateCompanyRequest struct {
-	Name         string `json:"name" binding:"required"`
-	Address      string `json:"address" binding:"required"`
-	Phone        string `json:"phone" binding:"required"`
-	Email        string `json:"email" binding:"required,email"`
-	Website      string `json:"website"`
-	TaxID        string `json:"tax_id" binding:"required"`
-	BusinessType string `json:"business_type" binding:"required"`
+	ParentCompanyID    string      `json:"parent_company_id"`
+	Name               string      `json:"name" binding:"required"`
+	TradingName        string      `json:"trading_name"`
+	Type               CompanyType `json:"type" binding:"omitempty,company_type"`
+	RegistrationNumber string      `json:"registration_number"`
+	Country            string      `json:"country" binding:"required,iso3166_1_alpha2"`
+	Address            string      `json:"address" binding:"required"`
+	Phone              string      `json:"phone" binding:"required"`
+	Email              string      `json:"email" binding:"required,email"`
+	Website            string      `json:"website"`
+	TaxID              string      `json:"tax_id" binding:"required"`
+	BusinessType       string      `json:"business_type" binding:"required,business_type"`
 }
 
 type UpdateCompanyRequest struct {
-	Name         string `json:"name"`
-	Address      string `json:"address"`
-	Phone        string `json:"phone"`
-	Email        string `json:"email" binding:"omitempty,email"`
-	Website      string `json:"website"`
-	TaxID        string `json:"tax_id"`
-	BusinessType string `json:"business_type"`
+	ParentCompanyID    string      `json:"parent_company_id"`
+	Name               string      `json:"name"`
+	TradingName        string      `json:"trading_name"`
+	Type               CompanyType `json:"type" binding:"omitempty,company_type"`
+	RegistrationNumber string      `json:"registration_number"`
+	Country            string      `json:"country" binding:"omitempty,iso3166_1_alpha2"`
+	Address            string      `json:"address"`
+	Phone              string      `json:"phone"`
+	Email              string      `json:"email" binding:"omitempty,email"`
+	Website            string      `json:"website"`
+	TaxID              string      `json:"tax_id"`
+	BusinessType       string      `json:"business_type" binding:"omitempty,business_type"`
+}
+
+// CompanyPatch is the editable part of a company. PATCH requests are
+// applied to it and the result must pass these rules before it is saved.
+type CompanyPatch struct {
+	ParentCompanyID    string      `json:"parent_company_id"`
+	Name               string      `json:"name" binding:"required"`
+	TradingName        string      `json:"trading_name"`
+	Type               CompanyType `json:"type" binding:"omitempty,company_type"`
+	RegistrationNumber string      `json:"registration_number"`
+	Country            string      `json:"country" binding:"required,iso3166_1_alpha2"`
+	Address            string      `json:"address" binding:"required"`
+	Phone              string      `json:"phone" binding:"required"`
+	Email              string      `json:"email" binding:"required,email"`
+	Website            string      `json:"website"`
+	TaxID              string      `json:"tax_id" binding:"required"`
+	BusinessType       string      `json:"business_type" binding:"required,business_type"`
+}
+
+// BranchOfficePatch is the editable part of a branch office
+type BranchOfficePatch struct {
+	Name          string         `json:"name" binding:"required"`
+	Address       string         `json:"address" binding:"required"`
+	PostalAddress *PostalAddress `json:"postal_address"`
+	Location      *GeoPoint      `json:"location"`
+	Phone         string         `json:"phone" binding:"required"`
+	Email         string         `json:"email" binding:"required,email"`
+}
+
+func (r CreateCompanyRequest) TaxIdentity() (string, string) {
+	return r.Country, r.TaxID
+}
+
+func (p CompanyPatch) TaxIdentity() (string, string) {
+	return p.Country, p.TaxID
+}
+
+func (c Company) CursorKey() (time.Time, string) {
+	return c.CreatedAt, c.ID
+}
+
+func (b BranchOffice) CursorKey() (time.Time, string) {
+	return b.CreatedAt, b.ID
 }
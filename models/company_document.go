@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// CompanyDocument is a stored licence, certificate or similar file for a
+// company. Every upload adds a version; earlier versions stay downloadable.
+type CompanyDocument struct {
+	Document `bson:",inline"`
+
+	ID             string            `bson:"_id,omitempty" json:"id"`
+	CompanyID      string            `bson:"company_id" json:"company_id"`
+	CurrentVersion int               `bson:"current_version" json:"current_version"`
+	Versions       []DocumentVersion `bson:"versions" json:"versions"`
+	CreatedBy      string            `bson:"created_by" json:"created_by"`
+	UpdatedBy      string            `bson:"updated_by" json:"updated_by"`
+	CreatedAt      time.Time         `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time         `bson:"updated_at" json:"updated_at"`
+}
+
+type DocumentVersion struct {
+	Version     int       `bson:"version" json:"version"`
+	Filename    string    `bson:"filename" json:"filename"`
+	ContentType string    `bson:"content_type" json:"content_type"`
+	Size        int64     `bson:"size" json:"size"`
+	Checksum    string    `bson:"checksum" json:"checksum"` // hex SHA-256
+	StorageKey  string    `bson:"storage_key" json:"-"`
+	UploadedBy  string    `bson:"uploaded_by" json:"uploaded_by"`
+	UploadedAt  time.Time `bson:"uploaded_at" json:"uploaded_at"`
+}
+
+type DocumentMetadataRequest struct {
+	Type        string `form:"type" json:"type" binding:"required"`
+	Number      string `form:"number" json:"number"`
+	Description string `form:"description" json:"description"`
+	IssuedDate  string `form:"issued_date" json:"issued_date"`
+	ExpiryDate  string `form:"expiry_date" json:"expiry_date"`
+}
+
+type UpdateDocumentRequest struct {
+	Type        string `json:"type"`
+	Number      string `json:"number"`
+	Description string `json:"description"`
+	IssuedDate  string `json:"issued_date"`
+	ExpiryDate  string `json:"expiry_date"`
+}
+
+func (d CompanyDocument) CursorKey() (time.Time, string) {
+	return d.CreatedAt, d.ID
+}
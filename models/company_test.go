@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The logo upload sets logo_url on the company document directly, so the
+// model must read it back for GetCompany and ListCompanies to return it
+func TestCompanyReadsLogoURL(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{
+		"_id":      "company-1",
+		"name":     "Acme Lending",
+		"logo_url": "/media/logo-1/medium",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var company Company
+	if err := bson.Unmarshal(raw, &company); err != nil {
+		t.Fatal(err)
+	}
+	if company.LogoURL != "/media/logo-1/medium" {
+		t.Fatalf("LogoURL = %q, want %q", company.LogoURL, "/media/logo-1/medium")
+	}
+
+	body, err := json.Marshal(company)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"logo_url":"/media/logo-1/medium"`) {
+		t.Fatalf("JSON is missing logo_url: %s", body)
+	}
+}
+
+func TestCompanyWithoutLogoOmitsLogoURL(t *testing.T) {
+	body, err := json.Marshal(Company{ID: "company-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "logo_url") {
+		t.Fatalf("JSON has logo_url without a logo: %s", body)
+	}
+}
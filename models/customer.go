@@ -0,0 +1,95 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+type CustomerType string
+
+const (
+	CustomerPersonal CustomerType = "personal"
+	CustomerBusiness CustomerType = "business"
+)
+
+func (t CustomerType) Valid() bool {
+	switch t {
+	case CustomerPersonal, CustomerBusiness:
+		return true
+	}
+	return false
+}
+
+// CustomerDetails are the parts of a borrower clients write. Personal
+// borrowers need their name, national ID and date of birth for KYC;
+// business borrowers need their registered name and registration number.
+// TaxID is stored normalized and checked against the address's country.
+type CustomerDetails struct {
+	BranchID           string        `bson:"branch_id" json:"branch_id" binding:"required"`
+	Type               CustomerType  `bson:"type" json:"type" binding:"required,customer_type"`
+	FirstName          string        `bson:"first_name" json:"first_name" binding:"max=100"`
+	LastName           string        `bson:"last_name" json:"last_name" binding:"max=100"`
+	NationalID         string        `bson:"national_id" json:"national_id" binding:"max=50"`
+	DateOfBirth        string        `bson:"date_of_birth" json:"date_of_birth" binding:"omitempty,datetime=2006-01-02"`
+	Employer           string        `bson:"employer" json:"employer" binding:"max=200"`
+	BusinessName       string        `bson:"business_name" json:"business_name" binding:"max=200"`
+	RegistrationNumber string        `bson:"registration_number" json:"registration_number" binding:"max=50"`
+	TaxID              string        `bson:"tax_id" json:"tax_id"`
+	Address            PostalAddress `bson:"address" json:"address" binding:"required"`
+	Phone              string        `bson:"phone" json:"phone" binding:"required"`
+	Email              string        `bson:"email" json:"email" binding:"omitempty,email"`
+	AnnualIncome       float64       `bson:"annual_income" json:"annual_income" binding:"gte=0"`
+}
+
+// Customer is a borrower of a company, owned by one of its branch offices.
+// Name is the person's full name or the business name, kept for display,
+// sorting and search.
+type Customer struct {
+	ID              string `bson:"_id,omitempty" json:"id"`
+	CompanyID       string `bson:"company_id" json:"company_id"`
+	Name            string `bson:"name" json:"name"`
+	CustomerDetails `bson:",inline"`
+	CreatedBy       string    `bson:"created_by" json:"created_by"`
+	UpdatedBy       string    `bson:"updated_by" json:"updated_by"`
+	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// MissingFields lists the JSON names of the fields the customer's type
+// requires that are empty
+func (d CustomerDetails) MissingFields() []string {
+	var missing []string
+	require := func(field, value string) {
+		if strings.TrimSpace(value) == "" {
+			missing = append(missing, field)
+		}
+	}
+
+	switch d.Type {
+	case CustomerPersonal:
+		require("first_name", d.FirstName)
+		require("last_name", d.LastName)
+		require("national_id", d.NationalID)
+		require("date_of_birth", d.DateOfBirth)
+	case CustomerBusiness:
+		require("business_name", d.BusinessName)
+		require("registration_number", d.RegistrationNumber)
+	}
+	return missing
+}
+
+// DisplayName is the customer's full name, or the business name
+func (d CustomerDetails) DisplayName() string {
+	if d.Type == CustomerBusiness {
+		return d.BusinessName
+	}
+	return strings.TrimSpace(d.FirstName + " " + d.LastName)
+}
+
+func (d CustomerDetails) TaxIdentity() (string, string) {
+	return d.Address.Country, d.TaxID
+}
+
+func (c Customer) CursorKey() (time.Time, string) {
+	return c.CreatedAt, c.ID
+}
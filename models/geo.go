@@ -0,0 +1,56 @@
+package models
+
+import (
+	"strings"
+)
+
+// PostalAddress is a structured street address
+type PostalAddress struct {
+	Line1      string `bson:"line1" json:"line1" binding:"required"`
+	Line2      string `bson:"line2" json:"line2"`
+	City       string `bson:"city" json:"city" binding:"required"`
+	Region     string `bson:"region" json:"region"`
+	PostalCode string `bson:"postal_code" json:"postal_code"`
+	Country    string `bson:"country" json:"country" binding:"required,iso3166_1_alpha2"` // ISO 3166-1 alpha-2
+}
+
+// String joins the non-empty parts of the address on one line
+func (a PostalAddress) String() string {
+	parts := make([]string, 0, 6)
+	for _, part := range []string{a.Line1, a.Line2, a.City, a.Region, a.PostalCode, strings.ToUpper(a.Country)} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// GeoPoint is a GeoJSON point. Coordinates are [longitude, latitude], the
+// order GeoJSON and Mongo's 2dsphere index expect.
+type GeoPoint struct {
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
+}
+
+func NewGeoPoint(lat, lng float64) GeoPoint {
+	return GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// Valid reports whether p is a point with a longitude in [-180, 180] and a
+// latitude in [-90, 90]
+func (p GeoPoint) Valid() bool {
+	if p.Type != "Point" || len(p.Coordinates) != 2 {
+		return false
+	}
+	return ValidLatLng(p.Coordinates[1], p.Coordinates[0])
+}
+
+func ValidLatLng(lat, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// NearbyBranchOffice is a branch office found by distance from a point
+type NearbyBranchOffice struct {
+	BranchOffice   `bson:",inline"`
+	DistanceMeters float64 `bson:"distance_meters" json:"distance_meters"`
+}
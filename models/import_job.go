@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+)
+
+type ImportKind string
+
+const (
+	ImportCompanies     ImportKind = "companies"
+	ImportBranchOffices ImportKind = "branch_offices"
+	ImportStaff         ImportKind = "staff"
+)
+
+type ImportStatus string
+
+const (
+	ImportStatusPending   ImportStatus = "pending"
+	ImportStatusRunning   ImportStatus = "running"
+	ImportStatusCompleted ImportStatus = "completed"
+	ImportStatusFailed    ImportStatus = "failed"
+)
+
+// ImportJob tracks a spreadsheet upload from validation through to insert
+type ImportJob struct {
+	ID              string       `bson:"_id,omitempty" json:"id"`
+	Kind            ImportKind   `bson:"kind" json:"kind"`
+	CompanyID       string       `bson:"company_id,omitempty" json:"company_id,omitempty"`
+	Filename        string       `bson:"filename" json:"filename"`
+	DryRun          bool         `bson:"dry_run" json:"dry_run"`
+	Status          ImportStatus `bson:"status" json:"status"`
+	TotalRows       int          `bson:"total_rows" json:"total_rows"`
+	ValidRows       int          `bson:"valid_rows" json:"valid_rows"`
+	InvalidRows     int          `bson:"invalid_rows" json:"invalid_rows"`
+	ImportedRows    int          `bson:"imported_rows" json:"imported_rows"`
+	RowErrors       []RowError   `bson:"row_errors" json:"row_errors"`
+	ErrorsTruncated bool         `bson:"errors_truncated" json:"errors_truncated"`
+	Error           string       `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedBy       string       `bson:"created_by" json:"created_by"`
+	CreatedAt       time.Time    `bson:"created_at" json:"created_at"`
+	FinishedAt      *time.Time   `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// RowError lists why one spreadsheet row was rejected. Row numbers count
+// the header as row 1, matching what the user sees in their spreadsheet.
+type RowError struct {
+	Row      int      `bson:"row" json:"row"`
+	Messages []string `bson:"messages" json:"messages"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+type JobRunStatus string
+
+const (
+	JobRunRunning   JobRunStatus = "running"
+	JobRunSucceeded JobRunStatus = "succeeded"
+	JobRunFailed    JobRunStatus = "failed"
+)
+
+type JobTrigger string
+
+const (
+	JobTriggerSchedule JobTrigger = "schedule"
+	JobTriggerManual   JobTrigger = "manual"
+)
+
+// JobRun records one execution of a scheduled job
+type JobRun struct {
+	ID          string       `bson:"_id,omitempty" json:"id"`
+	Job         string       `bson:"job" json:"job"`
+	Trigger     JobTrigger   `bson:"trigger" json:"trigger"`
+	TriggeredBy string       `bson:"triggered_by,omitempty" json:"triggered_by,omitempty"`
+	Instance    string       `bson:"instance" json:"instance"`
+	Status      JobRunStatus `bson:"status" json:"status"`
+	Error       string       `bson:"error,omitempty" json:"error,omitempty"`
+	StartedAt   time.Time    `bson:"started_at" json:"started_at"`
+	FinishedAt  *time.Time   `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	DurationMs  int64        `bson:"duration_ms" json:"duration_ms"`
+}
+
+// JobLock is the lease a replica holds while it runs a job
+type JobLock struct {
+	Job         string    `bson:"_id" json:"job"`
+	Owner       string    `bson:"owner" json:"owner"`
+	LockedUntil time.Time `bson:"locked_until" json:"locked_until"`
+}
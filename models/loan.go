@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+)
+
+// LoanStatus is where a loan application is in its lifecycle:
+//
+//	draft → submitted → under_review → approved → disbursed → active → closed
+//	                                 ↘ rejected                     ↘ written_off
+//
+// Rejected, closed and written off loans are final.
+type LoanStatus string
+
+const (
+	LoanDraft       LoanStatus = "draft"
+	LoanSubmitted   LoanStatus = "submitted"
+	LoanUnderReview LoanStatus = "under_review"
+	LoanApproved    LoanStatus = "approved"
+	LoanRejected    LoanStatus = "rejected"
+	LoanDisbursed   LoanStatus = "disbursed"
+	LoanActive      LoanStatus = "active"
+	LoanClosed      LoanStatus = "closed"
+	LoanWrittenOff  LoanStatus = "written_off"
+)
+
+// loanTransitions lists the statuses each status may move to
+var loanTransitions = map[LoanStatus][]LoanStatus{
+	LoanDraft:       {LoanSubmitted},
+	LoanSubmitted:   {LoanUnderReview},
+	LoanUnderReview: {LoanApproved, LoanRejected},
+	LoanApproved:    {LoanDisbursed},
+	LoanDisbursed:   {LoanActive},
+	LoanActive:      {LoanClosed, LoanWrittenOff},
+}
+
+// CanMoveTo reports whether a loan in status s may move to status to
+func (s LoanStatus) CanMoveTo(to LoanStatus) bool {
+	for _, next := range loanTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// LoanApplicationRequest is what a loan officer fills in for a customer.
+// Term is the number of installments and must be one the product allows.
+type LoanApplicationRequest struct {
+	CustomerID string  `bson:"customer_id" json:"customer_id" binding:"required"`
+	ProductID  string  `bson:"product_id" json:"product_id" binding:"required"`
+	Principal  float64 `bson:"principal" json:"principal" binding:"gt=0"`
+	Term       int     `bson:"term" json:"term" binding:"gt=0"`
+	Purpose    string  `bson:"purpose" json:"purpose" binding:"max=500"`
+}
+
+// LoanTransitionRequest moves a loan to its next status
+type LoanTransitionRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// LoanTransition records a loan moving from one status to another. The
+// first one of every loan is its creation, from no status to draft.
+type LoanTransition struct {
+	From    LoanStatus `bson:"from" json:"from"`
+	To      LoanStatus `bson:"to" json:"to"`
+	Reason  string     `bson:"reason" json:"reason"`
+	ActorID string     `bson:"actor_id" json:"actor_id"`
+	At      time.Time  `bson:"at" json:"at"`
+}
+
+// LoanApplication is a loan from being drafted at a branch office until it
+// is closed or written off. It is issued against one version of a loan
+// product, whose terms it keeps when the product changes later.
+type LoanApplication struct {
+	ID                     string `bson:"_id,omitempty" json:"id"`
+	CompanyID              string `bson:"company_id" json:"company_id"`
+	BranchID               string `bson:"branch_id" json:"branch_id"`
+	LoanApplicationRequest `bson:",inline"`
+	ProductVersion         int              `bson:"product_version" json:"product_version"`
+	Currency               string           `bson:"currency" json:"currency"`
+	Status                 LoanStatus       `bson:"status" json:"status"`
+	Transitions            []LoanTransition `bson:"transitions" json:"transitions"`
+	OfficerID              string           `bson:"officer_id" json:"officer_id"` // Looks after the loan; its creator until handed over
+	CreatedBy              string           `bson:"created_by" json:"created_by"`
+	UpdatedBy              string           `bson:"updated_by" json:"updated_by"`
+	CreatedAt              time.Time        `bson:"created_at" json:"created_at"`
+	UpdatedAt              time.Time        `bson:"updated_at" json:"updated_at"`
+}
+
+// LoanTimelineEntry is a transition with the name of the user who made it
+type LoanTimelineEntry struct {
+	LoanTransition `bson:",inline"`
+	ActorName      string `bson:"actor_name" json:"actor_name"`
+}
+
+func (l LoanApplication) CursorKey() (time.Time, string) {
+	return l.CreatedAt, l.ID
+}
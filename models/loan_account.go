@@ -0,0 +1,214 @@
+package models
+
+import (
+	"time"
+)
+
+type InstallmentStatus string
+
+const (
+	InstallmentDue     InstallmentStatus = "due"
+	InstallmentPartial InstallmentStatus = "partial"
+	InstallmentPaid    InstallmentStatus = "paid"
+	InstallmentOverdue InstallmentStatus = "overdue"
+)
+
+// AllocationBucket is a kind of amount owed that payments are allocated to
+type AllocationBucket string
+
+const (
+	BucketFees      AllocationBucket = "fees"
+	BucketPenalties AllocationBucket = "penalties"
+	BucketInterest  AllocationBucket = "interest"
+	BucketPrincipal AllocationBucket = "principal"
+)
+
+// DefaultWaterfall is the order payments are allocated in unless an
+// account is set up otherwise
+var DefaultWaterfall = []AllocationBucket{BucketFees, BucketPenalties, BucketInterest, BucketPrincipal}
+
+// OverpaymentMode is what happens to the part of a payment that exceeds
+// what has fallen due. Neither reduces the interest owed: paying in
+// advance settles the coming installments as they were scheduled, fees and
+// interest included, without paying principal down early.
+type OverpaymentMode string
+
+const (
+	OverpayAdvance OverpaymentMode = "advance" // Pays the coming installments ahead of time
+	OverpayCredit  OverpaymentMode = "credit"  // Kept on the account and used as installments fall due
+)
+
+type LoanAccountStatus string
+
+const (
+	LoanAccountActive  LoanAccountStatus = "active"
+	LoanAccountPaidOff LoanAccountStatus = "paid_off"
+)
+
+// AllocationSettings configure how an account's payments are allocated.
+// PenaltyRate is the percentage of an installment's unpaid amount charged
+// once when it becomes overdue.
+type AllocationSettings struct {
+	Waterfall   []AllocationBucket `bson:"waterfall" json:"waterfall" binding:"omitempty,len=4,unique,dive,oneof=fees penalties interest principal"`
+	Overpayment OverpaymentMode    `bson:"overpayment" json:"overpayment" binding:"omitempty,oneof=advance credit"`
+	PenaltyRate float64            `bson:"penalty_rate" json:"penalty_rate" binding:"gte=0,lte=100"`
+}
+
+// Amounts are what is owed or paid of each allocation bucket
+type Amounts struct {
+	Fees      float64 `bson:"fees" json:"fees"`
+	Penalties float64 `bson:"penalties" json:"penalties"`
+	Interest  float64 `bson:"interest" json:"interest"`
+	Principal float64 `bson:"principal" json:"principal"`
+}
+
+// Get returns the amount of bucket b
+func (a Amounts) Get(b AllocationBucket) float64 {
+	switch b {
+	case BucketFees:
+		return a.Fees
+	case BucketPenalties:
+		return a.Penalties
+	case BucketInterest:
+		return a.Interest
+	}
+	return a.Principal
+}
+
+// Add adds x to the amount of bucket b
+func (a *Amounts) Add(b AllocationBucket, x float64) {
+	switch b {
+	case BucketFees:
+		a.Fees += x
+	case BucketPenalties:
+		a.Penalties += x
+	case BucketInterest:
+		a.Interest += x
+	default:
+		a.Principal += x
+	}
+}
+
+// Plus returns the sum of a and b, bucket by bucket
+func (a Amounts) Plus(b Amounts) Amounts {
+	return Amounts{
+		Fees:      a.Fees + b.Fees,
+		Penalties: a.Penalties + b.Penalties,
+		Interest:  a.Interest + b.Interest,
+		Principal: a.Principal + b.Principal,
+	}
+}
+
+func (a Amounts) Total() float64 {
+	return a.Fees + a.Penalties + a.Interest + a.Principal
+}
+
+// AccountInstallment is one installment of a loan account and how much of
+// it has been paid
+type AccountInstallment struct {
+	Number         int               `bson:"number" json:"number"`
+	DueDate        time.Time         `bson:"due_date" json:"due_date"`
+	Due            Amounts           `bson:"due" json:"due"`
+	Paid           Amounts           `bson:"paid" json:"paid"`
+	Status         InstallmentStatus `bson:"status" json:"status"`
+	PenaltyCharged bool              `bson:"penalty_charged" json:"penalty_charged"`
+	PaidAt         *time.Time        `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+}
+
+// Outstanding is what is still owed of the installment
+func (i AccountInstallment) Outstanding() Amounts {
+	return Amounts{
+		Fees:      i.Due.Fees - i.Paid.Fees,
+		Penalties: i.Due.Penalties - i.Paid.Penalties,
+		Interest:  i.Due.Interest - i.Paid.Interest,
+		Principal: i.Due.Principal - i.Paid.Principal,
+	}
+}
+
+// LoanAccount tracks the repayment of an active loan. It is owned by the
+// branch office the loan was issued at. Balances is what is still owed
+// over all installments; Credit is money paid in but not yet allocated.
+type LoanAccount struct {
+	ID                   string               `bson:"_id,omitempty" json:"id"`
+	CompanyID            string               `bson:"company_id" json:"company_id"`
+	BranchID             string               `bson:"branch_id" json:"branch_id"`
+	LoanID               string               `bson:"loan_id" json:"loan_id"`
+	CustomerID           string               `bson:"customer_id" json:"customer_id"`
+	Currency             string               `bson:"currency" json:"currency"`
+	Principal            float64              `bson:"principal" json:"principal"`
+	Installments         []AccountInstallment `bson:"installments" json:"installments"`
+	Balances             Amounts              `bson:"balances" json:"balances"`
+	Credit               float64              `bson:"credit" json:"credit"`
+	Allocation           AllocationSettings   `bson:"allocation" json:"allocation"`
+	LatePaymentGraceDays int                  `bson:"late_payment_grace_days" json:"late_payment_grace_days"`
+	Status               LoanAccountStatus    `bson:"status" json:"status"`
+	CreatedBy            string               `bson:"created_by" json:"created_by"`
+	CreatedAt            time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt            time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+// RepaymentRequest records money received from a borrower
+type RepaymentRequest struct {
+	Amount    float64 `json:"amount" binding:"gt=0"`
+	Method    string  `json:"method" binding:"required,oneof=cash bank_transfer mobile_money card cheque"`
+	Reference string  `json:"reference" binding:"max=100"`
+	Notes     string  `json:"notes" binding:"max=500"`
+}
+
+// InstallmentAllocation is the part of a payment that went to one
+// installment
+type InstallmentAllocation struct {
+	Number  int     `bson:"number" json:"number"`
+	Amounts Amounts `bson:"amounts" json:"amounts"`
+}
+
+// Repayment is a payment on a loan account and how it was allocated. The
+// payment is allocated together with CreditUsed, the credit the account
+// had: Allocated went to installments that had fallen due, Prepaid to
+// installments ahead of time and Credited is left as the account's credit.
+type Repayment struct {
+	ID            string                  `bson:"_id,omitempty" json:"id"`
+	ReceiptNumber string                  `bson:"receipt_number" json:"receipt_number"`
+	CompanyID     string                  `bson:"company_id" json:"company_id"`
+	BranchID      string                  `bson:"branch_id" json:"branch_id"`
+	AccountID     string                  `bson:"account_id" json:"account_id"`
+	LoanID        string                  `bson:"loan_id" json:"loan_id"`
+	Amount        float64                 `bson:"amount" json:"amount"`
+	CreditUsed    float64                 `bson:"credit_used" json:"credit_used"`
+	Allocated     Amounts                 `bson:"allocated" json:"allocated"`
+	Prepaid       Amounts                 `bson:"prepaid" json:"prepaid"`
+	Credited      float64                 `bson:"credited" json:"credited"`
+	Installments  []InstallmentAllocation `bson:"installments" json:"installments"`
+	Method        string                  `bson:"method" json:"method"`
+	Reference     string                  `bson:"reference,omitempty" json:"reference,omitempty"`
+	Notes         string                  `bson:"notes,omitempty" json:"notes,omitempty"`
+	ReceivedBy    string                  `bson:"received_by" json:"received_by"`
+	CreatedAt     time.Time               `bson:"created_at" json:"created_at"`
+}
+
+// CreditAllocation is credit a loan account had that was allocated to
+// installments as they fell due, without a payment being taken: Amount of
+// it went to Allocated.
+type CreditAllocation struct {
+	ID           string                  `bson:"_id,omitempty" json:"id"`
+	CompanyID    string                  `bson:"company_id" json:"company_id"`
+	BranchID     string                  `bson:"branch_id" json:"branch_id"`
+	AccountID    string                  `bson:"account_id" json:"account_id"`
+	LoanID       string                  `bson:"loan_id" json:"loan_id"`
+	Amount       float64                 `bson:"amount" json:"amount"`
+	Allocated    Amounts                 `bson:"allocated" json:"allocated"`
+	Installments []InstallmentAllocation `bson:"installments" json:"installments"`
+	CreatedAt    time.Time               `bson:"created_at" json:"created_at"`
+}
+
+func (a LoanAccount) CursorKey() (time.Time, string) {
+	return a.CreatedAt, a.ID
+}
+
+func (r Repayment) CursorKey() (time.Time, string) {
+	return r.CreatedAt, r.ID
+}
+
+func (a CreditAllocation) CursorKey() (time.Time, string) {
+	return a.CreatedAt, a.ID
+}
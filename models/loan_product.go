@@ -0,0 +1,188 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+type InterestMethod string
+
+const (
+	InterestFlat             InterestMethod = "flat"
+	InterestDecliningBalance InterestMethod = "declining_balance"
+	InterestAnnuity          InterestMethod = "annuity" // Equal installments
+)
+
+func (m InterestMethod) Valid() bool {
+	switch m {
+	case InterestFlat, InterestDecliningBalance, InterestAnnuity:
+		return true
+	}
+	return false
+}
+
+type RepaymentFrequency string
+
+const (
+	RepaymentWeekly   RepaymentFrequency = "weekly"
+	RepaymentBiweekly RepaymentFrequency = "biweekly"
+	RepaymentMonthly  RepaymentFrequency = "monthly"
+)
+
+func (f RepaymentFrequency) Valid() bool {
+	switch f {
+	case RepaymentWeekly, RepaymentBiweekly, RepaymentMonthly:
+		return true
+	}
+	return false
+}
+
+type LoanProductStatus string
+
+const (
+	LoanProductActive  LoanProductStatus = "active"
+	LoanProductRetired LoanProductStatus = "retired"
+)
+
+// ProductFee is charged on every loan of a product, either as a fixed
+// amount or as a percentage of the principal
+type ProductFee struct {
+	Name        string  `bson:"name" json:"name" binding:"required,max=100"`
+	Calculation string  `bson:"calculation" json:"calculation" binding:"required,oneof=fixed percentage"`
+	Amount      float64 `bson:"amount" json:"amount" binding:"gte=0"` // Percentages are 0-100
+	ChargedOn   string  `bson:"charged_on" json:"charged_on" binding:"required,oneof=disbursement installment"`
+}
+
+// EligibilityRules limit who a product may be offered to. Zero values do
+// not limit anything.
+type EligibilityRules struct {
+	CustomerTypes   []CustomerType `bson:"customer_types" json:"customer_types" binding:"dive,customer_type"`
+	MinAge          int            `bson:"min_age" json:"min_age" binding:"gte=0"`
+	MaxAge          int            `bson:"max_age" json:"max_age" binding:"omitempty,gtefield=MinAge"`
+	MinAnnualIncome float64        `bson:"min_annual_income" json:"min_annual_income" binding:"gte=0"`
+	BranchIDs       []string       `bson:"branch_ids" json:"branch_ids"` // Branch offices offering the product
+}
+
+// LoanProductTerms are the parts of a product that loans are issued
+// against. Changing any of them makes a new version of the product.
+// InterestRate is the nominal annual rate in percent. GracePeriods is the
+// number of installments at the start in which no principal is repaid;
+// LatePaymentGraceDays is how long after a due date no penalty is charged.
+type LoanProductTerms struct {
+	Name                 string             `bson:"name" json:"name" binding:"required,max=200"`
+	Description          string             `bson:"description" json:"description" binding:"max=2000"`
+	Currency             string             `bson:"currency" json:"currency" binding:"required,iso4217"`
+	MinPrincipal         float64            `bson:"min_principal" json:"min_principal" binding:"gt=0"`
+	MaxPrincipal         float64            `bson:"max_principal" json:"max_principal" binding:"gtefield=MinPrincipal"`
+	Terms                []int              `bson:"terms" json:"terms" binding:"required,min=1,dive,gt=0"` // Allowed numbers of installments
+	InterestRate         float64            `bson:"interest_rate" json:"interest_rate" binding:"gte=0,lte=1000"`
+	InterestMethod       InterestMethod     `bson:"interest_method" json:"interest_method" binding:"required,interest_method"`
+	RepaymentFrequency   RepaymentFrequency `bson:"repayment_frequency" json:"repayment_frequency" binding:"required,repayment_frequency"`
+	Fees                 []ProductFee       `bson:"fees" json:"fees" binding:"dive"`
+	GracePeriods         int                `bson:"grace_periods" json:"grace_periods" binding:"gte=0"`
+	LatePaymentGraceDays int                `bson:"late_payment_grace_days" json:"late_payment_grace_days" binding:"gte=0"`
+	Eligibility          EligibilityRules   `bson:"eligibility" json:"eligibility"`
+}
+
+// LoanProduct is a kind of loan a company offers, as it currently stands.
+// Retired products are kept so the loans issued against them can still be
+// read, but no new loans are issued against them.
+type LoanProduct struct {
+	ID               string `bson:"_id,omitempty" json:"id"`
+	CompanyID        string `bson:"company_id" json:"company_id"`
+	LoanProductTerms `bson:",inline"`
+	Status           LoanProductStatus `bson:"status" json:"status"`
+	CurrentVersion   int               `bson:"current_version" json:"current_version"`
+	CreatedBy        string            `bson:"created_by" json:"created_by"`
+	UpdatedBy        string            `bson:"updated_by" json:"updated_by"`
+	CreatedAt        time.Time         `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time         `bson:"updated_at" json:"updated_at"`
+}
+
+// LoanProductVersion is an immutable copy of a product's terms. Loans
+// refer to the version they were issued against, so later changes to the
+// product do not alter them.
+type LoanProductVersion struct {
+	ID               string `bson:"_id,omitempty" json:"id"`
+	ProductID        string `bson:"product_id" json:"product_id"`
+	CompanyID        string `bson:"company_id" json:"company_id"`
+	Version          int    `bson:"version" json:"version"`
+	LoanProductTerms `bson:",inline"`
+	CreatedBy        string    `bson:"created_by" json:"created_by"`
+	CreatedAt        time.Time `bson:"created_at" json:"created_at"`
+}
+
+func (p LoanProduct) CursorKey() (time.Time, string) {
+	return p.CreatedAt, p.ID
+}
+
+// AllowsTerm reports whether loans may be repaid in n installments
+func (t LoanProductTerms) AllowsTerm(n int) bool {
+	for _, term := range t.Terms {
+		if term == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Ineligibility lists the reasons customer, applying at branchID on date
+// at, may not take a loan of principal under these terms. It is empty
+// when they may.
+func (t LoanProductTerms) Ineligibility(customer Customer, branchID string, principal float64, at time.Time) []string {
+	var reasons []string
+	if principal < t.MinPrincipal || principal > t.MaxPrincipal {
+		reasons = append(reasons, fmt.Sprintf("principal must be between %.2f and %.2f %s",
+			t.MinPrincipal, t.MaxPrincipal, t.Currency))
+	}
+
+	rules := t.Eligibility
+	if len(rules.CustomerTypes) > 0 && !containsCustomerType(rules.CustomerTypes, customer.Type) {
+		reasons = append(reasons, "product is not offered to "+string(customer.Type)+" customers")
+	}
+	if len(rules.BranchIDs) > 0 && !containsString(rules.BranchIDs, branchID) {
+		reasons = append(reasons, "product is not offered at this branch office")
+	}
+	if customer.AnnualIncome < rules.MinAnnualIncome {
+		reasons = append(reasons, fmt.Sprintf("annual income must be at least %.2f", rules.MinAnnualIncome))
+	}
+	if customer.Type == CustomerPersonal && (rules.MinAge > 0 || rules.MaxAge > 0) {
+		born, err := time.Parse("2006-01-02", customer.DateOfBirth)
+		if err != nil {
+			reasons = append(reasons, "customer's date of birth is unknown")
+		} else {
+			age := ageOn(born, at)
+			if age < rules.MinAge || (rules.MaxAge > 0 && age > rules.MaxAge) {
+				reasons = append(reasons, fmt.Sprintf("customer's age %d is outside the allowed range", age))
+			}
+		}
+	}
+	return reasons
+}
+
+// ageOn is the age in whole years on date at of someone born on born
+func ageOn(born, at time.Time) int {
+	age := at.Year() - born.Year()
+	if at.Month() < born.Month() || (at.Month() == born.Month() && at.Day() < born.Day()) {
+		age--
+	}
+	return age
+}
+
+func containsCustomerType(types []CustomerType, t CustomerType) bool {
+	for _, candidate := range types {
+		if candidate == t {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
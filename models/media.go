@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+type MediaKind string
+
+const (
+	MediaUserAvatar  MediaKind = "user_avatar"
+	MediaCompanyLogo MediaKind = "company_logo"
+)
+
+// Media is an uploaded image and its resized variants. The ID is random
+// and appears in public /media URLs, so a given URL never changes content.
+type Media struct {
+	ID        string         `bson:"_id" json:"id"`
+	Kind      MediaKind      `bson:"kind" json:"kind"`
+	OwnerID   string         `bson:"owner_id" json:"owner_id"`
+	Variants  []MediaVariant `bson:"variants" json:"variants"`
+	CreatedBy string         `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time      `bson:"created_at" json:"created_at"`
+}
+
+type MediaVariant struct {
+	Name        string `bson:"name" json:"name"`
+	URL         string `bson:"url" json:"url"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Width       int    `bson:"width" json:"width"`
+	Height      int    `bson:"height" json:"height"`
+	Size        int64  `bson:"size" json:"size"`
+	Checksum    string `bson:"checksum" json:"checksum"` // hex SHA-256
+	StorageKey  string `bson:"storage_key" json:"-"`
+}
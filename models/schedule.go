@@ -0,0 +1,43 @@
+package models
+
+// GraceType is what is paid during a loan's grace periods
+type GraceType string
+
+const (
+	GracePrincipal GraceType = "principal" // Interest only
+	GraceFull      GraceType = "full"      // Nothing; the interest is added to what is owed
+)
+
+type RoundingMode string
+
+const (
+	RoundHalfUp   RoundingMode = "half_up"
+	RoundHalfEven RoundingMode = "half_even"
+	RoundDown     RoundingMode = "down"
+	RoundUp       RoundingMode = "up"
+)
+
+// Rounding is how amounts in a schedule are rounded. Without decimals
+// amounts have 2; without a mode halves round away from zero.
+type Rounding struct {
+	Decimals *int         `json:"decimals" binding:"omitempty,gte=0,lte=4"`
+	Mode     RoundingMode `json:"mode" binding:"omitempty,oneof=half_up half_even down up"`
+}
+
+// ScheduleRequest describes a loan to draw up installments for.
+// InterestRate is the nominal annual rate in percent. The first installment
+// is due one period after StartDate. The first GracePeriods installments
+// repay no principal, and BalloonAmount of the principal is left to be
+// repaid with the last installment.
+type ScheduleRequest struct {
+	Principal          float64            `json:"principal" binding:"gt=0"`
+	InterestRate       float64            `json:"interest_rate" binding:"gte=0,lte=1000"`
+	InterestMethod     InterestMethod     `json:"interest_method" binding:"required,interest_method"`
+	RepaymentFrequency RepaymentFrequency `json:"repayment_frequency" binding:"required,repayment_frequency"`
+	Installments       int                `json:"installments" binding:"gt=0,lte=1000"`
+	StartDate          string             `json:"start_date" binding:"required,datetime=2006-01-02"`
+	GracePeriods       int                `json:"grace_periods" binding:"gte=0,ltfield=Installments"`
+	GraceType          GraceType          `json:"grace_type" binding:"omitempty,oneof=principal full"` // Default: principal
+	BalloonAmount      float64            `json:"balloon_amount" binding:"gte=0,ltfield=Principal"`
+	Rounding           Rounding           `json:"rounding"`
+}
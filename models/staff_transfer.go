@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// StaffTransferRequest moves a staff member from one branch office to
+// another. Without to_company_id the move stays within their company; with
+// it they move to another company of the same group and lose their roles
+// in the old one.
+type StaffTransferRequest struct {
+	FromBranchID string     `json:"from_branch_id" binding:"required"`
+	ToBranchID   string     `json:"to_branch_id" binding:"required"`
+	ToCompanyID  string     `json:"to_company_id"`
+	Role         BranchRole `json:"role" binding:"omitempty,branch_role"` // Default: the role held at from_branch_id
+	// Drop the staff member's roles at the company's other branch offices
+	RevokeOtherRoles bool   `json:"revoke_other_roles"`
+	HandoverTo       string `json:"handover_to"` // Colleague at from_branch_id who takes over open work
+	Reason           string `json:"reason" binding:"max=500"`
+}
+
+// StaffTransfer records a completed transfer
+type StaffTransfer struct {
+	ID            string             `bson:"_id,omitempty" json:"id"`
+	UserID        string             `bson:"user_id" json:"user_id"`
+	FromCompanyID string             `bson:"from_company_id" json:"from_company_id"`
+	FromBranchID  string             `bson:"from_branch_id" json:"from_branch_id"`
+	ToCompanyID   string             `bson:"to_company_id" json:"to_company_id"`
+	ToBranchID    string             `bson:"to_branch_id" json:"to_branch_id"`
+	Role          BranchRole         `bson:"role" json:"role"`
+	RevokedRoles  []BranchAssignment `bson:"revoked_roles" json:"revoked_roles"`
+	HandoverTo    string             `bson:"handover_to,omitempty" json:"handover_to,omitempty"`
+	HandedOver    int                `bson:"handed_over" json:"handed_over"` // Open work items reassigned to HandoverTo
+	Reason        string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	TransferredBy string             `bson:"transferred_by" json:"transferred_by"`
+	TransferredAt time.Time          `bson:"transferred_at" json:"transferred_at"`
+}
+
+// StaffTransferResponse is the transfer together with the staff member as
+// they are after it
+type StaffTransferResponse struct {
+	Transfer StaffTransfer `json:"transfer"`
+	User     User          `json:"user"`
+}
@@ -15,18 +15,68 @@ const (
 	RoleStaff     Role = "staff" // New role for branch office staff
 )
 
+// BranchRole is what a staff member does at one branch office. The same
+// person can hold different roles at different branches.
+type BranchRole string
+
+const (
+	BranchRoleTeller      BranchRole = "teller"
+	BranchRoleLoanOfficer BranchRole = "loan_officer"
+	BranchRoleManager     BranchRole = "branch_manager"
+)
+
+func (r BranchRole) Valid() bool {
+	switch r {
+	case BranchRoleTeller, BranchRoleLoanOfficer, BranchRoleManager:
+		return true
+	}
+	return false
+}
+
+// BranchAssignment gives a staff member a role at a branch office
+type BranchAssignment struct {
+	BranchID   string     `bson:"branch_id" json:"branch_id"`
+	Role       BranchRole `bson:"role" json:"role"`
+	AssignedAt time.Time  `bson:"assigned_at" json:"assigned_at"`
+	AssignedBy string     `bson:"assigned_by" json:"assigned_by"`
+}
+
+type BranchRoleRequest struct {
+	BranchID string     `bson:"branch_id" json:"branch_id" binding:"required"`
+	Role     BranchRole `bson:"role" json:"role" binding:"required,branch_role"`
+}
+
+// CompanyMembership makes a user a member of a company with roles that
+// apply while they work in it
+type CompanyMembership struct {
+	CompanyID string    `bson:"company_id" json:"company_id"`
+	Roles     []Role    `bson:"roles" json:"roles"`
+	JoinedAt  time.Time `bson:"joined_at" json:"joined_at"`
+	AddedBy   string    `bson:"added_by" json:"added_by"`
+}
+
+type MembershipRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	Roles  []Role `json:"roles" binding:"required,min=1"`
+}
+
+type SwitchCompanyRequest struct {
+	CompanyID string `json:"company_id" binding:"required"`
+}
+
 type User struct {
-	ID            string    `bson:"_id,omitempty" json:"id"`
-	Username      string    `bson:"username" json:"username"`
-	Password      string    `bson:"password" json:"-"`
-	Roles         []Role    `bson:"roles" json:"roles"`
-	FullName      string    `bson:"full_name" json:"full_name"`
-	Bio           string    `bson:"bio" json:"bio"`
-	Avatar        string    `bson:"avatar" json:"avatar"`
-	CompanyID     string    `bson:"company_id" json:"company_id"`         // Associated company
-	BranchOffices []string  `bson:"branch_offices" json:"branch_offices"` // Associated branch offices
-	CreatedAt     time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt     time.Time `bson:"updated_at" json:"updated_at"`
+	ID          string              `bson:"_id,omitempty" json:"id"`
+	Username    string              `bson:"username" json:"username"`
+	Password    string              `bson:"password" json:"-"`
+	Roles       []Role              `bson:"roles" json:"roles"`
+	FullName    string              `bson:"full_name" json:"full_name"`
+	Bio         string              `bson:"bio" json:"bio"`
+	Avatar      string              `bson:"avatar" json:"avatar"`
+	CompanyID   string              `bson:"company_id" json:"company_id"`     // Home company, used when signing in
+	Memberships []CompanyMembership `bson:"memberships" json:"memberships"`   // Every company the user works in, the home company included
+	BranchRoles []BranchAssignment  `bson:"branch_roles" json:"branch_roles"` // Role at each branch office
+	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time           `bson:"updated_at" json:"updated_at"`
 }
 
 type LoginRequest struct {
@@ -35,6 +85,25 @@ type LoginRequest struct {
 }
 
 type RegisterRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=32"`
+	Password string `json:"password" binding:"required,min=6"`
+	FullName string `json:"full_name" binding:"required"`
+	Roles    []Role `json:"roles"`
+}
+
+// StaffRegisterRequest signs up a staff member. Sign-up is public, so it
+// grants nothing: a company admin or branch manager then adds them to a
+// company and gives them branch roles.
+type StaffRegisterRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=32"`
+	Password string `json:"password" binding:"required,min=6"`
+	FullName string `json:"full_name" binding:"required"`
+}
+
+// StaffImportRow is one row of a staff import. Each branch office is
+// referenced by ID or name, optionally followed by ":role"; without a role
+// the staff member is a teller there.
+type StaffImportRow struct {
 	Username      string   `json:"username" binding:"required,min=3,max=32"`
 	Password      string   `json:"password" binding:"required,min=6"`
 	FullName      string   `json:"full_name" binding:"required"`
@@ -44,8 +113,9 @@ type RegisterRequest struct {
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token     string `json:"token"`
+	CompanyID string `json:"company_id,omitempty"` // Company the token is scoped to
+	User      User   `json:"user"`
 }
 
 type UpdateProfileRequest struct {
@@ -54,18 +124,27 @@ type UpdateProfileRequest struct {
 	Avatar   string `json:"avatar" binding:"omitempty,url"`
 }
 
+// ProfilePatch is the editable part of a user's own profile. Avatar is
+// either an external URL or a /media path from an upload.
+type ProfilePatch struct {
+	FullName string `json:"full_name" binding:"required,max=100"`
+	Bio      string `json:"bio" binding:"max=500"`
+	Avatar   string `json:"avatar" binding:"omitempty,uri"`
+}
+
 type UpdatePasswordRequest struct {
 	CurrentPassword string `json:"current_password" binding:"required,min=6"`
 	NewPassword     string `json:"new_password" binding:"required,min=6"`
 }
 
 // Staff specific types
+
+// StaffAssignment replaces a staff member's branch roles within a company.
+// The roles it changes are recorded as assignment periods.
 type StaffAssignment struct {
-	UserID        string    `bson:"user_id" json:"user_id"`
-	CompanyID     string    `bson:"company_id" json:"company_id"`
-	BranchOffices []string  `bson:"branch_offices" json:"branch_offices"`
-	AssignedAt    time.Time `bson:"assigned_at" json:"assigned_at"`
-	AssignedBy    string    `bson:"assigned_by" json:"assigned_by"`
+	UserID      string              `json:"user_id" binding:"required"`
+	CompanyID   string              `json:"company_id" binding:"required"`
+	BranchRoles []BranchRoleRequest `json:"branch_roles" binding:"dive"`
 }
 
 func (u *User) HashPassword() error {
@@ -97,15 +176,113 @@ func (u *User) IsStaff() bool {
 	return false
 }
 
-// HasAccessToBranch checks if user has access to a specific branch office
+// Membership returns the user's membership of a company, or nil if they
+// are not a member
+func (u *User) Membership(companyID string) *CompanyMembership {
+	for i := range u.Memberships {
+		if u.Memberships[i].CompanyID == companyID {
+			return &u.Memberships[i]
+		}
+	}
+	return nil
+}
+
+// SetMembership adds the user to a company with roles, or replaces their
+// roles there if they are already a member
+func (u *User) SetMembership(companyID string, roles []Role, addedBy string) {
+	if m := u.Membership(companyID); m != nil {
+		m.Roles = roles
+		return
+	}
+	u.Memberships = append(u.Memberships, CompanyMembership{
+		CompanyID: companyID,
+		Roles:     roles,
+		JoinedAt:  time.Now(),
+		AddedBy:   addedBy,
+	})
+}
+
+func (m *CompanyMembership) hasRole(role Role) bool {
+	if m == nil {
+		return false
+	}
+	for _, r := range m.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveMembership takes the user out of a company
+func (u *User) RemoveMembership(companyID string) {
+	memberships := make([]CompanyMembership, 0, len(u.Memberships))
+	for _, m := range u.Memberships {
+		if m.CompanyID != companyID {
+			memberships = append(memberships, m)
+		}
+	}
+	u.Memberships = memberships
+}
+
+// RolesIn returns the roles the user acts with in a company: those of
+// their membership there, plus super user, which holds everywhere. Users
+// without a membership of their home company, such as ones created before
+// memberships existed, keep their own roles there.
+func (u *User) RolesIn(companyID string) []Role {
+	if companyID == "" {
+		return u.Roles
+	}
+	m := u.Membership(companyID)
+	if m == nil && companyID == u.CompanyID {
+		return u.Roles
+	}
+
+	var roles []Role
+	if m != nil {
+		roles = append(roles, m.Roles...)
+	}
+	for _, role := range u.Roles {
+		if role == RoleSuperUser && !m.hasRole(role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// HasAccessToBranch checks if user has any role at a specific branch office
 func (u *User) HasAccessToBranch(branchID string) bool {
+	return u.BranchRole(branchID) != ""
+}
+
+// BranchRole returns the user's role at a branch office, or "" if they
+// have none there
+func (u *User) BranchRole(branchID string) BranchRole {
 	if !u.IsStaff() {
+		return ""
+	}
+	for _, assignment := range u.BranchRoles {
+		if assignment.BranchID == branchID {
+			return assignment.Role
+		}
+	}
+	return ""
+}
+
+// HasBranchRole checks if the user holds one of roles at a branch office
+func (u *User) HasBranchRole(branchID string, roles ...BranchRole) bool {
+	have := u.BranchRole(branchID)
+	if have == "" {
 		return false
 	}
-	for _, branch := range u.BranchOffices {
-		if branch == branchID {
+	for _, role := range roles {
+		if have == role {
 			return true
 		}
 	}
 	return false
 }
+
+func (u User) CursorKey() (time.Time, string) {
+	return u.CreatedAt, u.ID
+}
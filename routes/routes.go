@@ -4,19 +4,44 @@ import (
 	"loan/config"
 	"loan/controllers"
 	"loan/middleware"
+	"loan/models"
+	"loan/scheduler"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(router *gin.Engine, config *config.Config) {
+func SetupRoutes(router *gin.Engine, config *config.Config, sched *scheduler.Scheduler) {
 	// Add request logger middleware
 	router.Use(middleware.RequestLogger())
 
+	// Add audit trail middleware for state-changing requests
+	auditLogger := middleware.NewAuditLogger(config.MongoDB.Collection("audit_logs"), 1000)
+	router.Use(middleware.AuditMiddleware(auditLogger))
+
 	// Initialize controllers
 	authController := controllers.NewAuthController(config)
 	companyController := controllers.NewCompanyController(config)
 	branchOfficeController := controllers.NewBranchOfficeController(config)
 	staffController := controllers.NewStaffController(config)
+	historyController := controllers.NewHistoryController(config)
+	auditController := controllers.NewAuditController(config)
+	searchController := controllers.NewSearchController(config)
+	importController := controllers.NewImportController(config)
+	documentController := controllers.NewDocumentController(config)
+	schedulerController := controllers.NewSchedulerController(config, sched)
+	mediaController := controllers.NewMediaController(config)
+	calendarController := controllers.NewCalendarController(config)
+	groupController := controllers.NewGroupController(config)
+	assignmentController := controllers.NewAssignmentController(config)
+	membershipController := controllers.NewMembershipController(config)
+	customerController := controllers.NewCustomerController(config)
+	loanProductController := controllers.NewLoanProductController(config)
+	loanController := controllers.NewLoanController(config)
+	calculatorController := controllers.NewCalculatorController(config)
+	loanAccountController := controllers.NewLoanAccountController(config)
+
+	// Uploaded images are public so they work in <img> tags
+	router.GET("/media/:id/:variant", mediaController.ServeMedia)
 
 	// API routes group
 	api := router.Group("/api")
@@ -27,6 +52,7 @@ func SetupRoutes(router *gin.Engine, config *config.Config) {
 			auth.POST("/register", authController.Register)
 			auth.POST("/login", authController.Login)
 			auth.POST("/staff/register", staffController.RegisterStaff)
+			auth.POST("/switch-company", middleware.AuthMiddleware(), authController.SwitchCompany)
 		}
 
 		// Protected routes
@@ -38,8 +64,13 @@ func SetupRoutes(router *gin.Engine, config *config.Config) {
 			{
 				users.GET("/profile", authController.GetCurrentProfile)
 				users.PUT("/profile", authController.UpdateProfile)
+				users.PATCH("/profile", authController.PatchProfile)
 				users.PUT("/password", authController.UpdatePassword)
+				users.PUT("/profile/avatar", mediaController.UploadAvatar)
+				users.DELETE("/profile/avatar", mediaController.DeleteAvatar)
 				users.GET("", authController.GetAllUsers)
+				users.GET("/:id/history", historyController.GetUserHistory)
+				users.GET("/:id/assignments", assignmentController.ListUserAssignments)
 			}
 
 			// Company routes
@@ -50,19 +81,211 @@ func SetupRoutes(router *gin.Engine, config *config.Config) {
 				companies.GET("", companyController.ListCompanies)
 				companies.GET("/detail/:id", companyController.GetCompany)
 				companies.PUT("/detail/:id", companyController.UpdateCompany)
+				companies.PATCH("/detail/:id", companyController.PatchCompany)
 				companies.DELETE("/detail/:id", companyController.DeleteCompany)
+				companies.PUT("/detail/:id/logo", mediaController.UploadCompanyLogo)
+				companies.DELETE("/detail/:id/logo", mediaController.DeleteCompanyLogo)
+				companies.GET("/detail/:id/history", historyController.GetCompanyHistory)
+				companies.GET("/detail/:id/subsidiaries", groupController.ListSubsidiaries)
+				companies.GET("/detail/:id/ancestors", groupController.ListAncestors)
+				companies.GET("/detail/:id/members", membershipController.ListMembers)
+				companies.PUT("/detail/:id/members",
+					middleware.RequireRoles(models.RoleAdmin, models.RoleSuperUser),
+					membershipController.SetMember)
+				companies.DELETE("/detail/:id/members/:user_id",
+					middleware.RequireRoles(models.RoleAdmin, models.RoleSuperUser),
+					membershipController.RemoveMember)
+				companies.POST("/import", importController.ImportCompanies)
 
 				// Branch office routes
 				companies.POST("/:id/branches", branchOfficeController.CreateBranchOffice)
 				companies.GET("/:id/branches", branchOfficeController.ListBranchOffices)
 				companies.GET("/:id/branches/:branch_id", branchOfficeController.GetBranchOffice)
 				companies.PUT("/:id/branches/:branch_id", branchOfficeController.UpdateBranchOffice)
+				companies.PATCH("/:id/branches/:branch_id", branchOfficeController.PatchBranchOffice)
 				companies.DELETE("/:id/branches/:branch_id", branchOfficeController.DeleteBranchOffice)
+				companies.GET("/:id/branches/:branch_id/history", historyController.GetBranchOfficeHistory)
+				companies.POST("/:id/branches/import", importController.ImportBranchOffices)
+
+				// Consolidated routes across a company and its subsidiaries
+				companies.GET("/:id/group/branches", groupController.ListGroupBranchOffices)
+				companies.GET("/:id/group/staff", groupController.ListGroupStaff)
+
+				// Opening hours and holiday routes
+				companies.PUT("/:id/branches/:branch_id/hours", calendarController.SetOpeningHours)
+				companies.GET("/:id/branches/:branch_id/open", calendarController.IsOpen)
+				companies.GET("/:id/branches/:branch_id/next-business-day", calendarController.NextBusinessDay)
+				companies.GET("/:id/branches/:branch_id/holidays", calendarController.ListHolidays)
+				companies.POST("/:id/branches/:branch_id/holidays", calendarController.CreateHoliday)
+				companies.POST("/:id/branches/:branch_id/holidays/import", calendarController.ImportHolidays)
+				companies.GET("/:id/holidays", calendarController.ListHolidays)
+				companies.POST("/:id/holidays", calendarController.CreateHoliday)
+				companies.POST("/:id/holidays/import", calendarController.ImportHolidays)
+				companies.DELETE("/:id/holidays/:holiday_id", calendarController.DeleteHoliday)
 
 				// Staff management routes
 				companies.POST("/:id/branches/:branch_id/staff", staffController.AssignStaffToBranch)
 				companies.GET("/:id/branches/:branch_id/staff", staffController.ListStaffByBranch)
-				companies.DELETE("/:id/branches/:branch_id/staff/:user_id", staffController.RemoveStaffFromBranch)
+				companies.DELETE("/:id/branches/:branch_id/staff/:user_id",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleManager),
+					staffController.RemoveStaffFromBranch)
+				companies.POST("/:id/staff/import", importController.ImportStaff)
+
+				// Effective-dated staff assignment routes
+				companies.GET("/:id/branches/:branch_id/assignments", assignmentController.ListBranchAssignments)
+				companies.POST("/:id/branches/:branch_id/assignments",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleManager),
+					assignmentController.ScheduleAssignment)
+				companies.DELETE("/:id/branches/:branch_id/assignments/:assignment_id",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleManager),
+					assignmentController.CancelAssignment)
+
+				// Customer (borrower) routes
+				companies.POST("/:id/customers", customerController.CreateCustomer)
+				companies.GET("/:id/customers", customerController.ListCustomers)
+				companies.GET("/:id/customers/:customer_id", customerController.GetCustomer)
+				companies.PUT("/:id/customers/:customer_id", customerController.UpdateCustomer)
+				companies.PATCH("/:id/customers/:customer_id", customerController.PatchCustomer)
+				companies.DELETE("/:id/customers/:customer_id", customerController.DeleteCustomer)
+				companies.GET("/:id/customers/:customer_id/history", historyController.GetCustomerHistory)
+
+				// Loan product routes; only admins change the catalog
+				companies.GET("/:id/loan-products", loanProductController.ListLoanProducts)
+				companies.GET("/:id/loan-products/:product_id", loanProductController.GetLoanProduct)
+				companies.GET("/:id/loan-products/:product_id/versions", loanProductController.ListLoanProductVersions)
+				companies.GET("/:id/loan-products/:product_id/versions/:version", loanProductController.GetLoanProductVersion)
+				companies.POST("/:id/loan-products",
+					middleware.RequireRoles(models.RoleAdmin, models.RoleSuperUser),
+					loanProductController.CreateLoanProduct)
+				companies.PUT("/:id/loan-products/:product_id",
+					middleware.RequireRoles(models.RoleAdmin, models.RoleSuperUser),
+					loanProductController.UpdateLoanProduct)
+				companies.PATCH("/:id/loan-products/:product_id",
+					middleware.RequireRoles(models.RoleAdmin, models.RoleSuperUser),
+					loanProductController.PatchLoanProduct)
+				companies.POST("/:id/loan-products/:product_id/retire",
+					middleware.RequireRoles(models.RoleAdmin, models.RoleSuperUser),
+					loanProductController.RetireLoanProduct)
+				companies.POST("/:id/loan-products/:product_id/reactivate",
+					middleware.RequireRoles(models.RoleAdmin, models.RoleSuperUser),
+					loanProductController.ReactivateLoanProduct)
+
+				// Loan application routes; approving, rejecting, closing and
+				// writing off need a branch manager
+				companies.POST("/:id/branches/:branch_id/loans",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanController.CreateLoan)
+				companies.GET("/:id/branches/:branch_id/loans",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanController.ListLoans)
+				companies.GET("/:id/branches/:branch_id/loans/:loan_id",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanController.GetLoan)
+				companies.PUT("/:id/branches/:branch_id/loans/:loan_id",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanController.UpdateLoan)
+				companies.DELETE("/:id/branches/:branch_id/loans/:loan_id",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanController.DeleteLoan)
+				companies.GET("/:id/branches/:branch_id/loans/:loan_id/timeline",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanController.GetLoanTimeline)
+				companies.POST("/:id/branches/:branch_id/loans/:loan_id/submit",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanController.SubmitLoan)
+				companies.POST("/:id/branches/:branch_id/loans/:loan_id/review",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanController.ReviewLoan)
+				companies.POST("/:id/branches/:branch_id/loans/:loan_id/approve",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleManager),
+					loanController.ApproveLoan)
+				companies.POST("/:id/branches/:branch_id/loans/:loan_id/reject",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleManager),
+					loanController.RejectLoan)
+				companies.POST("/:id/branches/:branch_id/loans/:loan_id/disburse",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleManager),
+					loanController.DisburseLoan)
+				companies.POST("/:id/branches/:branch_id/loans/:loan_id/activate",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanController.ActivateLoan)
+				companies.POST("/:id/branches/:branch_id/loans/:loan_id/close",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleManager),
+					loanController.CloseLoan)
+				companies.POST("/:id/branches/:branch_id/loans/:loan_id/write-off",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleManager),
+					loanController.WriteOffLoan)
+
+				// Loan account and repayment routes; tellers take payments
+				companies.POST("/:id/branches/:branch_id/loans/:loan_id/account",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanAccountController.OpenLoanAccount)
+				companies.GET("/:id/branches/:branch_id/accounts",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanAccountController.ListLoanAccounts)
+				companies.GET("/:id/branches/:branch_id/accounts/:account_id",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanAccountController.GetLoanAccount)
+				companies.PUT("/:id/branches/:branch_id/accounts/:account_id/allocation",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleManager),
+					loanAccountController.SetLoanAccountAllocation)
+				companies.POST("/:id/branches/:branch_id/accounts/:account_id/repayments",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleManager),
+					loanAccountController.RecordRepayment)
+				companies.GET("/:id/branches/:branch_id/accounts/:account_id/repayments",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanAccountController.ListRepayments)
+				companies.GET("/:id/branches/:branch_id/accounts/:account_id/repayments/:repayment_id",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanAccountController.GetRepayment)
+				companies.GET("/:id/branches/:branch_id/accounts/:account_id/credit-allocations",
+					middleware.RequireBranchRoles(config.MongoDB, models.BranchRoleTeller, models.BranchRoleLoanOfficer, models.BranchRoleManager),
+					loanAccountController.ListCreditAllocations)
+
+				// Company document routes
+				companies.POST("/:id/documents", documentController.CreateDocument)
+				companies.GET("/:id/documents", documentController.ListDocuments)
+				companies.GET("/:id/documents/:document_id", documentController.GetDocument)
+				companies.PUT("/:id/documents/:document_id", documentController.UpdateDocument)
+				companies.DELETE("/:id/documents/:document_id", documentController.DeleteDocument)
+				companies.POST("/:id/documents/:document_id/versions", documentController.UploadDocumentVersion)
+				companies.GET("/:id/documents/:document_id/download", documentController.DownloadDocument)
+			}
+
+			// Staff transfer between branch offices and group companies
+			protected.POST("/staff/:user_id/transfer", staffController.TransferStaff)
+
+			// Branch office location routes across the caller's company
+			protected.GET("/branches/nearby", branchOfficeController.NearbyBranchOffices)
+			protected.GET("/branches/within", branchOfficeController.BranchOfficesInBounds)
+
+			// Document routes across companies
+			protected.GET("/documents/expiring", documentController.ListExpiringDocuments)
+
+			// Loan calculator routes
+			protected.POST("/calculator/schedule", calculatorController.CalculateSchedule)
+
+			// Import job routes
+			protected.GET("/imports/:job_id", importController.GetImportJob)
+
+			// Search routes
+			protected.GET("/search", searchController.Search)
+
+			// Audit log routes (super users only); entries span every company
+			auditLogs := protected.Group("/audit-logs")
+			auditLogs.Use(middleware.RequireRoles(models.RoleSuperUser))
+			{
+				auditLogs.GET("", auditController.SearchAuditLogs)
+				auditLogs.GET("/export", auditController.ExportAuditLogs)
+			}
+
+			// Background job routes (super users only); jobs run across every
+			// company
+			jobs := protected.Group("/admin/jobs")
+			jobs.Use(middleware.RequireRoles(models.RoleSuperUser))
+			{
+				jobs.GET("", schedulerController.ListJobs)
+				jobs.POST("/:name/run", schedulerController.TriggerJob)
+				jobs.GET("/:name/runs", schedulerController.ListJobRuns)
 			}
 		}
 	}
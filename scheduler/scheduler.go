@@ -0,0 +1,297 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"loan/models"
+	"loan/utils"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrLocked     = errors.New("job is already running")
+)
+
+// DefaultTimeout bounds a job run and the lease held for it
+const DefaultTimeout = 10 * time.Minute
+
+type JobFunc func(ctx context.Context) error
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	timeout  time.Duration
+	run      JobFunc
+
+	mu      sync.Mutex
+	nextRun time.Time
+}
+
+// JobInfo describes a registered job for the admin API
+type JobInfo struct {
+	Name     string         `json:"name"`
+	Schedule string         `json:"schedule"`
+	Timeout  string         `json:"timeout"`
+	NextRun  time.Time      `json:"next_run"`
+	LastRun  *models.JobRun `json:"last_run,omitempty"`
+}
+
+// Scheduler runs registered jobs on their schedules. Every replica runs a
+// scheduler; a lease in the job_locks collection makes sure only one of
+// them executes a given job at a time.
+type Scheduler struct {
+	db       *mongo.Database
+	instance string
+
+	mu   sync.RWMutex
+	jobs map[string]*job
+}
+
+func New(db *mongo.Database) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		db:       db,
+		instance: fmt.Sprintf("%s-%s", host, primitive.NewObjectID().Hex()),
+		jobs:     make(map[string]*job),
+	}
+}
+
+// Register adds a job under a unique name. Pass a zero timeout for
+// DefaultTimeout.
+func (s *Scheduler) Register(name, spec string, timeout time.Duration, fn JobFunc) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.jobs[name]; exists {
+		return fmt.Errorf("job %s is already registered", name)
+	}
+	s.jobs[name] = &job{name: name, spec: spec, schedule: schedule, timeout: timeout, run: fn}
+	return nil
+}
+
+// Start runs every registered job on its schedule until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, j := range s.jobs {
+		go s.loop(ctx, j)
+	}
+	utils.Info("Scheduler started", utils.Fields(map[string]interface{}{
+		"instance": s.instance,
+		"jobs":     len(s.jobs),
+	}))
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	for {
+		next := j.schedule.Next(time.Now())
+		if next.IsZero() {
+			utils.Warn("Job has no upcoming run, stopping", utils.Fields(map[string]interface{}{"job": j.name}))
+			return
+		}
+		j.mu.Lock()
+		j.nextRun = next
+		j.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// Another replica holding the lease is the normal case, not an error
+		if _, err := s.execute(ctx, j, next, models.JobTriggerSchedule, "", false); err != nil && err != ErrLocked {
+			utils.Error("Error running job " + j.name + ": " + err.Error())
+		}
+	}
+}
+
+// Trigger starts a job immediately in the background and returns its run
+// record. It fails with ErrLocked if the job is running anywhere.
+func (s *Scheduler) Trigger(ctx context.Context, name, triggeredBy string) (*models.JobRun, error) {
+	s.mu.RLock()
+	j, ok := s.jobs[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+
+	return s.execute(ctx, j, time.Time{}, models.JobTriggerManual, triggeredBy, true)
+}
+
+// Jobs lists registered jobs with their most recent run
+func (s *Scheduler) Jobs(ctx context.Context) ([]JobInfo, error) {
+	s.mu.RLock()
+	infos := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		next := j.nextRun
+		j.mu.Unlock()
+		if next.IsZero() {
+			next = j.schedule.Next(time.Now())
+		}
+		infos = append(infos, JobInfo{
+			Name:     j.name,
+			Schedule: j.spec,
+			Timeout:  j.timeout.String(),
+			NextRun:  next,
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(infos, func(a, b int) bool { return infos[a].Name < infos[b].Name })
+
+	for i := range infos {
+		var run models.JobRun
+		err := s.db.Collection("job_runs").FindOne(ctx,
+			bson.M{"job": infos[i].Name},
+			options.FindOne().SetSort(bson.D{{Key: "started_at", Value: -1}}),
+		).Decode(&run)
+		if err == nil {
+			infos[i].LastRun = &run
+		} else if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+
+	return infos, nil
+}
+
+// execute takes the lease, records the run and calls the job. A scheduled
+// run passes the slot it runs for; manual runs pass a zero slot. With
+// background set the job runs in its own goroutine after the lease is held.
+func (s *Scheduler) execute(ctx context.Context, j *job, slot time.Time, trigger models.JobTrigger, triggeredBy string,
+	background bool) (*models.JobRun, error) {
+	runID := primitive.NewObjectID().Hex()
+	acquired, err := s.acquire(ctx, j, runID, slot)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrLocked
+	}
+
+	run := &models.JobRun{
+		ID:          runID,
+		Job:         j.name,
+		Trigger:     trigger,
+		TriggeredBy: triggeredBy,
+		Instance:    s.instance,
+		Status:      models.JobRunRunning,
+		StartedAt:   time.Now(),
+	}
+	if _, err := s.db.Collection("job_runs").InsertOne(ctx, run); err != nil {
+		s.release(context.Background(), j, runID)
+		return nil, err
+	}
+
+	work := func() {
+		// Detached from the request so a manual trigger outlives it
+		runCtx, cancel := context.WithTimeout(context.Background(), j.timeout)
+		defer cancel()
+		defer s.release(context.Background(), j, runID)
+
+		jobErr := safeRun(runCtx, j.run)
+
+		finished := time.Now()
+		result := *run
+		result.FinishedAt = &finished
+		result.DurationMs = finished.Sub(run.StartedAt).Milliseconds()
+		result.Status = models.JobRunSucceeded
+		if jobErr != nil {
+			result.Status = models.JobRunFailed
+			result.Error = jobErr.Error()
+		}
+
+		if _, err := s.db.Collection("job_runs").ReplaceOne(context.Background(), bson.M{"_id": run.ID}, result); err != nil {
+			utils.Error("Error recording job run: " + err.Error())
+		}
+		utils.Info("Job finished", utils.Fields(map[string]interface{}{
+			"job":      j.name,
+			"status":   result.Status,
+			"duration": result.DurationMs,
+		}))
+	}
+
+	if background {
+		go work()
+	} else {
+		work()
+	}
+	return run, nil
+}
+
+// acquire takes the lease on a job for run runID. The lease is only taken
+// once the previous one has expired, even by the instance holding it, so a
+// job never runs twice at once. A scheduled run also records its slot and
+// is refused if the slot has already run, so replicas whose clocks or
+// timers lag cannot run it again. The upsert fails with a duplicate key
+// error when the lease cannot be taken.
+func (s *Scheduler) acquire(ctx context.Context, j *job, runID string, slot time.Time) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id":          j.name,
+		"locked_until": bson.M{"$lt": now},
+	}
+	set := bson.M{
+		"owner":        runID,
+		"instance":     s.instance,
+		"locked_until": now.Add(j.timeout),
+	}
+	if !slot.IsZero() {
+		filter["$or"] = bson.A{
+			bson.M{"last_slot": bson.M{"$lt": slot}},
+			bson.M{"last_slot": bson.M{"$exists": false}},
+		}
+		set["last_slot"] = slot
+	}
+
+	_, err := s.db.Collection("job_locks").UpdateOne(ctx, filter, bson.M{"$set": set}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release ends the lease taken for run runID, unless it has since expired
+// and been taken by another run
+func (s *Scheduler) release(ctx context.Context, j *job, runID string) {
+	_, err := s.db.Collection("job_locks").UpdateOne(ctx,
+		bson.M{"_id": j.name, "owner": runID},
+		bson.M{"$set": bson.M{"locked_until": time.Now()}},
+	)
+	if err != nil {
+		utils.Error("Error releasing job lock " + j.name + ": " + err.Error())
+	}
+}
+
+// safeRun keeps a panicking job from taking down the server
+func safeRun(ctx context.Context, fn JobFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
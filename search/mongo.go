@@ -0,0 +1,183 @@
+package search
+
+import (
+	"context"
+	"loan/models"
+	"regexp"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Score given to documents matched only by substring, which ranks them below
+// any whole-word text index match
+const partialMatchScore = 0.1
+
+type collectionSpec struct {
+	name string
+	// Field compared against Query.CompanyIDs
+	scopeField string
+	// Fields searched by substring, to catch partial tax IDs and phone numbers
+	partialFields []string
+	decode        func(raw bson.Raw) (interface{}, error)
+}
+
+var collectionSpecs = map[ResultType]collectionSpec{
+	TypeCompany: {
+		name:          "companies",
+		scopeField:    "_id",
+		partialFields: []string{"name", "tax_id", "email"},
+		decode:        decodeAs[models.Company],
+	},
+	TypeBranchOffice: {
+		name:          "branch_offices",
+		scopeField:    "company_id",
+		partialFields: []string{"name", "address", "phone"},
+		decode:        decodeAs[models.BranchOffice],
+	},
+	TypeUser: {
+		name:          "users",
+		scopeField:    "company_id",
+		partialFields: []string{"username", "full_name"},
+		decode:        decodeAs[models.User],
+	},
+}
+
+// MongoIndex searches using the text indexes created in config.EnsureIndexes
+type MongoIndex struct {
+	db *mongo.Database
+}
+
+func NewMongoIndex(db *mongo.Database) *MongoIndex {
+	return &MongoIndex{db: db}
+}
+
+func (m *MongoIndex) Search(ctx context.Context, query Query) (*Results, error) {
+	results := &Results{
+		Companies:     []Hit{},
+		BranchOffices: []Hit{},
+		Users:         []Hit{},
+	}
+
+	for _, t := range AllTypes {
+		if !query.wants(t) {
+			continue
+		}
+
+		hits, err := m.searchCollection(ctx, collectionSpecs[t], query)
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case TypeCompany:
+			results.Companies = hits
+		case TypeBranchOffice:
+			results.BranchOffices = hits
+		case TypeUser:
+			results.Users = hits
+		}
+	}
+
+	return results, nil
+}
+
+func (m *MongoIndex) searchCollection(ctx context.Context, spec collectionSpec, query Query) ([]Hit, error) {
+	collection := m.db.Collection(spec.name)
+	scope := bson.M{}
+	if query.CompanyIDs != nil {
+		scope[spec.scopeField] = bson.M{"$in": query.CompanyIDs}
+	}
+
+	scores := make(map[string]float64)
+	docs := make(map[string]bson.Raw)
+	order := make([]string, 0)
+
+	// Whole-word matches ranked by the text index
+	textFilter := bson.M{"$text": bson.M{"$search": query.Text}}
+	for k, v := range scope {
+		textFilter[k] = v
+	}
+	textOpts := options.Find().
+		SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetSort(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetLimit(int64(query.Limit))
+
+	if err := collectRaw(ctx, collection, textFilter, textOpts, func(raw bson.Raw) {
+		id := raw.Lookup("_id").String()
+		score, _ := raw.Lookup("score").DoubleOK()
+		scores[id] = score
+		docs[id] = raw
+		order = append(order, id)
+	}); err != nil {
+		return nil, err
+	}
+
+	// Substring matches for anything the text index cannot tokenise
+	if len(docs) < query.Limit {
+		pattern := regexp.QuoteMeta(query.Text)
+		or := bson.A{}
+		for _, field := range spec.partialFields {
+			or = append(or, bson.M{field: bson.M{"$regex": pattern, "$options": "i"}})
+		}
+		partialFilter := bson.M{"$or": or}
+		for k, v := range scope {
+			partialFilter[k] = v
+		}
+		partialOpts := options.Find().SetLimit(int64(query.Limit))
+
+		if err := collectRaw(ctx, collection, partialFilter, partialOpts, func(raw bson.Raw) {
+			id := raw.Lookup("_id").String()
+			if _, seen := docs[id]; !seen {
+				scores[id] = partialMatchScore
+				docs[id] = raw
+				order = append(order, id)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	hits := make([]Hit, 0, len(order))
+	for _, id := range order {
+		doc, err := spec.decode(docs[id])
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, Hit{Score: scores[id], Document: doc})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+	if len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+
+	return hits, nil
+}
+
+func collectRaw(ctx context.Context, collection *mongo.Collection, filter bson.M, opts *options.FindOptions, fn func(bson.Raw)) error {
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		raw := make(bson.Raw, len(cursor.Current))
+		copy(raw, cursor.Current)
+		fn(raw)
+	}
+	return cursor.Err()
+}
+
+func decodeAs[T any](raw bson.Raw) (interface{}, error) {
+	var doc T
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
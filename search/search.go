@@ -0,0 +1,56 @@
+package search
+
+import (
+	"context"
+)
+
+type ResultType string
+
+const (
+	TypeCompany      ResultType = "company"
+	TypeBranchOffice ResultType = "branch_office"
+	TypeUser         ResultType = "user"
+)
+
+// AllTypes lists every result type in the order results are returned
+var AllTypes = []ResultType{TypeCompany, TypeBranchOffice, TypeUser}
+
+type Query struct {
+	Text string
+	// CompanyIDs restricts results to a company group. Nil means no
+	// restriction, which is only allowed for super users.
+	CompanyIDs []string
+	Types      []ResultType
+	// Limit is applied per result type
+	Limit int
+}
+
+type Hit struct {
+	Score    float64     `json:"score"`
+	Document interface{} `json:"document"`
+}
+
+// Results are grouped by type and ordered by descending score
+type Results struct {
+	Companies     []Hit `json:"companies"`
+	BranchOffices []Hit `json:"branch_offices"`
+	Users         []Hit `json:"users"`
+}
+
+// Index is implemented by search backends. The Mongo text index backend is
+// the default; an external engine can be plugged in by implementing this.
+type Index interface {
+	Search(ctx context.Context, query Query) (*Results, error)
+}
+
+func (q Query) wants(t ResultType) bool {
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, want := range q.Types {
+		if want == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package servicing
+
+import (
+	"loan/models"
+	"math"
+	"strings"
+	"time"
+)
+
+// Decimals of the currencies whose smallest unit is not a hundredth, as in
+// ISO 4217
+var minorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// currencyDecimals is the number of decimals amounts in currency have
+func currencyDecimals(currency string) int {
+	if decimals, ok := minorUnits[strings.ToUpper(currency)]; ok {
+		return decimals
+	}
+	return 2
+}
+
+// money rounds amounts to the smallest unit of a currency
+type money struct {
+	scale float64
+}
+
+func moneyIn(currency string) money {
+	return money{scale: math.Pow10(currencyDecimals(currency))}
+}
+
+// round rounds x to the smallest unit, dropping float noise first
+func (m money) round(x float64) float64 {
+	return math.Round(math.Round(x*m.scale*1e6)/1e6) / m.scale
+}
+
+func (m money) amounts(a models.Amounts) models.Amounts {
+	return models.Amounts{
+		Fees:      m.round(a.Fees),
+		Penalties: m.round(a.Penalties),
+		Interest:  m.round(a.Interest),
+		Principal: m.round(a.Principal),
+	}
+}
+
+// Refresh charges the penalty on installments that became overdue by now,
+// then sets the status of every installment and the account's balances
+// and status. An installment is overdue once its due date and the late
+// payment grace days have passed with something of it unpaid.
+func Refresh(account *models.LoanAccount, now time.Time) {
+	m := moneyIn(account.Currency)
+	balances := models.Amounts{}
+	for i := range account.Installments {
+		inst := &account.Installments[i]
+		outstanding := m.round(inst.Outstanding().Total())
+		late := !now.Before(inst.DueDate.AddDate(0, 0, account.LatePaymentGraceDays+1))
+
+		if late && outstanding > 0 && !inst.PenaltyCharged && account.Allocation.PenaltyRate > 0 {
+			inst.Due.Penalties = m.round(inst.Due.Penalties + outstanding*account.Allocation.PenaltyRate/100)
+			inst.PenaltyCharged = true
+			outstanding = m.round(inst.Outstanding().Total())
+		}
+
+		switch {
+		case outstanding <= 0:
+			inst.Status = models.InstallmentPaid
+		case late:
+			inst.Status = models.InstallmentOverdue
+		case inst.Paid.Total() > 0:
+			inst.Status = models.InstallmentPartial
+		default:
+			inst.Status = models.InstallmentDue
+		}
+
+		balances = balances.Plus(inst.Outstanding())
+	}
+
+	account.Balances = m.amounts(balances)
+	account.Status = models.LoanAccountActive
+	if m.round(account.Balances.Total()) <= 0 {
+		account.Status = models.LoanAccountPaidOff
+	}
+}
+
+// Allocate allocates amount, together with any credit the account has, to
+// its installments and returns how it was allocated. Installments that
+// have fallen due by now are paid oldest first, each bucket in the order
+// of the account's waterfall. What is left pays the coming installments
+// as scheduled when the account takes overpayments in advance, and is
+// otherwise kept as credit. Only the allocation fields of the returned
+// repayment are set.
+func Allocate(account *models.LoanAccount, amount float64, now time.Time) models.Repayment {
+	Refresh(account, now)
+	m := moneyIn(account.Currency)
+
+	repayment := models.Repayment{
+		Amount:       m.round(amount),
+		CreditUsed:   account.Credit,
+		Installments: []models.InstallmentAllocation{},
+	}
+	available := m.round(amount + account.Credit)
+	account.Credit = 0
+
+	for i := range account.Installments {
+		if account.Installments[i].DueDate.After(now) {
+			break
+		}
+		available = pay(account, i, available, &repayment.Allocated, &repayment.Installments, now)
+	}
+	if account.Allocation.Overpayment != models.OverpayCredit {
+		for i := range account.Installments {
+			if available <= 0 {
+				break
+			}
+			available = pay(account, i, available, &repayment.Prepaid, &repayment.Installments, now)
+		}
+	}
+
+	repayment.Credited = available
+	account.Credit = available
+	repayment.Allocated = m.amounts(repayment.Allocated)
+	repayment.Prepaid = m.amounts(repayment.Prepaid)
+
+	Refresh(account, now)
+	return repayment
+}
+
+// ApplyCredit allocates the account's credit to installments that have
+// fallen due by now and returns how it was allocated; its Amount is 0 when
+// no credit was used. Only the allocation fields are set.
+func ApplyCredit(account *models.LoanAccount, now time.Time) models.CreditAllocation {
+	allocation := models.CreditAllocation{Installments: []models.InstallmentAllocation{}}
+	if account.Credit <= 0 {
+		return allocation
+	}
+
+	m := moneyIn(account.Currency)
+	available := account.Credit
+	for i := range account.Installments {
+		if account.Installments[i].DueDate.After(now) {
+			break
+		}
+		available = pay(account, i, available, &allocation.Allocated, &allocation.Installments, now)
+	}
+	allocation.Amount = m.round(account.Credit - available)
+	allocation.Allocated = m.amounts(allocation.Allocated)
+	account.Credit = available
+	return allocation
+}
+
+// pay allocates up to available to what is owed of installment i in
+// waterfall order, adding what it paid to total and to installments. It
+// returns what is left.
+func pay(account *models.LoanAccount, i int, available float64, total *models.Amounts,
+	installments *[]models.InstallmentAllocation, now time.Time) float64 {
+	m := moneyIn(account.Currency)
+	inst := &account.Installments[i]
+	outstanding := inst.Outstanding()
+	if available <= 0 || m.round(outstanding.Total()) <= 0 {
+		return available
+	}
+
+	waterfall := account.Allocation.Waterfall
+	if len(waterfall) == 0 {
+		waterfall = models.DefaultWaterfall
+	}
+
+	var paid models.Amounts
+	for _, bucket := range waterfall {
+		amount := math.Min(m.round(outstanding.Get(bucket)), available)
+		if amount <= 0 {
+			continue
+		}
+		paid.Add(bucket, amount)
+		available = m.round(available - amount)
+	}
+
+	inst.Paid = m.amounts(inst.Paid.Plus(paid))
+	if m.round(inst.Outstanding().Total()) <= 0 {
+		inst.PaidAt = &now
+	}
+
+	*total = total.Plus(paid)
+	*installments = append(*installments, models.InstallmentAllocation{
+		Number:  inst.Number,
+		Amounts: m.amounts(paid),
+	})
+	return available
+}
@@ -0,0 +1,370 @@
+package servicing
+
+import (
+	"loan/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// testAccount owes 115 on the 1st of January, February and March 2026:
+// fees of 5, interest of 10 and principal of 100. Installments are late
+// three days after they fall due and charged a penalty of 5%.
+func testAccount(settings models.AllocationSettings) models.LoanAccount {
+	if settings.Overpayment == "" {
+		settings.Overpayment = models.OverpayCredit
+	}
+	settings.PenaltyRate = 5
+	account := models.LoanAccount{
+		Currency:             "USD",
+		Principal:            300,
+		Allocation:           settings,
+		LatePaymentGraceDays: 3,
+	}
+	for i, due := range []string{"2026-01-01", "2026-02-01", "2026-03-01"} {
+		account.Installments = append(account.Installments, models.AccountInstallment{
+			Number:  i + 1,
+			DueDate: date(due),
+			Due:     models.Amounts{Fees: 5, Interest: 10, Principal: 100},
+		})
+	}
+	return account
+}
+
+func statuses(account models.LoanAccount) []models.InstallmentStatus {
+	var s []models.InstallmentStatus
+	for _, inst := range account.Installments {
+		s = append(s, inst.Status)
+	}
+	return s
+}
+
+func TestRefresh(t *testing.T) {
+	due, partial, paid, overdue := models.InstallmentDue, models.InstallmentPartial, models.InstallmentPaid,
+		models.InstallmentOverdue
+	tests := []struct {
+		name      string
+		paid      []models.Amounts
+		now       string
+		statuses  []models.InstallmentStatus
+		penalties float64
+		balance   float64
+		status    models.LoanAccountStatus
+	}{
+		{"nothing due yet", nil, "2025-12-31", []models.InstallmentStatus{due, due, due}, 0, 345, models.LoanAccountActive},
+		{"due within the grace days", nil, "2026-01-04", []models.InstallmentStatus{due, due, due}, 0, 345, models.LoanAccountActive},
+		{"late after the grace days", nil, "2026-01-05", []models.InstallmentStatus{overdue, due, due}, 5.75, 350.75, models.LoanAccountActive},
+		{"partly paid", []models.Amounts{{Fees: 5}}, "2026-01-02",
+			[]models.InstallmentStatus{partial, due, due}, 0, 340, models.LoanAccountActive},
+		{"partly paid and late", []models.Amounts{{Fees: 5, Interest: 10}}, "2026-01-05",
+			[]models.InstallmentStatus{overdue, due, due}, 5, 335, models.LoanAccountActive},
+		{"paid on time", []models.Amounts{{Fees: 5, Interest: 10, Principal: 100}}, "2026-01-05",
+			[]models.InstallmentStatus{paid, due, due}, 0, 230, models.LoanAccountActive},
+		{"paid off", []models.Amounts{
+			{Fees: 5, Interest: 10, Principal: 100},
+			{Fees: 5, Interest: 10, Principal: 100},
+			{Fees: 5, Interest: 10, Principal: 100},
+		}, "2026-01-05", []models.InstallmentStatus{paid, paid, paid}, 0, 0, models.LoanAccountPaidOff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := testAccount(models.AllocationSettings{})
+			for i, p := range tt.paid {
+				account.Installments[i].Paid = p
+			}
+
+			Refresh(&account, date(tt.now))
+			if got := statuses(account); !reflect.DeepEqual(got, tt.statuses) {
+				t.Errorf("statuses = %v, want %v", got, tt.statuses)
+			}
+			if account.Balances.Penalties != tt.penalties {
+				t.Errorf("penalties = %v, want %v", account.Balances.Penalties, tt.penalties)
+			}
+			if got := account.Balances.Total(); got != tt.balance {
+				t.Errorf("balance = %v, want %v", got, tt.balance)
+			}
+			if account.Status != tt.status {
+				t.Errorf("status = %v, want %v", account.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestRefreshChargesThePenaltyOnce(t *testing.T) {
+	account := testAccount(models.AllocationSettings{})
+	Refresh(&account, date("2026-01-05"))
+	Refresh(&account, date("2026-01-20"))
+	Refresh(&account, date("2026-01-31"))
+
+	inst := account.Installments[0]
+	if !inst.PenaltyCharged || inst.Due.Penalties != 5.75 {
+		t.Fatalf("penalty = %v (charged %v), want 5.75 charged once", inst.Due.Penalties, inst.PenaltyCharged)
+	}
+}
+
+func TestRefreshWithoutPenaltyRate(t *testing.T) {
+	account := testAccount(models.AllocationSettings{})
+	account.Allocation.PenaltyRate = 0
+	Refresh(&account, date("2026-01-05"))
+
+	inst := account.Installments[0]
+	if inst.Status != models.InstallmentOverdue || inst.PenaltyCharged || inst.Due.Penalties != 0 {
+		t.Fatalf("installment = %+v, want overdue without a penalty", inst)
+	}
+}
+
+// The first installment is overdue with a penalty of 5.75 when 12 is paid
+func TestAllocateWaterfall(t *testing.T) {
+	tests := []struct {
+		name      string
+		waterfall []models.AllocationBucket
+		want      models.Amounts
+	}{
+		{"default", nil, models.Amounts{Fees: 5, Penalties: 5.75, Interest: 1.25}},
+		{"fees first", models.DefaultWaterfall, models.Amounts{Fees: 5, Penalties: 5.75, Interest: 1.25}},
+		{"interest first",
+			[]models.AllocationBucket{models.BucketInterest, models.BucketFees, models.BucketPenalties, models.BucketPrincipal},
+			models.Amounts{Fees: 2, Interest: 10}},
+		{"penalties first",
+			[]models.AllocationBucket{models.BucketPenalties, models.BucketPrincipal, models.BucketInterest, models.BucketFees},
+			models.Amounts{Penalties: 5.75, Principal: 6.25}},
+		{"principal first",
+			[]models.AllocationBucket{models.BucketPrincipal, models.BucketInterest, models.BucketFees, models.BucketPenalties},
+			models.Amounts{Principal: 12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := testAccount(models.AllocationSettings{Waterfall: tt.waterfall})
+			repayment := Allocate(&account, 12, date("2026-01-10"))
+
+			if repayment.Allocated != tt.want {
+				t.Errorf("allocated = %+v, want %+v", repayment.Allocated, tt.want)
+			}
+			want := []models.InstallmentAllocation{{Number: 1, Amounts: tt.want}}
+			if !reflect.DeepEqual(repayment.Installments, want) {
+				t.Errorf("installments = %+v, want %+v", repayment.Installments, want)
+			}
+			if repayment.Credited != 0 || account.Credit != 0 {
+				t.Errorf("credited %v, want nothing", repayment.Credited)
+			}
+			if account.Installments[0].Status != models.InstallmentOverdue {
+				t.Errorf("status = %v, want overdue", account.Installments[0].Status)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	due, partial, paid := models.InstallmentDue, models.InstallmentPartial, models.InstallmentPaid
+	tests := []struct {
+		name        string
+		overpayment models.OverpaymentMode
+		credit      float64
+		amount      float64
+		now         string
+		allocated   models.Amounts
+		prepaid     models.Amounts
+		credited    float64
+		statuses    []models.InstallmentStatus
+		status      models.LoanAccountStatus
+	}{
+		{
+			name: "partial payment", overpayment: models.OverpayCredit, amount: 50, now: "2026-01-01",
+			allocated: models.Amounts{Fees: 5, Interest: 10, Principal: 35},
+			statuses:  []models.InstallmentStatus{partial, due, due}, status: models.LoanAccountActive,
+		},
+		{
+			name: "exact payment", overpayment: models.OverpayCredit, amount: 115, now: "2026-01-01",
+			allocated: models.Amounts{Fees: 5, Interest: 10, Principal: 100},
+			statuses:  []models.InstallmentStatus{paid, due, due}, status: models.LoanAccountActive,
+		},
+		{
+			name: "overpayment kept as credit", overpayment: models.OverpayCredit, amount: 200, now: "2026-01-01",
+			allocated: models.Amounts{Fees: 5, Interest: 10, Principal: 100}, credited: 85,
+			statuses: []models.InstallmentStatus{paid, due, due}, status: models.LoanAccountActive,
+		},
+		{
+			name: "overpayment paid in advance", overpayment: models.OverpayAdvance, amount: 200, now: "2026-01-01",
+			allocated: models.Amounts{Fees: 5, Interest: 10, Principal: 100},
+			prepaid:   models.Amounts{Fees: 5, Interest: 10, Principal: 70},
+			statuses:  []models.InstallmentStatus{paid, partial, due}, status: models.LoanAccountActive,
+		},
+		{
+			name: "overpayment in advance beyond what is owed", overpayment: models.OverpayAdvance, amount: 400,
+			now:       "2026-01-01",
+			allocated: models.Amounts{Fees: 5, Interest: 10, Principal: 100},
+			prepaid:   models.Amounts{Fees: 10, Interest: 20, Principal: 200}, credited: 55,
+			statuses: []models.InstallmentStatus{paid, paid, paid}, status: models.LoanAccountPaidOff,
+		},
+		{
+			name: "credit is used with the payment", overpayment: models.OverpayCredit, credit: 85, amount: 30,
+			now:       "2026-01-01",
+			allocated: models.Amounts{Fees: 5, Interest: 10, Principal: 100},
+			statuses:  []models.InstallmentStatus{paid, due, due}, status: models.LoanAccountActive,
+		},
+		{
+			name: "nothing due yet kept as credit", overpayment: models.OverpayCredit, amount: 50, now: "2025-12-31",
+			credited: 50,
+			statuses: []models.InstallmentStatus{due, due, due}, status: models.LoanAccountActive,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := testAccount(models.AllocationSettings{Overpayment: tt.overpayment})
+			account.Credit = tt.credit
+			repayment := Allocate(&account, tt.amount, date(tt.now))
+
+			if repayment.Amount != tt.amount || repayment.CreditUsed != tt.credit {
+				t.Errorf("amount %v with credit %v, want %v with %v", repayment.Amount, repayment.CreditUsed,
+					tt.amount, tt.credit)
+			}
+			if repayment.Allocated != tt.allocated {
+				t.Errorf("allocated = %+v, want %+v", repayment.Allocated, tt.allocated)
+			}
+			if repayment.Prepaid != tt.prepaid {
+				t.Errorf("prepaid = %+v, want %+v", repayment.Prepaid, tt.prepaid)
+			}
+			if repayment.Credited != tt.credited || account.Credit != tt.credited {
+				t.Errorf("credited %v leaving credit %v, want %v", repayment.Credited, account.Credit, tt.credited)
+			}
+			if got := statuses(account); !reflect.DeepEqual(got, tt.statuses) {
+				t.Errorf("statuses = %v, want %v", got, tt.statuses)
+			}
+			if account.Status != tt.status {
+				t.Errorf("status = %v, want %v", account.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestApplyCredit(t *testing.T) {
+	tests := []struct {
+		name         string
+		credit       float64
+		now          string
+		amount       float64
+		installments []models.InstallmentAllocation
+		left         float64
+	}{
+		{"no credit", 0, "2026-02-01", 0, []models.InstallmentAllocation{}, 0},
+		{"nothing due yet", 50, "2025-12-31", 0, []models.InstallmentAllocation{}, 50},
+		{"less than is due", 50, "2026-01-01", 50, []models.InstallmentAllocation{
+			{Number: 1, Amounts: models.Amounts{Fees: 5, Interest: 10, Principal: 35}},
+		}, 0},
+		{"more than is due", 250, "2026-02-01", 230, []models.InstallmentAllocation{
+			{Number: 1, Amounts: models.Amounts{Fees: 5, Interest: 10, Principal: 100}},
+			{Number: 2, Amounts: models.Amounts{Fees: 5, Interest: 10, Principal: 100}},
+		}, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := testAccount(models.AllocationSettings{})
+			account.Credit = tt.credit
+			allocation := ApplyCredit(&account, date(tt.now))
+
+			if allocation.Amount != tt.amount {
+				t.Errorf("amount = %v, want %v", allocation.Amount, tt.amount)
+			}
+			if !reflect.DeepEqual(allocation.Installments, tt.installments) {
+				t.Errorf("installments = %+v, want %+v", allocation.Installments, tt.installments)
+			}
+			var allocated models.Amounts
+			for _, inst := range tt.installments {
+				allocated = allocated.Plus(inst.Amounts)
+			}
+			if allocation.Allocated != allocated {
+				t.Errorf("allocated = %+v, want %+v", allocation.Allocated, allocated)
+			}
+			if account.Credit != tt.left {
+				t.Errorf("credit left = %v, want %v", account.Credit, tt.left)
+			}
+		})
+	}
+}
+
+func TestPay(t *testing.T) {
+	now := date("2026-01-01")
+	tests := []struct {
+		name      string
+		paid      models.Amounts
+		available float64
+		want      models.Amounts
+		left      float64
+		settled   bool // Whether pay marks the installment paid
+	}{
+		{"part", models.Amounts{}, 12, models.Amounts{Fees: 5, Interest: 7}, 0, false},
+		{"the rest", models.Amounts{Fees: 5, Interest: 7}, 103, models.Amounts{Interest: 3, Principal: 100}, 0, true},
+		{"more than is owed", models.Amounts{}, 120, models.Amounts{Fees: 5, Interest: 10, Principal: 100}, 5, true},
+		{"nothing available", models.Amounts{}, 0, models.Amounts{}, 0, false},
+		{"already paid", models.Amounts{Fees: 5, Interest: 10, Principal: 100}, 20, models.Amounts{}, 20, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := testAccount(models.AllocationSettings{})
+			account.Installments[0].Paid = tt.paid
+			var total models.Amounts
+			installments := []models.InstallmentAllocation{}
+
+			left := pay(&account, 0, tt.available, &total, &installments, now)
+			if left != tt.left {
+				t.Errorf("left = %v, want %v", left, tt.left)
+			}
+			if total != tt.want {
+				t.Errorf("paid = %+v, want %+v", total, tt.want)
+			}
+			if allocations := len(installments); (total != models.Amounts{}) != (allocations == 1) {
+				t.Errorf("%d installment allocations for %+v paid", allocations, total)
+			}
+			if got := account.Installments[0].Paid; got != tt.paid.Plus(tt.want) {
+				t.Errorf("installment paid = %+v, want %+v", got, tt.paid.Plus(tt.want))
+			}
+			if settled := account.Installments[0].PaidAt != nil; settled != tt.settled {
+				t.Errorf("paid at set = %v, want %v", settled, tt.settled)
+			}
+		})
+	}
+}
+
+func TestMoneyRoundsToTheCurrencysSmallestUnit(t *testing.T) {
+	tests := []struct {
+		currency string
+		in, want float64
+	}{
+		{"USD", 10.005, 10.01},
+		{"usd", 0.1 + 0.2, 0.3},
+		{"JPY", 100.5, 101},
+		{"KRW", 99.4, 99},
+		{"KWD", 1.2345, 1.235},
+		{"BHD", 0.0004, 0},
+		{"XYZ", 1.234, 1.23},
+	}
+	for _, tt := range tests {
+		if got := moneyIn(tt.currency).round(tt.in); got != tt.want {
+			t.Errorf("%s: round(%v) = %v, want %v", tt.currency, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAllocateInWholeYen(t *testing.T) {
+	account := testAccount(models.AllocationSettings{})
+	account.Currency = "JPY"
+	account.Allocation.PenaltyRate = 3
+	repayment := Allocate(&account, 20.4, date("2026-01-05"))
+
+	// The penalty is 3% of 115, 3.45, charged as 3
+	if account.Installments[0].Due.Penalties != 3 {
+		t.Errorf("penalty = %v, want 3", account.Installments[0].Due.Penalties)
+	}
+	want := models.Amounts{Fees: 5, Penalties: 3, Interest: 10, Principal: 2}
+	if repayment.Amount != 20 || repayment.Allocated != want {
+		t.Errorf("allocated %v as %+v, want 20 as %+v", repayment.Amount, repayment.Allocated, want)
+	}
+}
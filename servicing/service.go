@@ -0,0 +1,331 @@
+// Package servicing keeps the accounts of active loans: their installments,
+// what has been paid of them, and the repayments that paid it.
+package servicing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"loan/loancalc"
+	"loan/models"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrLoanNotActive  = errors.New("only active loans have an account")
+	ErrAccountExists  = errors.New("loan already has an account")
+	ErrAccountPaidOff = errors.New("loan account is paid off")
+	ErrStaleAccount   = errors.New("loan account was changed by another payment, try again")
+	ErrUnschedulable  = errors.New("loan cannot be scheduled under its product's terms")
+)
+
+// Service opens loan accounts and records repayments on them. Every change
+// to an account is a single conditional write of the whole account, so its
+// installment statuses and balances always change together.
+type Service struct {
+	db *mongo.Database
+}
+
+func NewService(db *mongo.Database) *Service {
+	return &Service{db: db}
+}
+
+// Open opens the account of an active loan, with installments drawn up from
+// terms, the product version it was issued against. The first installment
+// falls due one period after the loan was disbursed. Penalties start when
+// the account is opened: installments of a loan disbursed in the past that
+// are already late are shown as overdue but never penalized.
+func (s *Service) Open(ctx context.Context, loan models.LoanApplication, terms models.LoanProductTerms,
+	settings models.AllocationSettings, by string) (models.LoanAccount, error) {
+	if loan.Status != models.LoanActive {
+		return models.LoanAccount{}, ErrLoanNotActive
+	}
+
+	now := time.Now()
+	disbursed := now
+	for _, t := range loan.Transitions {
+		if t.To == models.LoanDisbursed {
+			disbursed = t.At
+		}
+	}
+
+	// Amounts are rounded to the smallest unit of the loan's currency
+	decimals := currencyDecimals(loan.Currency)
+	schedule, err := loancalc.Generate(models.ScheduleRequest{
+		Principal:          loan.Principal,
+		InterestRate:       terms.InterestRate,
+		InterestMethod:     terms.InterestMethod,
+		RepaymentFrequency: terms.RepaymentFrequency,
+		Installments:       loan.Term,
+		StartDate:          disbursed.UTC().Format("2006-01-02"),
+		GracePeriods:       terms.GracePeriods,
+		Rounding:           models.Rounding{Decimals: &decimals},
+	})
+	if err != nil {
+		return models.LoanAccount{}, fmt.Errorf("%w: %v", ErrUnschedulable, err)
+	}
+
+	// Fees charged with every installment, as a fixed amount or a
+	// percentage of the principal
+	var fee float64
+	for _, f := range terms.Fees {
+		if f.ChargedOn != "installment" {
+			continue
+		}
+		if f.Calculation == "percentage" {
+			fee += loan.Principal * f.Amount / 100
+		} else {
+			fee += f.Amount
+		}
+	}
+	fee = moneyIn(loan.Currency).round(fee)
+
+	if len(settings.Waterfall) == 0 {
+		settings.Waterfall = models.DefaultWaterfall
+	}
+	if settings.Overpayment == "" {
+		settings.Overpayment = models.OverpayAdvance
+	}
+
+	account := models.LoanAccount{
+		ID:                   primitive.NewObjectID().Hex(),
+		CompanyID:            loan.CompanyID,
+		BranchID:             loan.BranchID,
+		LoanID:               loan.ID,
+		CustomerID:           loan.CustomerID,
+		Currency:             loan.Currency,
+		Principal:            loan.Principal,
+		Installments:         make([]models.AccountInstallment, 0, len(schedule.Installments)),
+		Allocation:           settings,
+		LatePaymentGraceDays: terms.LatePaymentGraceDays,
+		CreatedBy:            by,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}
+	for _, inst := range schedule.Installments {
+		dueDate, err := time.Parse("2006-01-02", inst.DueDate)
+		if err != nil {
+			return models.LoanAccount{}, err
+		}
+		account.Installments = append(account.Installments, models.AccountInstallment{
+			Number:  inst.Number,
+			DueDate: dueDate,
+			Due: models.Amounts{
+				Fees:      fee,
+				Interest:  inst.Interest,
+				Principal: inst.Principal,
+			},
+			PenaltyCharged: !now.Before(dueDate.AddDate(0, 0, terms.LatePaymentGraceDays+1)),
+		})
+	}
+	Refresh(&account, now)
+
+	_, err = s.db.Collection("loan_accounts").InsertOne(ctx, account)
+	if mongo.IsDuplicateKeyError(err) {
+		return account, ErrAccountExists
+	}
+	return account, err
+}
+
+// Repay records a payment on account and allocates it. The receipt number
+// is taken, the payment stored under it and the account written in one
+// transaction, so a receipt is never left without its allocation and no
+// number is used up by a payment that was not recorded; if the account
+// changed since it was read, nothing is written and ErrStaleAccount is
+// returned.
+func (s *Service) Repay(ctx context.Context, account models.LoanAccount, req models.RepaymentRequest,
+	by string) (models.Repayment, models.LoanAccount, error) {
+	if account.Status == models.LoanAccountPaidOff {
+		return models.Repayment{}, account, ErrAccountPaidOff
+	}
+
+	now := time.Now()
+	updated := account
+	updated.Installments = append([]models.AccountInstallment{}, account.Installments...)
+	repayment := Allocate(&updated, req.Amount, now)
+
+	repayment.ID = primitive.NewObjectID().Hex()
+	repayment.CompanyID = account.CompanyID
+	repayment.BranchID = account.BranchID
+	repayment.AccountID = account.ID
+	repayment.LoanID = account.LoanID
+	repayment.Method = req.Method
+	repayment.Reference = req.Reference
+	repayment.Notes = req.Notes
+	repayment.ReceivedBy = by
+	repayment.CreatedAt = now
+
+	updated.UpdatedAt = now
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return repayment, account, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		receipt, err := s.nextReceiptNumber(sc, account.CompanyID, now)
+		if err != nil {
+			return nil, err
+		}
+		repayment.ReceiptNumber = receipt
+		if _, err := s.db.Collection("repayments").InsertOne(sc, repayment); err != nil {
+			return nil, err
+		}
+		ok, err := s.save(sc, account, updated)
+		if err == nil && !ok {
+			err = ErrStaleAccount
+		}
+		return nil, err
+	})
+	if err != nil {
+		return repayment, account, err
+	}
+	return repayment, updated, nil
+}
+
+// SetAllocation changes how account's future payments are allocated
+func (s *Service) SetAllocation(ctx context.Context, account models.LoanAccount,
+	settings models.AllocationSettings) (models.LoanAccount, error) {
+	if len(settings.Waterfall) == 0 {
+		settings.Waterfall = models.DefaultWaterfall
+	}
+	if settings.Overpayment == "" {
+		settings.Overpayment = models.OverpayAdvance
+	}
+
+	updated := account
+	updated.Allocation = settings
+	updated.UpdatedAt = time.Now()
+	ok, err := s.save(ctx, account, updated)
+	if err == nil && !ok {
+		err = ErrStaleAccount
+	}
+	return updated, err
+}
+
+// ApplyDue brings the accounts with installments that have fallen due up
+// to date: credit goes to those installments, overdue ones are marked and
+// charged their penalty. Credit used is recorded as a credit allocation in
+// the same transaction as the account. An account a payment is being
+// recorded on is skipped and caught up on the next run. It returns the
+// number of accounts changed.
+func (s *Service) ApplyDue(ctx context.Context, now time.Time) (int, error) {
+	cursor, err := s.db.Collection("loan_accounts").Find(ctx, bson.M{
+		"status": models.LoanAccountActive,
+		"installments": bson.M{"$elemMatch": bson.M{
+			"status":   bson.M{"$in": bson.A{models.InstallmentDue, models.InstallmentPartial, models.InstallmentOverdue}},
+			"due_date": bson.M{"$lte": now},
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	changed := 0
+	for cursor.Next(ctx) {
+		var account models.LoanAccount
+		if err := cursor.Decode(&account); err != nil {
+			return changed, err
+		}
+
+		updated := account
+		updated.Installments = append([]models.AccountInstallment{}, account.Installments...)
+		allocation := ApplyCredit(&updated, now)
+		Refresh(&updated, now)
+		if reflect.DeepEqual(updated, account) {
+			continue
+		}
+
+		updated.UpdatedAt = now
+		var ok bool
+		if allocation.Amount > 0 {
+			allocation.ID = primitive.NewObjectID().Hex()
+			allocation.CompanyID = account.CompanyID
+			allocation.BranchID = account.BranchID
+			allocation.AccountID = account.ID
+			allocation.LoanID = account.LoanID
+			allocation.CreatedAt = now
+			ok, err = s.saveWithCredit(ctx, account, updated, allocation)
+		} else {
+			ok, err = s.save(ctx, account, updated)
+		}
+		if err != nil {
+			return changed, err
+		}
+		if ok {
+			changed++
+		}
+	}
+	return changed, cursor.Err()
+}
+
+// save writes updated over before, provided the account is unchanged since
+// before was read. It reports whether it was written.
+func (s *Service) save(ctx context.Context, before, updated models.LoanAccount) (bool, error) {
+	result, err := s.db.Collection("loan_accounts").UpdateOne(ctx,
+		bson.M{"_id": before.ID, "updated_at": before.UpdatedAt},
+		bson.M{"$set": bson.M{
+			"installments": updated.Installments,
+			"balances":     updated.Balances,
+			"credit":       updated.Credit,
+			"allocation":   updated.Allocation,
+			"status":       updated.Status,
+			"updated_at":   updated.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// saveWithCredit saves updated like save, storing allocation in the same
+// transaction
+func (s *Service) saveWithCredit(ctx context.Context, before, updated models.LoanAccount,
+	allocation models.CreditAllocation) (bool, error) {
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return false, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if _, err := s.db.Collection("credit_allocations").InsertOne(sc, allocation); err != nil {
+			return nil, err
+		}
+		ok, err := s.save(sc, before, updated)
+		if err == nil && !ok {
+			err = ErrStaleAccount
+		}
+		return nil, err
+	})
+	if errors.Is(err, ErrStaleAccount) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// nextReceiptNumber numbers a company's receipts from 1 each year, as in
+// RC2026-000042
+func (s *Service) nextReceiptNumber(ctx context.Context, companyID string, at time.Time) (string, error) {
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := s.db.Collection("receipt_counters").FindOneAndUpdate(ctx,
+		bson.M{"_id": fmt.Sprintf("%s:%d", companyID, at.Year())},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("RC%d-%06d", at.Year(), counter.Seq), nil
+}
@@ -0,0 +1,360 @@
+package staffing
+
+import (
+	"context"
+	"errors"
+	"loan/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNotStaff           = errors.New("user is not a staff member of the company")
+	ErrInvalidPeriod      = errors.New("effective_to must be after effective_from")
+	ErrOverlap            = errors.New("staff member already has an assignment at this branch office in that period")
+	ErrAssignmentNotFound = errors.New("assignment not found")
+	ErrAssignmentFinished = errors.New("assignment has already ended")
+)
+
+// Service keeps the assignment periods in staff_assignments in step with
+// the branch roles on each user. Every change to a user's branch roles
+// should go through it, whether made directly or scheduled ahead.
+type Service struct {
+	db *mongo.Database
+}
+
+func NewService(db *mongo.Database) *Service {
+	return &Service{db: db}
+}
+
+// RecordChange records a change made directly to a user's branch roles:
+// periods for roles the user lost or that changed end at, and periods for
+// the new roles start at it
+func (s *Service) RecordChange(ctx context.Context, before, after models.User, by string, at time.Time) error {
+	beforeRoles := roleMap(before)
+	afterRoles := roleMap(after)
+
+	for branchID, role := range beforeRoles {
+		if afterRoles[branchID] != role {
+			if err := s.endActive(ctx, after.ID, branchID, by, at); err != nil {
+				return err
+			}
+		}
+	}
+	for branchID, role := range afterRoles {
+		if beforeRoles[branchID] != role {
+			if err := s.insertActive(ctx, after.ID, after.CompanyID, branchID, role, by, at); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Schedule adds an assignment of a staff member to a branch office. One
+// that starts in the past starts now. Scheduled assignments may not overlap
+// each other or temporary cover already running for the same user and
+// branch; a permanent role held there is simply replaced while the new one
+// lasts.
+func (s *Service) Schedule(ctx context.Context, companyID, branchID string, req models.ScheduleAssignmentRequest,
+	by string) (models.AssignmentPeriod, error) {
+	now := time.Now()
+	from := req.EffectiveFrom
+	if from.Before(now) {
+		from = now
+	}
+	if req.EffectiveTo != nil && !req.EffectiveTo.After(from) {
+		return models.AssignmentPeriod{}, ErrInvalidPeriod
+	}
+
+	var user models.User
+	err := s.db.Collection("users").FindOne(ctx, bson.M{"_id": req.UserID}).Decode(&user)
+	if err == mongo.ErrNoDocuments || (err == nil && (!user.IsStaff() || user.CompanyID != companyID)) {
+		return models.AssignmentPeriod{}, ErrNotStaff
+	}
+	if err != nil {
+		return models.AssignmentPeriod{}, err
+	}
+
+	overlap := bson.M{
+		"user_id":   req.UserID,
+		"branch_id": branchID,
+		"status":    bson.M{"$in": bson.A{models.AssignmentScheduled, models.AssignmentActive}},
+		"$nor": bson.A{
+			bson.M{"status": models.AssignmentActive, "effective_to": nil},
+		},
+		"$or": bson.A{
+			bson.M{"effective_to": nil},
+			bson.M{"effective_to": bson.M{"$gt": from}},
+		},
+	}
+	if req.EffectiveTo != nil {
+		overlap["effective_from"] = bson.M{"$lt": *req.EffectiveTo}
+	}
+	count, err := s.db.Collection("staff_assignments").CountDocuments(ctx, overlap)
+	if err != nil {
+		return models.AssignmentPeriod{}, err
+	}
+	if count > 0 {
+		return models.AssignmentPeriod{}, ErrOverlap
+	}
+
+	period := models.AssignmentPeriod{
+		ID:            primitive.NewObjectID().Hex(),
+		UserID:        req.UserID,
+		CompanyID:     companyID,
+		BranchID:      branchID,
+		Role:          req.Role,
+		EffectiveFrom: from,
+		EffectiveTo:   req.EffectiveTo,
+		Status:        models.AssignmentScheduled,
+		AssignedBy:    by,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if _, err := s.db.Collection("staff_assignments").InsertOne(ctx, period); err != nil {
+		return models.AssignmentPeriod{}, err
+	}
+
+	if !from.After(now) {
+		if err := s.start(ctx, period); err != nil {
+			return models.AssignmentPeriod{}, err
+		}
+	}
+	return s.find(ctx, bson.M{"_id": period.ID})
+}
+
+// Cancel withdraws an assignment at a branch office that has not started
+// yet, or ends one that is running now
+func (s *Service) Cancel(ctx context.Context, companyID, branchID, id, by string) (models.AssignmentPeriod, error) {
+	period, err := s.find(ctx, bson.M{"_id": id, "company_id": companyID, "branch_id": branchID})
+	if err != nil {
+		return period, err
+	}
+
+	switch period.Status {
+	case models.AssignmentScheduled:
+		_, err = s.db.Collection("staff_assignments").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+			"status":     models.AssignmentCancelled,
+			"ended_by":   by,
+			"updated_at": time.Now(),
+		}})
+	case models.AssignmentActive:
+		err = s.end(ctx, period, by, time.Now())
+	default:
+		return period, ErrAssignmentFinished
+	}
+	if err != nil {
+		return period, err
+	}
+	return s.find(ctx, bson.M{"_id": id})
+}
+
+// ApplyDue ends temporary cover whose time is up and starts scheduled
+// assignments whose time has come, each at the time it was planned for.
+// Cover ending is handled first so that back-to-back cover hands over
+// cleanly, and once more afterwards for cover that started and finished
+// while nothing was running.
+func (s *Service) ApplyDue(ctx context.Context, now time.Time) (started, ended int, err error) {
+	n, err := s.endDue(ctx, now)
+	ended += n
+	if err != nil {
+		return started, ended, err
+	}
+
+	var due []models.AssignmentPeriod
+	cursor, err := s.db.Collection("staff_assignments").Find(ctx, bson.M{
+		"status":         models.AssignmentScheduled,
+		"effective_from": bson.M{"$lte": now},
+	}, options.Find().SetSort(bson.D{{Key: "effective_from", Value: 1}}))
+	if err != nil {
+		return started, ended, err
+	}
+	if err := cursor.All(ctx, &due); err != nil {
+		return started, ended, err
+	}
+	for _, period := range due {
+		if err := s.start(ctx, period); err != nil {
+			return started, ended, err
+		}
+		started++
+	}
+
+	n, err = s.endDue(ctx, now)
+	ended += n
+	return started, ended, err
+}
+
+func (s *Service) endDue(ctx context.Context, now time.Time) (int, error) {
+	var due []models.AssignmentPeriod
+	cursor, err := s.db.Collection("staff_assignments").Find(ctx, bson.M{
+		"status":       models.AssignmentActive,
+		"effective_to": bson.M{"$lte": now},
+	}, options.Find().SetSort(bson.D{{Key: "effective_to", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	if err := cursor.All(ctx, &due); err != nil {
+		return 0, err
+	}
+
+	for i, period := range due {
+		if err := s.end(ctx, period, "", *period.EffectiveTo); err != nil {
+			return i, err
+		}
+	}
+	return len(due), nil
+}
+
+// start gives the user the period's role, ending whatever role they held
+// at the branch until then. Temporary cover remembers that role so it can
+// be given back. If the user has left the company in the meantime the
+// period is cancelled instead.
+func (s *Service) start(ctx context.Context, period models.AssignmentPeriod) error {
+	var user models.User
+	err := s.db.Collection("users").FindOne(ctx, bson.M{"_id": period.UserID}).Decode(&user)
+	if err == mongo.ErrNoDocuments || (err == nil && (!user.IsStaff() || user.CompanyID != period.CompanyID)) {
+		_, err = s.db.Collection("staff_assignments").UpdateOne(ctx, bson.M{"_id": period.ID}, bson.M{"$set": bson.M{
+			"status":     models.AssignmentCancelled,
+			"updated_at": time.Now(),
+		}})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	previous := user.BranchRole(period.BranchID)
+	if err := s.setBranchRole(ctx, user, period.BranchID, period.Role, period.AssignedBy, period.EffectiveFrom); err != nil {
+		return err
+	}
+	if err := s.endActive(ctx, user.ID, period.BranchID, period.AssignedBy, period.EffectiveFrom); err != nil {
+		return err
+	}
+
+	set := bson.M{
+		"status":     models.AssignmentActive,
+		"updated_at": time.Now(),
+	}
+	if period.EffectiveTo != nil && previous != "" {
+		set["previous_role"] = previous
+	}
+	_, err = s.db.Collection("staff_assignments").UpdateOne(ctx, bson.M{"_id": period.ID}, bson.M{"$set": set})
+	return err
+}
+
+// end finishes an active period at the given time. If the user still holds
+// the period's role, the role from before it is given back, or the user
+// leaves the branch when there was none.
+func (s *Service) end(ctx context.Context, period models.AssignmentPeriod, by string, at time.Time) error {
+	_, err := s.db.Collection("staff_assignments").UpdateOne(ctx, bson.M{"_id": period.ID}, bson.M{"$set": bson.M{
+		"status":       models.AssignmentEnded,
+		"effective_to": at,
+		"ended_by":     by,
+		"updated_at":   time.Now(),
+	}})
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	err = s.db.Collection("users").FindOne(ctx, bson.M{"_id": period.UserID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.CompanyID != period.CompanyID || user.BranchRole(period.BranchID) != period.Role {
+		return nil
+	}
+
+	if err := s.setBranchRole(ctx, user, period.BranchID, period.PreviousRole, period.AssignedBy, at); err != nil {
+		return err
+	}
+	if period.PreviousRole == "" {
+		return nil
+	}
+	return s.insertActive(ctx, user.ID, user.CompanyID, period.BranchID, period.PreviousRole, period.AssignedBy, at)
+}
+
+// setBranchRole replaces the user's role at a branch office, removing it
+// when role is empty
+func (s *Service) setBranchRole(ctx context.Context, user models.User, branchID string, role models.BranchRole,
+	by string, at time.Time) error {
+	branchRoles := make([]models.BranchAssignment, 0, len(user.BranchRoles)+1)
+	for _, assignment := range user.BranchRoles {
+		if assignment.BranchID != branchID {
+			branchRoles = append(branchRoles, assignment)
+		}
+	}
+	if role != "" {
+		branchRoles = append(branchRoles, models.BranchAssignment{
+			BranchID:   branchID,
+			Role:       role,
+			AssignedAt: at,
+			AssignedBy: by,
+		})
+	}
+
+	_, err := s.db.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"branch_roles": branchRoles,
+		"updated_at":   time.Now(),
+	}})
+	return err
+}
+
+func (s *Service) endActive(ctx context.Context, userID, branchID, by string, at time.Time) error {
+	_, err := s.db.Collection("staff_assignments").UpdateMany(ctx, bson.M{
+		"user_id":   userID,
+		"branch_id": branchID,
+		"status":    models.AssignmentActive,
+	}, bson.M{"$set": bson.M{
+		"status":       models.AssignmentEnded,
+		"effective_to": at,
+		"ended_by":     by,
+		"updated_at":   time.Now(),
+	}})
+	return err
+}
+
+func (s *Service) insertActive(ctx context.Context, userID, companyID, branchID string, role models.BranchRole,
+	by string, at time.Time) error {
+	_, err := s.db.Collection("staff_assignments").InsertOne(ctx, models.AssignmentPeriod{
+		ID:            primitive.NewObjectID().Hex(),
+		UserID:        userID,
+		CompanyID:     companyID,
+		BranchID:      branchID,
+		Role:          role,
+		EffectiveFrom: at,
+		Status:        models.AssignmentActive,
+		AssignedBy:    by,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	})
+	return err
+}
+
+func (s *Service) find(ctx context.Context, filter bson.M) (models.AssignmentPeriod, error) {
+	var period models.AssignmentPeriod
+	err := s.db.Collection("staff_assignments").FindOne(ctx, filter).Decode(&period)
+	if err == mongo.ErrNoDocuments {
+		return period, ErrAssignmentNotFound
+	}
+	return period, err
+}
+
+// roleMap returns the user's role at each branch office they are staff at
+func roleMap(user models.User) map[string]models.BranchRole {
+	roles := make(map[string]models.BranchRole, len(user.BranchRoles))
+	for _, assignment := range user.BranchRoles {
+		if role := user.BranchRole(assignment.BranchID); role != "" {
+			roles[assignment.BranchID] = role
+		}
+	}
+	return roles
+}
@@ -0,0 +1,179 @@
+package staffing
+
+import (
+	"context"
+	"errors"
+	"loan/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrStaleUser = errors.New("staff member was changed while being transferred, try again")
+
+// workSource is a collection holding work assigned to staff members. Items
+// are tied to a branch office by their branch_id field.
+type workSource struct {
+	collection    string
+	assigneeField string
+	open          bson.M // Matches the items still to be worked on
+}
+
+// workSources lists where open work is assigned to staff, so a transfer
+// can hand it over to a colleague. A module that starts assigning work to
+// staff members adds its collection here.
+var workSources = []workSource{
+	// Loans are looked after by their officer until they are final
+	{
+		collection:    "loans",
+		assigneeField: "officer_id",
+		open: bson.M{"status": bson.M{"$nin": bson.A{
+			models.LoanRejected, models.LoanClosed, models.LoanWrittenOff,
+		}}},
+	},
+}
+
+// TransferredRoles works out a staff member's branch roles after a
+// transfer: the role moves from t.FromBranchID to t.ToBranchID, and the
+// roles at other branches are kept unless the transfer leaves the company
+// or revokeOthers is set. It returns the new roles and those revoked.
+func TransferredRoles(user models.User, t models.StaffTransfer, revokeOthers bool, by string,
+	at time.Time) (kept, revoked []models.BranchAssignment) {
+	kept = []models.BranchAssignment{}
+	revoked = []models.BranchAssignment{}
+	leaving := t.ToCompanyID != t.FromCompanyID
+
+	for _, assignment := range user.BranchRoles {
+		switch {
+		case assignment.BranchID == t.FromBranchID, assignment.BranchID == t.ToBranchID:
+			// Replaced by the role being moved
+		case leaving || revokeOthers:
+			revoked = append(revoked, assignment)
+		default:
+			kept = append(kept, assignment)
+		}
+	}
+	kept = append(kept, models.BranchAssignment{
+		BranchID:   t.ToBranchID,
+		Role:       t.Role,
+		AssignedAt: at,
+		AssignedBy: by,
+	})
+	return kept, revoked
+}
+
+// Transfer moves user as described by t, giving them branchRoles from
+// TransferredRoles. The user is only written if they are unchanged since
+// they were loaded, so a concurrent assignment or transfer makes this fail
+// with ErrStaleUser instead of being lost. Assignment periods are recorded,
+// scheduled assignments that no longer apply are cancelled, open work at
+// the old branch goes to t.HandoverTo and t is stored as the record of the
+// transfer, all in one transaction.
+func (s *Service) Transfer(ctx context.Context, user models.User, t *models.StaffTransfer,
+	branchRoles []models.BranchAssignment) (models.User, error) {
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer session.EndSession(ctx)
+
+	var updated models.User
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var err error
+		updated, err = s.transfer(sc, user, t, branchRoles)
+		return nil, err
+	})
+	return updated, err
+}
+
+// transfer makes the writes of Transfer. It may run more than once when
+// the transaction is retried.
+func (s *Service) transfer(ctx context.Context, user models.User, t *models.StaffTransfer,
+	branchRoles []models.BranchAssignment) (models.User, error) {
+	t.HandedOver = 0
+	set := bson.M{
+		"company_id":   t.ToCompanyID,
+		"branch_roles": branchRoles,
+		"updated_at":   t.TransferredAt,
+	}
+	if t.ToCompanyID != t.FromCompanyID {
+		// The membership moves to the new company with the same roles,
+		// unless the user already has one there
+		moved := models.User{Memberships: append([]models.CompanyMembership{}, user.Memberships...)}
+		if moved.Membership(t.ToCompanyID) == nil {
+			roles := []models.Role{}
+			for _, role := range user.RolesIn(t.FromCompanyID) {
+				if role != models.RoleSuperUser {
+					roles = append(roles, role)
+				}
+			}
+			moved.SetMembership(t.ToCompanyID, roles, t.TransferredBy)
+		}
+		moved.RemoveMembership(t.FromCompanyID)
+		set["memberships"] = moved.Memberships
+	}
+
+	var updated models.User
+	err := s.db.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":        user.ID,
+			"company_id": t.FromCompanyID,
+			"updated_at": user.UpdatedAt,
+		},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return updated, ErrStaleUser
+	}
+	if err != nil {
+		return updated, err
+	}
+
+	if err := s.RecordChange(ctx, user, updated, t.TransferredBy, t.TransferredAt); err != nil {
+		return updated, err
+	}
+
+	// Cover planned at the old branch, or anywhere in the old company, is
+	// off
+	stale := bson.M{"branch_id": t.FromBranchID}
+	if t.ToCompanyID != t.FromCompanyID {
+		stale = bson.M{"company_id": t.FromCompanyID}
+	}
+	stale["user_id"] = user.ID
+	stale["status"] = models.AssignmentScheduled
+	_, err = s.db.Collection("staff_assignments").UpdateMany(ctx, stale, bson.M{"$set": bson.M{
+		"status":     models.AssignmentCancelled,
+		"ended_by":   t.TransferredBy,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		return updated, err
+	}
+
+	if t.HandoverTo != "" {
+		for _, source := range workSources {
+			filter := bson.M{source.assigneeField: user.ID, "branch_id": t.FromBranchID}
+			for k, v := range source.open {
+				filter[k] = v
+			}
+			result, err := s.db.Collection(source.collection).UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+				source.assigneeField: t.HandoverTo,
+				"updated_at":         time.Now(),
+			}})
+			if err != nil {
+				return updated, err
+			}
+			t.HandedOver += int(result.ModifiedCount)
+		}
+	}
+
+	t.ID = primitive.NewObjectID().Hex()
+	if _, err := s.db.Collection("staff_transfers").InsertOne(ctx, t); err != nil {
+		return updated, err
+	}
+	return updated, nil
+}
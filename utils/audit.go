@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const auditResourcesKey = "audit_resource_ids"
+
+// AddAuditResource attaches a resource ID to the current request so the
+// audit trail can name resources that are not part of the route, such as
+// the ID of a newly created document.
+func AddAuditResource(c *gin.Context, id string) {
+	c.Set(auditResourcesKey, append(AuditResources(c), id))
+}
+
+// AuditResources returns the resource IDs attached to the current request
+func AuditResources(c *gin.Context) []string {
+	return c.GetStringSlice(auditResourcesKey)
+}
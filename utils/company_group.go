@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Most levels a company group may have, counting its top company. Walks up
+// or down a group stop after this many levels.
+const MaxGroupDepth = 20
+
+// CompanyGroupIDs returns companyID followed by the IDs of every subsidiary
+// below it
+func CompanyGroupIDs(ctx context.Context, db *mongo.Database, companyID string) ([]string, error) {
+	cursor, err := db.Collection("companies").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": companyID}}},
+		{{Key: "$graphLookup", Value: bson.M{
+			"from":             "companies",
+			"startWith":        "$_id",
+			"connectFromField": "_id",
+			"connectToField":   "parent_company_id",
+			"as":               "descendants",
+			"maxDepth":         MaxGroupDepth - 1,
+		}}},
+		{{Key: "$project", Value: bson.M{"descendant_ids": "$descendants._id"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		DescendantIDs []string `bson:"descendant_ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	ids := []string{companyID}
+	if len(groups) > 0 {
+		ids = append(ids, groups[0].DescendantIDs...)
+	}
+	return ids, nil
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cursor marks a position in a list sorted by created_at desc, _id desc
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+	Backward  bool      `json:"b,omitempty"`
+}
+
+// CursorKeyer is implemented by documents that can be paged by cursor
+type CursorKeyer interface {
+	CursorKey() (time.Time, string)
+}
+
+type CursorParams struct {
+	Cursor       *Cursor
+	Limit        int
+	IncludeTotal bool
+}
+
+type CursorPage struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	HasNext    bool   `json:"has_next"`
+	HasPrev    bool   `json:"has_prev"`
+	Limit      int    `json:"limit"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+func EncodeCursor(cursor Cursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(token string) (Cursor, error) {
+	var cursor Cursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return cursor, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// IsCursorRequest reports whether the client asked for cursor pagination.
+// An empty ?cursor= requests the first page.
+func IsCursorRequest(c *gin.Context) bool {
+	_, ok := c.GetQuery("cursor")
+	return ok
+}
+
+func GetCursorParams(c *gin.Context) (CursorParams, error) {
+	params := CursorParams{Limit: 10}
+
+	// Cursors encode a (created_at, _id) position, so no other order works
+	if c.Query("sort") != "" {
+		return params, errors.New("sort is not supported with cursor pagination")
+	}
+
+	if token := c.Query("cursor"); token != "" {
+		cursor, err := DecodeCursor(token)
+		if err != nil {
+			return params, err
+		}
+		params.Cursor = &cursor
+	}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			params.Limit = l
+		}
+	}
+	if params.Limit > MaxPageLimit {
+		params.Limit = MaxPageLimit
+	}
+	params.IncludeTotal, _ = strconv.ParseBool(c.Query("include_total"))
+
+	return params, nil
+}
+
+// FindCursorPage fetches one page of documents after (or, for a backward
+// cursor, before) the cursor position
+func FindCursorPage[T CursorKeyer](ctx context.Context, collection *mongo.Collection, filter bson.M, params CursorParams) ([]T, CursorPage, error) {
+	page := CursorPage{Limit: params.Limit}
+	backward := params.Cursor != nil && params.Cursor.Backward
+
+	query := filter
+	if params.Cursor != nil {
+		op := "$lt"
+		if backward {
+			op = "$gt"
+		}
+		query = bson.M{"$and": bson.A{
+			filter,
+			bson.M{"$or": bson.A{
+				bson.M{"created_at": bson.M{op: params.Cursor.CreatedAt}},
+				bson.M{"created_at": params.Cursor.CreatedAt, "_id": bson.M{op: params.Cursor.ID}},
+			}},
+		}}
+	}
+
+	direction := -1
+	if backward {
+		direction = 1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(params.Limit + 1))
+
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, page, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]T, 0, params.Limit+1)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, page, err
+	}
+
+	hasMore := len(items) > params.Limit
+	if hasMore {
+		items = items[:params.Limit]
+	}
+
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+		page.HasPrev = hasMore
+		page.HasNext = true
+	} else {
+		page.HasNext = hasMore
+		page.HasPrev = params.Cursor != nil
+	}
+
+	if len(items) > 0 {
+		if page.HasNext {
+			t, id := items[len(items)-1].CursorKey()
+			page.NextCursor = EncodeCursor(Cursor{CreatedAt: t, ID: id})
+		}
+		if page.HasPrev {
+			t, id := items[0].CursorKey()
+			page.PrevCursor = EncodeCursor(Cursor{CreatedAt: t, ID: id, Backward: true})
+		}
+	}
+
+	if params.IncludeTotal {
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, page, err
+		}
+		page.Total = &total
+	}
+
+	return items, page, nil
+}
+
+func SendCursorResponse(c *gin.Context, data interface{}, page CursorPage) {
+	c.JSON(200, gin.H{
+		"data":       data,
+		"pagination": page,
+	})
+}
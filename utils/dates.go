@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"errors"
+	"time"
+)
+
+// ParseDate parses a query parameter given either as RFC 3339 or as a plain
+// 2006-01-02 date. The second return value reports whether only a date was
+// given, so callers can treat an upper bound as inclusive of that day.
+func ParseDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, errors.New("invalid date: " + value)
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"loan/models"
+	"reflect"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Fields that are never recorded in a diff
+var diffIgnoredFields = map[string]bool{
+	"_id":        true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// Fields whose values are replaced before being recorded
+var diffRedactedFields = map[string]bool{
+	"password": true,
+}
+
+const redactedValue = "[REDACTED]"
+
+// DiffDocuments compares two documents by their bson representation and
+// returns one FieldChange per top-level field that differs. Either side may
+// be nil, which is how creates and deletes are recorded.
+func DiffDocuments(before, after interface{}) ([]models.FieldChange, error) {
+	beforeDoc, err := toBsonMap(before)
+	if err != nil {
+		return nil, err
+	}
+	afterDoc, err := toBsonMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for k := range beforeDoc {
+		keys[k] = true
+	}
+	for k := range afterDoc {
+		keys[k] = true
+	}
+
+	fields := make([]string, 0, len(keys))
+	for k := range keys {
+		if !diffIgnoredFields[k] {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := make([]models.FieldChange, 0)
+	for _, field := range fields {
+		oldValue, hadOld := beforeDoc[field]
+		newValue, hasNew := afterDoc[field]
+		if hadOld == hasNew && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		if diffRedactedFields[field] {
+			if hadOld {
+				oldValue = redactedValue
+			}
+			if hasNew {
+				newValue = redactedValue
+			}
+		}
+
+		changes = append(changes, models.FieldChange{
+			Field:  field,
+			Before: oldValue,
+			After:  newValue,
+		})
+	}
+
+	return changes, nil
+}
+
+func toBsonMap(doc interface{}) (bson.M, error) {
+	if doc == nil || (reflect.ValueOf(doc).Kind() == reflect.Ptr && reflect.ValueOf(doc).IsNil()) {
+		return bson.M{}, nil
+	}
+
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -31,3 +31,7 @@ func BadRequest(c *gin.Context, message string) {
 func InternalError(c *gin.Context, message string) {
 	HandleError(c, http.StatusInternalServerError, message)
 }
+
+func Forbidden(c *gin.Context, message string) {
+	HandleError(c, http.StatusForbidden, message)
+}
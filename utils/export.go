@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ExportFormat string
+
+const (
+	ExportCSV    ExportFormat = "text/csv"
+	ExportXLSX   ExportFormat = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	ExportNDJSON ExportFormat = "application/x-ndjson"
+)
+
+var exportExtensions = map[ExportFormat]string{
+	ExportCSV:    "csv",
+	ExportXLSX:   "xlsx",
+	ExportNDJSON: "ndjson",
+}
+
+// Flush the response to the client every this many rows
+const exportFlushEvery = 500
+
+// GetExportFormat reports whether the client asked for an export, either
+// through the Accept header or ?format=csv|xlsx|ndjson for plain links
+func GetExportFormat(c *gin.Context) (ExportFormat, bool) {
+	if format := c.Query("format"); format != "" {
+		for f, ext := range exportExtensions {
+			if ext == format {
+				return f, true
+			}
+		}
+	}
+
+	accept := c.GetHeader("Accept")
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.Split(part, ";")[0])
+		if _, ok := exportExtensions[ExportFormat(mediaType)]; ok {
+			return ExportFormat(mediaType), true
+		}
+		// JSON listed first wins
+		if mediaType == "application/json" {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// Export streams every document matching the filter straight from the Mongo
+// cursor in the requested format. Only the listed columns (bson field names,
+// with "id" for _id) are written, so sensitive fields never leave the server.
+func Export(c *gin.Context, format ExportFormat, collection *mongo.Collection, filter bson.M, sort bson.D,
+	name string, columns []string) {
+	cursor, err := collection.Find(c, filter, options.Find().SetSort(sort))
+	if err != nil {
+		InternalError(c, "Error exporting "+name)
+		return
+	}
+	defer cursor.Close(c)
+
+	filename := fmt.Sprintf("%s_%s.%s", name, time.Now().Format("20060102_150405"), exportExtensions[format])
+	c.Header("Content-Type", string(format))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(http.StatusOK)
+
+	var writeRow func(doc bson.M) error
+	var finish func() error
+
+	switch format {
+	case ExportCSV:
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write(columns); err != nil {
+			return
+		}
+		writeRow = func(doc bson.M) error {
+			record := make([]string, len(columns))
+			for i, col := range columns {
+				record[i] = formatExportValue(exportValue(doc, col))
+			}
+			return w.Write(record)
+		}
+		finish = func() error {
+			w.Flush()
+			return w.Error()
+		}
+
+	case ExportXLSX:
+		f := excelize.NewFile()
+		defer f.Close()
+		sheet := f.GetSheetName(0)
+		sw, err := f.NewStreamWriter(sheet)
+		if err != nil {
+			Error("Error creating xlsx export: " + err.Error())
+			return
+		}
+		header := make([]interface{}, len(columns))
+		for i, col := range columns {
+			header[i] = col
+		}
+		if err := sw.SetRow("A1", header); err != nil {
+			return
+		}
+		rowNum := 1
+		writeRow = func(doc bson.M) error {
+			rowNum++
+			cells := make([]interface{}, len(columns))
+			for i, col := range columns {
+				cells[i] = formatExportValue(exportValue(doc, col))
+			}
+			cell, _ := excelize.CoordinatesToCellName(1, rowNum)
+			return sw.SetRow(cell, cells)
+		}
+		// The workbook can only be written once it is complete
+		finish = func() error {
+			if err := sw.Flush(); err != nil {
+				return err
+			}
+			return f.Write(c.Writer)
+		}
+
+	default:
+		encoder := json.NewEncoder(c.Writer)
+		writeRow = func(doc bson.M) error {
+			record := make(map[string]interface{}, len(columns))
+			for _, col := range columns {
+				record[col] = exportValue(doc, col)
+			}
+			return encoder.Encode(record)
+		}
+		finish = func() error { return nil }
+	}
+
+	count := 0
+	for cursor.Next(c) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			Error("Error decoding export row: " + err.Error())
+			return
+		}
+		if err := writeRow(doc); err != nil {
+			// Client went away
+			return
+		}
+
+		count++
+		if format != ExportXLSX && count%exportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		Error("Error streaming export: " + err.Error())
+		return
+	}
+	if err := finish(); err != nil {
+		Error("Error finishing export: " + err.Error())
+	}
+}
+
+func exportValue(doc bson.M, column string) interface{} {
+	if column == "id" {
+		return doc["_id"]
+	}
+	return doc[column]
+}
+
+func formatExportValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339)
+	case primitive.A:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = formatExportValue(item)
+		}
+		return strings.Join(parts, ";")
+	case primitive.M, primitive.D:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprint(value)
+}
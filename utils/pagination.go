@@ -7,6 +7,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// MaxPageLimit caps the page size a client can request
+const MaxPageLimit = 100
+
 func GetPaginationParams(c *gin.Context) (page, limit int) {
 	page = 1
 	limit = 10
@@ -21,6 +24,9 @@ func GetPaginationParams(c *gin.Context) (page, limit int) {
 			limit = l
 		}
 	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
 	return
 }
 
@@ -0,0 +1,327 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+var ErrUnsupportedPatch = errors.New("PATCH body must be " + MergePatchContentType + " or " + JSONPatchContentType)
+
+// BindPatch applies the request body to current and decodes the result into
+// out, which must pass its binding rules. The body is an RFC 7396 merge
+// patch, where null removes a field, or an RFC 6902 JSON Patch when sent as
+// application/json-patch+json. Plain application/json is read as a merge
+// patch.
+func BindPatch(c *gin.Context, current, out interface{}) error {
+	contentType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	if err := remarshal(current, &doc); err != nil {
+		return err
+	}
+
+	switch contentType {
+	case MergePatchContentType, "application/json", "":
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return errors.New("Invalid merge patch")
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return errors.New("Merge patch must be a JSON object")
+		}
+		doc = MergePatch(doc, patch)
+
+	case JSONPatchContentType:
+		var ops []PatchOperation
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return errors.New("Invalid JSON patch")
+		}
+		if doc, err = ApplyJSONPatch(doc, ops); err != nil {
+			return err
+		}
+
+	default:
+		return ErrUnsupportedPatch
+	}
+
+	// Fields the document does not have are rejected rather than ignored
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("Invalid patched document: %v", err)
+	}
+
+	if err := binding.Validator.ValidateStruct(out); err != nil {
+		return errors.New(strings.Join(ValidationMessages(err, out), "; "))
+	}
+	return nil
+}
+
+// PatchFields turns a patched document into a $set, keyed by its json
+// field names, which match the stored field names
+func PatchFields(doc interface{}) (bson.M, error) {
+	var fields bson.M
+	err := remarshal(doc, &fields)
+	return fields, err
+}
+
+// MergePatch applies an RFC 7396 merge patch to target
+func MergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = MergePatch(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
+
+// PatchOperation is one RFC 6902 operation
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// ApplyJSONPatch applies RFC 6902 operations in order. If any operation
+// fails, including a failed test, the whole patch is rejected.
+func ApplyJSONPatch(doc interface{}, ops []PatchOperation) (interface{}, error) {
+	var err error
+	for i, op := range ops {
+		switch op.Op {
+		case "add":
+			doc, err = pointerAdd(doc, op.Path, op.Value)
+		case "remove":
+			doc, _, err = pointerRemove(doc, op.Path)
+		case "replace":
+			if doc, _, err = pointerRemove(doc, op.Path); err == nil {
+				doc, err = pointerAdd(doc, op.Path, op.Value)
+			}
+		case "move":
+			var value interface{}
+			if doc, value, err = pointerRemove(doc, op.From); err == nil {
+				doc, err = pointerAdd(doc, op.Path, value)
+			}
+		case "copy":
+			var value interface{}
+			if value, err = pointerGet(doc, op.From); err == nil {
+				var clone interface{}
+				if err = remarshal(value, &clone); err == nil {
+					doc, err = pointerAdd(doc, op.Path, clone)
+				}
+			}
+		case "test":
+			var value interface{}
+			if value, err = pointerGet(doc, op.Path); err == nil && !reflect.DeepEqual(value, op.Value) {
+				err = errors.New("test failed")
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("JSON patch operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// splitPointer splits a JSON pointer into unescaped reference tokens
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("path must start with /")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > length || (i == length && !allowEnd) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+func pointerGet(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, errors.New("path not found")
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, errors.New("path not found")
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd sets the value at pointer, inserting into arrays, and returns
+// the updated document
+func pointerAdd(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parentPath := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(doc, parentPath)
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node[:i], append([]interface{}{value}, node[i:]...)...)
+		return pointerSet(doc, parentPath, node)
+	}
+	return nil, errors.New("path not found")
+}
+
+// pointerSet overwrites the existing value at pointer. Arrays grow and
+// shrink by replacing the whole slice through this.
+func pointerSet(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := pointerGet(doc, pointer[:strings.LastIndex(pointer, "/")])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+		return doc, nil
+	}
+	return nil, errors.New("path not found")
+}
+
+// pointerRemove deletes the value at pointer and returns the updated
+// document and the removed value
+func pointerRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+
+	parentPath := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(doc, parentPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, errors.New("path not found")
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = pointerSet(doc, parentPath, node)
+		return doc, value, err
+	}
+	return nil, nil, errors.New("path not found")
+}
+
+// remarshal copies v into out through JSON
+func remarshal(v, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type FieldType int
+
+const (
+	FieldString FieldType = iota
+	FieldNumber
+	FieldBool
+	FieldTime
+)
+
+// QuerySpec is the whitelist of fields a list endpoint lets clients filter
+// and sort on. Keys are bson field names.
+type QuerySpec struct {
+	Filterable  map[string]FieldType
+	Sortable    map[string]bool
+	DefaultSort bson.D
+}
+
+// ListQuery is the parsed and validated form of ?filter[...] and ?sort=
+type ListQuery struct {
+	Filter bson.M
+	Sort   bson.D
+	// Sorted is true when the client supplied an explicit sort
+	Sorted bool
+}
+
+// Supported filter operators and the Mongo operators they translate to
+var filterOperators = map[string]string{
+	"eq":       "$eq",
+	"ne":       "$ne",
+	"gt":       "$gt",
+	"gte":      "$gte",
+	"lt":       "$lt",
+	"lte":      "$lte",
+	"in":       "$in",
+	"nin":      "$nin",
+	"contains": "$regex",
+}
+
+// filter[field] or filter[field][op]
+var filterKeyPattern = regexp.MustCompile(`^filter\[([a-z0-9_.]+)\](?:\[([a-z]+)\])?$`)
+
+// ParseListQuery validates the filter and sort query parameters against the
+// spec and translates them into bson. Any field or operator that is not
+// whitelisted is rejected.
+func ParseListQuery(c *gin.Context, spec QuerySpec) (ListQuery, error) {
+	query := ListQuery{Filter: bson.M{}, Sort: spec.DefaultSort}
+
+	for key, values := range c.Request.URL.Query() {
+		if !strings.HasPrefix(key, "filter") {
+			continue
+		}
+
+		match := filterKeyPattern.FindStringSubmatch(key)
+		if match == nil {
+			return query, fmt.Errorf("invalid filter parameter %q", key)
+		}
+		field, op := match[1], match[2]
+		if op == "" {
+			op = "eq"
+		}
+
+		fieldType, ok := spec.Filterable[field]
+		if !ok {
+			return query, fmt.Errorf("filtering on field %q is not allowed", field)
+		}
+		if _, ok := filterOperators[op]; !ok {
+			return query, fmt.Errorf("unknown filter operator %q", op)
+		}
+		if len(values) != 1 {
+			return query, fmt.Errorf("filter %q given more than once", key)
+		}
+
+		condition, err := buildCondition(op, fieldType, values[0])
+		if err != nil {
+			return query, fmt.Errorf("filter %q: %v", key, err)
+		}
+
+		existing, _ := query.Filter[field].(bson.M)
+		if existing == nil {
+			existing = bson.M{}
+		}
+		for k, v := range condition {
+			if _, dup := existing[k]; dup {
+				return query, fmt.Errorf("conflicting filters on field %q", field)
+			}
+			existing[k] = v
+		}
+		query.Filter[field] = existing
+	}
+
+	if sortStr := c.Query("sort"); sortStr != "" {
+		sort := bson.D{}
+		seen := make(map[string]bool)
+		for _, part := range strings.Split(sortStr, ",") {
+			part = strings.TrimSpace(part)
+			direction := 1
+			if strings.HasPrefix(part, "-") {
+				direction = -1
+				part = part[1:]
+			}
+			if !spec.Sortable[part] {
+				return query, fmt.Errorf("sorting on field %q is not allowed", part)
+			}
+			if seen[part] {
+				return query, fmt.Errorf("field %q appears more than once in sort", part)
+			}
+			seen[part] = true
+			sort = append(sort, bson.E{Key: part, Value: direction})
+		}
+		// Keep the order stable between pages
+		if !seen["_id"] {
+			sort = append(sort, bson.E{Key: "_id", Value: sort[len(sort)-1].Value})
+		}
+		query.Sort = sort
+		query.Sorted = true
+	}
+
+	return query, nil
+}
+
+// Merge restricts a base filter further by the client's filters. The base
+// filter always applies, so clients cannot widen the scope of a list.
+func (q ListQuery) Merge(base bson.M) bson.M {
+	if len(q.Filter) == 0 {
+		return base
+	}
+	return bson.M{"$and": bson.A{base, q.Filter}}
+}
+
+func buildCondition(op string, fieldType FieldType, raw string) (bson.M, error) {
+	mongoOp := filterOperators[op]
+
+	switch op {
+	case "in", "nin":
+		values := bson.A{}
+		for _, part := range strings.Split(raw, ",") {
+			v, err := parseFilterValue(fieldType, part)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return bson.M{mongoOp: values}, nil
+
+	case "contains":
+		if fieldType != FieldString {
+			return nil, fmt.Errorf("contains is only supported on text fields")
+		}
+		return bson.M{mongoOp: regexp.QuoteMeta(raw), "$options": "i"}, nil
+	}
+
+	// A plain date on a time field means the whole day
+	if fieldType == FieldTime {
+		t, dateOnly, err := ParseDate(raw)
+		if err != nil {
+			return nil, err
+		}
+		if dateOnly {
+			next := t.Add(24 * time.Hour)
+			switch op {
+			case "eq":
+				return bson.M{"$gte": t, "$lt": next}, nil
+			case "ne":
+				return bson.M{"$not": bson.M{"$gte": t, "$lt": next}}, nil
+			case "gt":
+				return bson.M{"$gte": next}, nil
+			case "lte":
+				return bson.M{"$lt": next}, nil
+			}
+		}
+		return bson.M{mongoOp: t}, nil
+	}
+
+	v, err := parseFilterValue(fieldType, raw)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{mongoOp: v}, nil
+}
+
+func parseFilterValue(fieldType FieldType, raw string) (interface{}, error) {
+	switch fieldType {
+	case FieldNumber:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", raw)
+		}
+		return v, nil
+	case FieldBool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", raw)
+		}
+		return v, nil
+	case FieldTime:
+		t, _, err := ParseDate(raw)
+		return t, err
+	default:
+		return raw, nil
+	}
+}
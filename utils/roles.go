@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"context"
+	"loan/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// HasRole reports whether the authenticated user holds the given role
+func HasRole(c *gin.Context, role models.Role) bool {
+	value, _ := c.Get("roles")
+	roles, _ := value.([]models.Role)
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// UserHasBranchRole reports whether a user holds one of roles at a branch
+// office. Users that do not exist hold no roles.
+func UserHasBranchRole(ctx context.Context, db *mongo.Database, userID, branchID string, roles ...models.BranchRole) (bool, error) {
+	var user models.User
+	err := db.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.HasBranchRole(branchID, roles...), nil
+}
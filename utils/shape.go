@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Expansion describes a related collection that can be embedded in a
+// response with ?expand=. It is translated into a $lookup stage whose
+// pipeline matches Match against the variables bound by Let.
+type Expansion struct {
+	From    string
+	Let     bson.M
+	Match   bson.M
+	Project bson.M
+	// Single embeds the first match as an object instead of an array
+	Single bool
+}
+
+// ShapeSpec is the whitelist of fields (by JSON name) and expansions a
+// resource supports. Hidden fields are stripped from every shaped document.
+type ShapeSpec struct {
+	Fields     map[string]bool
+	Expansions map[string]Expansion
+	Hidden     []string
+}
+
+// Shape is the parsed form of ?fields= and ?expand=
+type Shape struct {
+	Fields []string
+	Expand []string
+	spec   ShapeSpec
+}
+
+func ParseShape(c *gin.Context, spec ShapeSpec) (Shape, error) {
+	shape := Shape{spec: spec}
+
+	if fieldsStr := c.Query("fields"); fieldsStr != "" {
+		for _, field := range strings.Split(fieldsStr, ",") {
+			field = strings.TrimSpace(field)
+			if !spec.Fields[field] {
+				return shape, fmt.Errorf("unknown field %q", field)
+			}
+			shape.Fields = append(shape.Fields, field)
+		}
+	}
+
+	if expandStr := c.Query("expand"); expandStr != "" {
+		for _, name := range strings.Split(expandStr, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := spec.Expansions[name]; !ok {
+				return shape, fmt.Errorf("unknown expansion %q", name)
+			}
+			shape.Expand = append(shape.Expand, name)
+		}
+	}
+
+	return shape, nil
+}
+
+func (s Shape) Empty() bool {
+	return len(s.Fields) == 0 && len(s.Expand) == 0
+}
+
+// pipeline builds the stages that run after the documents are matched
+func (s Shape) pipeline() bson.A {
+	stages := bson.A{}
+
+	for _, name := range s.Expand {
+		exp := s.spec.Expansions[name]
+		lookupPipeline := bson.A{bson.M{"$match": bson.M{"$expr": exp.Match}}}
+		if exp.Project != nil {
+			lookupPipeline = append(lookupPipeline, bson.M{"$project": exp.Project})
+		}
+		stages = append(stages, bson.M{"$lookup": bson.M{
+			"from":     exp.From,
+			"let":      exp.Let,
+			"pipeline": lookupPipeline,
+			"as":       name,
+		}})
+		if exp.Single {
+			stages = append(stages, bson.M{"$addFields": bson.M{
+				name: bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$" + name, 0}}, nil}},
+			}})
+		}
+	}
+
+	if len(s.Fields) > 0 {
+		project := bson.M{"_id": 1}
+		for _, field := range s.Fields {
+			if field != "id" {
+				project[field] = 1
+			}
+		}
+		for _, name := range s.Expand {
+			project[name] = 1
+		}
+		stages = append(stages, bson.M{"$project": project})
+	} else if len(s.spec.Hidden) > 0 {
+		project := bson.M{}
+		for _, field := range s.spec.Hidden {
+			project[field] = 0
+		}
+		stages = append(stages, bson.M{"$project": project})
+	}
+
+	return stages
+}
+
+// ApplyShape re-reads the given documents through a $lookup/$project
+// aggregation when the client asked for sparse fields or expansions, and
+// returns them in their original order. Without a shape the items are
+// returned unchanged.
+func ApplyShape[T CursorKeyer](ctx context.Context, collection *mongo.Collection, shape Shape, items []T) (interface{}, error) {
+	if shape.Empty() {
+		return items, nil
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		_, ids[i] = item.CursorKey()
+	}
+
+	pipeline := append(bson.A{bson.M{"$match": bson.M{"_id": bson.M{"$in": ids}}}}, shape.pipeline()...)
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]bson.M, len(docs))
+	for _, doc := range docs {
+		if id, ok := doc["_id"].(string); ok {
+			byID[id] = doc
+		}
+	}
+
+	shaped := make([]bson.M, 0, len(ids))
+	for _, id := range ids {
+		if doc, ok := byID[id]; ok {
+			shaped = append(shaped, renameIDs(doc).(bson.M))
+		}
+	}
+	return shaped, nil
+}
+
+// ApplyShapeOne is ApplyShape for a single document
+func ApplyShapeOne[T CursorKeyer](ctx context.Context, collection *mongo.Collection, shape Shape, item T) (interface{}, error) {
+	if shape.Empty() {
+		return item, nil
+	}
+
+	shaped, err := ApplyShape(ctx, collection, shape, []T{item})
+	if err != nil {
+		return nil, err
+	}
+	if docs := shaped.([]bson.M); len(docs) > 0 {
+		return docs[0], nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// renameIDs exposes _id as id, matching the JSON tags on the models
+func renameIDs(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		if id, ok := v["_id"]; ok {
+			delete(v, "_id")
+			v["id"] = id
+		}
+		for k, inner := range v {
+			v[k] = renameIDs(inner)
+		}
+		return v
+	case bson.A:
+		for i, inner := range v {
+			v[i] = renameIDs(inner)
+		}
+		return v
+	}
+	return value
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/xuri/excelize/v2"
+)
+
+// ReadSpreadsheet reads every row of a .csv file or the first sheet of an
+// .xlsx file. The first row is the header.
+func ReadSpreadsheet(r io.Reader, filename string) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		return reader.ReadAll()
+
+	case ".xlsx":
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, errors.New("workbook has no sheets")
+		}
+		return f.GetRows(sheets[0])
+	}
+
+	return nil, errors.New("unsupported file type, expected .csv or .xlsx")
+}
+
+// RowDecoder maps spreadsheet columns onto a struct by its json tags
+type RowDecoder struct {
+	columns []int // struct field index for each column
+	typ     reflect.Type
+}
+
+// NewRowDecoder checks the header against the json tags of out, which must
+// be a pointer to a struct. Unknown columns are rejected so a misspelt
+// header is not silently ignored.
+func NewRowDecoder(header []string, out interface{}) (*RowDecoder, error) {
+	typ := reflect.TypeOf(out).Elem()
+
+	byTag := make(map[string]int)
+	for i := 0; i < typ.NumField(); i++ {
+		tag := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if tag != "" && tag != "-" {
+			byTag[tag] = i
+		}
+	}
+
+	decoder := &RowDecoder{typ: typ, columns: make([]int, len(header))}
+	for col, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		index, ok := byTag[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		decoder.columns[col] = index
+	}
+	return decoder, nil
+}
+
+// Decode fills out from one row. String slice fields take a comma or
+// semicolon separated list.
+func (d *RowDecoder) Decode(row []string, out interface{}) error {
+	value := reflect.ValueOf(out).Elem()
+
+	for col, cell := range row {
+		if col >= len(d.columns) {
+			return fmt.Errorf("row has more cells than the header")
+		}
+		cell = strings.TrimSpace(cell)
+		field := value.Field(d.columns[col])
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(cell)
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("column %d cannot be imported", col+1)
+			}
+			parts := strings.FieldsFunc(cell, func(r rune) bool { return r == ',' || r == ';' })
+			slice := reflect.MakeSlice(field.Type(), 0, len(parts))
+			for _, part := range parts {
+				slice = reflect.Append(slice, reflect.ValueOf(strings.TrimSpace(part)).Convert(field.Type().Elem()))
+			}
+			field.Set(slice)
+		default:
+			return fmt.Errorf("column %d cannot be imported", col+1)
+		}
+	}
+	return nil
+}
+
+// ValidationMessages turns binding errors on obj into one readable message
+// per field, naming fields by their json tag
+func ValidationMessages(err error, obj interface{}) []string {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return []string{err.Error()}
+	}
+
+	typ := reflect.TypeOf(obj)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	messages := make([]string, 0, len(errs))
+	for _, e := range errs {
+		name := e.StructField()
+		if f, ok := typ.FieldByName(e.StructField()); ok {
+			if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" {
+				name = tag
+			}
+		}
+
+		if e.Param() != "" {
+			messages = append(messages, fmt.Sprintf("%s failed %s=%s", name, e.Tag(), e.Param()))
+		} else {
+			messages = append(messages, fmt.Sprintf("%s failed %s", name, e.Tag()))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// taxIDFormats validates normalized tax IDs per ISO 3166 country code.
+// Countries without an entry accept any 4-20 letters and digits.
+var taxIDFormats = map[string]func(string) bool{
+	// Employer Identification Number
+	"US": regexp.MustCompile(`^\d{9}$`).MatchString,
+	// VAT registration number, standard or branch traders
+	"GB": regexp.MustCompile(`^(GB)?(\d{9}|\d{12})$`).MatchString,
+	// Umsatzsteuer-Identifikationsnummer
+	"DE": regexp.MustCompile(`^DE\d{9}$`).MatchString,
+	// SIREN or intra-community VAT number
+	"FR": func(id string) bool {
+		if frenchVAT.MatchString(id) {
+			return luhnValid(id[4:])
+		}
+		return nineDigits.MatchString(id) && luhnValid(id)
+	},
+	// Permanent Account Number or GSTIN
+	"IN": regexp.MustCompile(`^([A-Z]{5}\d{4}[A-Z]|\d{2}[A-Z]{5}\d{4}[A-Z][1-9A-Z]Z[0-9A-Z])$`).MatchString,
+	// Cadastro Nacional da Pessoa Jurídica
+	"BR": cnpjValid,
+	// Registro Federal de Contribuyentes for companies
+	"MX": regexp.MustCompile(`^[A-Z&Ñ]{3}\d{6}[A-Z0-9]{3}$`).MatchString,
+	// Nomor Pokok Wajib Pajak, 15 or 16 digits
+	"ID": regexp.MustCompile(`^\d{15,16}$`).MatchString,
+}
+
+var (
+	genericTaxID   = regexp.MustCompile(`^[0-9A-Z]{4,20}$`)
+	frenchVAT      = regexp.MustCompile(`^FR[0-9A-Z]{2}\d{9}$`)
+	nineDigits     = regexp.MustCompile(`^\d{9}$`)
+	fourteenDigits = regexp.MustCompile(`^\d{14}$`)
+)
+
+// NormalizeTaxID uppercases a tax ID and drops the spaces, dots, dashes
+// and slashes people type for readability, so equal IDs compare equal
+func NormalizeTaxID(taxID string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '.', '-', '/':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(taxID)))
+}
+
+// ValidateTaxID checks a tax ID against the format used in country
+func ValidateTaxID(country, taxID string) error {
+	normalized := NormalizeTaxID(taxID)
+	valid, ok := taxIDFormats[strings.ToUpper(country)]
+	if !ok {
+		valid = genericTaxID.MatchString
+	}
+	if !valid(normalized) {
+		return fmt.Errorf("tax_id %q is not valid for country %s", taxID, country)
+	}
+	return nil
+}
+
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func cnpjValid(id string) bool {
+	if !fourteenDigits.MatchString(id) || strings.Count(id, id[:1]) == 14 {
+		return false
+	}
+
+	checkDigit := func(digits string, weights []int) byte {
+		sum := 0
+		for i, w := range weights {
+			sum += int(digits[i]-'0') * w
+		}
+		r := sum % 11
+		if r < 2 {
+			return '0'
+		}
+		return byte('0' + 11 - r)
+	}
+
+	first := checkDigit(id, []int{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2})
+	second := checkDigit(id, []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2})
+	return id[12] == first && id[13] == second
+}
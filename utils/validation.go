@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"loan/models"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// TaxIdentified is implemented by request types that carry a tax ID and
+// the country whose format it must follow
+type TaxIdentified interface {
+	TaxIdentity() (country, taxID string)
+}
+
+// RegisterValidators adds the custom binding tags used by the models:
+//
+//	business_type        one of the configured business types
+//	company_type         one of the models.CompanyType values
+//	branch_role          one of the models.BranchRole values
+//	customer_type        one of the models.CustomerType values
+//	interest_method      one of the models.InterestMethod values
+//	repayment_frequency  one of the models.RepaymentFrequency values
+//
+// checks the tax ID of company and customer requests against their
+// country, checks that customers have the fields their type requires,
+// checks that percentage fees are at most 100, and checks that GeoJSON
+// points are in range.
+func RegisterValidators(businessTypes []string) error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return nil
+	}
+
+	allowed := make(map[string]bool, len(businessTypes))
+	for _, t := range businessTypes {
+		allowed[t] = true
+	}
+	if err := v.RegisterValidation("business_type", func(fl validator.FieldLevel) bool {
+		return allowed[fl.Field().String()]
+	}); err != nil {
+		return err
+	}
+
+	if err := v.RegisterValidation("company_type", func(fl validator.FieldLevel) bool {
+		return models.CompanyType(fl.Field().String()).Valid()
+	}); err != nil {
+		return err
+	}
+
+	if err := v.RegisterValidation("branch_role", func(fl validator.FieldLevel) bool {
+		return models.BranchRole(fl.Field().String()).Valid()
+	}); err != nil {
+		return err
+	}
+
+	if err := v.RegisterValidation("customer_type", func(fl validator.FieldLevel) bool {
+		return models.CustomerType(fl.Field().String()).Valid()
+	}); err != nil {
+		return err
+	}
+
+	if err := v.RegisterValidation("interest_method", func(fl validator.FieldLevel) bool {
+		return models.InterestMethod(fl.Field().String()).Valid()
+	}); err != nil {
+		return err
+	}
+
+	if err := v.RegisterValidation("repayment_frequency", func(fl validator.FieldLevel) bool {
+		return models.RepaymentFrequency(fl.Field().String()).Valid()
+	}); err != nil {
+		return err
+	}
+
+	v.RegisterStructValidation(func(sl validator.StructLevel) {
+		req, ok := sl.Current().Interface().(TaxIdentified)
+		if !ok {
+			return
+		}
+		country, taxID := req.TaxIdentity()
+		if country == "" || taxID == "" {
+			return
+		}
+		if err := ValidateTaxID(country, taxID); err != nil {
+			sl.ReportError(taxID, "TaxID", "TaxID", "tax_id", strings.ToUpper(country))
+		}
+	}, models.CreateCompanyRequest{}, models.CompanyPatch{})
+
+	v.RegisterStructValidation(func(sl validator.StructLevel) {
+		details := sl.Current().Interface().(models.CustomerDetails)
+		for _, field := range details.MissingFields() {
+			sl.ReportError("", field, field, "required", string(details.Type))
+		}
+		country, taxID := details.TaxIdentity()
+		if country != "" && taxID != "" {
+			if err := ValidateTaxID(country, taxID); err != nil {
+				sl.ReportError(taxID, "TaxID", "TaxID", "tax_id", strings.ToUpper(country))
+			}
+		}
+	}, models.CustomerDetails{})
+
+	v.RegisterStructValidation(func(sl validator.StructLevel) {
+		fee := sl.Current().Interface().(models.ProductFee)
+		if fee.Calculation == "percentage" && fee.Amount > 100 {
+			sl.ReportError(fee.Amount, "Amount", "amount", "lte", "100")
+		}
+	}, models.ProductFee{})
+
+	v.RegisterStructValidation(func(sl validator.StructLevel) {
+		point := sl.Current().Interface().(models.GeoPoint)
+		if !point.Valid() {
+			sl.ReportError(point.Coordinates, "Coordinates", "coordinates", "geo_point", "")
+		}
+	}, models.GeoPoint{})
+
+	return nil
+}